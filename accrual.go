@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// RoundingMode decides when tokens earned part-way through an interval can
+// be spent. Between refills a bucket earns rate tokens per interval
+// continuously; the mode converts that fractional balance to whole tokens.
+// Whatever is released early is held back from the next refill, so every
+// mode hands out exactly rate tokens per interval. Only RoundFloor never
+// releases a token before it has been fully earned, so it is the only mode
+// that strictly guarantees the rate is never exceeded over any window;
+// RoundNearest and RoundCeil trade that for lower latency.
+type RoundingMode int
+
+const (
+	RoundFloor RoundingMode = iota
+	RoundNearest
+	RoundCeil
+)
+
+func WithRoundingMode(mode RoundingMode) Option {
+	return func(tb *TokenBucket) {
+		tb.rounding = mode
+	}
+}
+
+func (m RoundingMode) round(x float64) int64 {
+	switch m {
+	case RoundNearest:
+		return int64(math.Round(x))
+	case RoundCeil:
+		return int64(math.Ceil(x))
+	default:
+		return int64(math.Floor(x))
+	}
+}
+
+// earnedAt is how far into a refill period the r-th of rate tokens is
+// released, the inverse of round.
+func (m RoundingMode) earnedAt(r, rate int64, interval time.Duration) time.Duration {
+	var units float64
+	switch m {
+	case RoundNearest:
+		units = float64(r) - 0.5
+	case RoundCeil:
+		units = float64(r - 1)
+	default:
+		units = float64(r)
+	}
+	d := time.Duration(units * float64(interval) / float64(rate))
+	if m == RoundCeil {
+		// ceil(x) reaches r as soon as x exceeds r-1.
+		d++
+	}
+	return d
+}
+
+// accrueLocked releases the tokens earned since the last refill that the
+// rounding mode already counts as whole. The refill only adds what has not
+// been released this way. phase is a fraction of a token carried over from
+// before a Reconfigure, shifting every release by the same amount.
+func (tb *TokenBucket) accrueLocked() {
+	tb.resetIfDueLocked()
+	tb.decayLocked()
+	if tb.curve != nil {
+		tb.accrueCurveLocked()
+		return
+	}
+	if tb.rate <= 0 {
+		return
+	}
+	elapsed := tb.clock.Now().Sub(tb.lastRefill)
+	if elapsed <= 0 {
+		return
+	}
+
+	rate := float64(tb.rate) * tb.rateScaleLocked()
+	earned := rate*float64(elapsed)/float64(tb.interval) + tb.phase
+	if earned > rate {
+		earned = rate
+	}
+	if whole := tb.rounding.round(earned); whole > tb.credited {
+		n := whole - tb.credited
+		tb.credited = whole
+		tb.creditLocked(n)
+	}
+}
+
+// phaseOffset is how much earlier than earnedAt each release happens because
+// of the carried phase.
+func (tb *TokenBucket) phaseOffset() time.Duration {
+	return time.Duration(tb.phase * float64(tb.interval) / float64(tb.rate))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoundingModesReleaseTokensAtDifferentPoints(t *testing.T) {
+	tests := []struct {
+		mode RoundingMode
+		want []bool
+	}{
+		{RoundFloor, []bool{false, false, true}},
+		{RoundNearest, []bool{false, true, false}},
+		{RoundCeil, []bool{true, false, false}},
+	}
+	// Two tokens per 10s: 0.2, 0.5 and 1 token earned at these points.
+	at := []time.Duration{time.Second, 2500 * time.Millisecond, 5 * time.Second}
+
+	for _, tt := range tests {
+		clock := NewManualClock(time.Unix(0, 0))
+		tb := NewTokenBucket(2, 2, 10*time.Second, WithClock(clock), WithRoundingMode(tt.mode))
+		tb.AllowN(2)
+
+		var elapsed time.Duration
+		for i, d := range at {
+			clock.Advance(d - elapsed)
+			elapsed = d
+			if got := tb.Allow(); got != tt.want[i] {
+				t.Errorf("mode %d at %v: Allow() = %v, want %v", tt.mode, d, got, tt.want[i])
+			}
+		}
+		tb.Stop()
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync/atomic"
+)
+
+// nextBucketID gives every bucket a stable identity for lock ordering.
+var nextBucketID atomic.Uint64
+
+// AcquireAll waits for one token from each of buckets. They are always taken
+// in the same order, whatever order they are passed in, so call sites
+// blocking on overlapping sets of buckets cannot deadlock each other. If ctx
+// is done or a bucket fails partway, the tokens already taken are refunded
+// and the error returned.
+//
+// Tokens stay consumed once AcquireAll succeeds; release does nothing and
+// exists so callers can defer it like other acquire/release pairs.
+func AcquireAll(ctx context.Context, buckets ...*TokenBucket) (release func(), err error) {
+	ordered := slices.Clone(buckets)
+	slices.SortStableFunc(ordered, func(a, b *TokenBucket) int {
+		return cmp.Compare(a.id, b.id)
+	})
+
+	for i, tb := range ordered {
+		if err := tb.WaitContext(ctx); err != nil {
+			for _, got := range ordered[:i] {
+				got.Refund(1)
+			}
+			return nil, err
+		}
+	}
+	return func() {}, nil
+}
+
+// AllowFirstAvailable consumes n tokens from the first of buckets, in the
+// order given, that has them, and returns its index. Each bucket is charged
+// or not in a single AllowN, so at most one is ever drawn from. It returns
+// -1 and false when none can cover n.
+func AllowFirstAvailable(n int64, buckets ...*TokenBucket) (idx int, ok bool) {
+	for i, tb := range buckets {
+		if tb.AllowN(n) {
+			return i, true
+		}
+	}
+	return -1, false
+}
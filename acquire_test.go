@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcquireAllRollsBackOnCancel(t *testing.T) {
+	a := NewTokenBucket(1, 2, time.Hour)
+	defer a.Stop()
+	b := NewTokenBucket(1, 2, time.Hour)
+	defer b.Stop()
+	empty := NewTokenBucket(1, 1, time.Hour)
+	defer empty.Stop()
+	empty.Allow()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := AcquireAll(ctx, empty, b, a)
+		done <- err
+	}()
+	// Taken in creation order, so a and b are held while empty is waited on.
+	waitForQueue(t, empty, 1)
+	if a.AvailableTokens() != 1 || b.AvailableTokens() != 1 {
+		t.Fatal("a and b not taken before waiting on the empty bucket")
+	}
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("AcquireAll = %v, want context.Canceled", err)
+	}
+	if a.AvailableTokens() != 2 || b.AvailableTokens() != 2 {
+		t.Fatalf("tokens after rollback: a=%d b=%d, want 2 each", a.AvailableTokens(), b.AvailableTokens())
+	}
+}
+
+func TestAcquireAllTakesInCanonicalOrder(t *testing.T) {
+	a := NewTokenBucket(1, 1, time.Hour)
+	defer a.Stop()
+	b := NewTokenBucket(1, 1, time.Hour)
+	defer b.Stop()
+	a.Allow()
+	b.Allow()
+
+	done := make(chan error, 2)
+	for _, order := range [][]*TokenBucket{{a, b}, {b, a}} {
+		go func() {
+			_, err := AcquireAll(context.Background(), order...)
+			done <- err
+		}()
+	}
+	// Both callers queue on a, neither holding b.
+	waitForQueue(t, a, 2)
+	if got := queued(b); got != 0 {
+		t.Fatalf("%d callers queued on b, want 0", got)
+	}
+
+	a.Refund(1)
+	b.Refund(1)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	a.Refund(1)
+	b.Refund(1)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllowFirstAvailableFallsBackInOrder(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	primary := NewTokenBucket(1, 3, time.Hour, WithClock(clock))
+	defer primary.Stop()
+	secondary := NewTokenBucket(1, 5, time.Hour, WithClock(clock))
+	defer secondary.Stop()
+
+	for i, want := range []struct {
+		idx                int
+		ok                 bool
+		primary, secondary int64
+	}{
+		{0, true, 1, 5},
+		// The primary has 1 left, too few for 2; the secondary pays.
+		{1, true, 1, 3},
+		{1, true, 1, 1},
+		// Neither can cover it, and neither is charged.
+		{-1, false, 1, 1},
+	} {
+		idx, ok := AllowFirstAvailable(2, primary, secondary)
+		if idx != want.idx || ok != want.ok {
+			t.Fatalf("call %d: (%d, %v), want (%d, %v)", i, idx, ok, want.idx, want.ok)
+		}
+		if p, s := primary.AvailableTokens(), secondary.AvailableTokens(); p != want.primary || s != want.secondary {
+			t.Fatalf("call %d: primary %d, secondary %d tokens; want %d, %d", i, p, s, want.primary, want.secondary)
+		}
+	}
+
+	// Once the primary refills it is preferred again.
+	clock.Advance(time.Hour)
+	if idx, ok := AllowFirstAvailable(2, primary, secondary); idx != 0 || !ok {
+		t.Fatalf("after a refill: (%d, %v), want (0, true)", idx, ok)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// AdaptivePolicy configures WithAdaptiveCapacity.
+type AdaptivePolicy struct {
+	Window   time.Duration // length of each observation window
+	DenyRate float64       // share of a window's decisions denied, 0 to 1, that makes it a burst
+	Step     int64         // capacity added per burst window, and given back per calm one
+	MaxExtra int64         // most capacity ever added above the baseline
+	// Apply changes the capacity. Without it the bucket only recommends:
+	// the capacity it would have set is passed to OnChange, or logged.
+	Apply bool
+	// OnChange, if set, is called in its own goroutine with each new
+	// capacity, applied or recommended.
+	OnChange func(capacity int64)
+}
+
+// WithAdaptiveCapacity closes a loop from rejections to capacity, for spiky
+// workloads whose bursts outgrow the configured capacity. At the first
+// refill after each Window has passed, the bucket compares the share of
+// decisions denied during it with DenyRate. A burst window raises the
+// capacity by Step, a calm one (including one with no traffic) lowers it by
+// Step, and it never leaves the range from the baseline to baseline plus
+// MaxExtra, so a flood can raise it by MaxExtra at most and a quiet spell
+// always brings it back. Only the capacity moves: the rate is unchanged, and
+// the extra room fills from refills between spikes. Lowering it discards
+// tokens above the new capacity.
+//
+// The baseline is the constructed capacity, or whatever Reconfigure last
+// set, which also drops any extra. Config reports the current capacity,
+// extra included. A zero Window, Step or MaxExtra leaves the loop off.
+func WithAdaptiveCapacity(policy AdaptivePolicy) Option {
+	return func(tb *TokenBucket) {
+		if policy.Window > 0 && policy.Step > 0 && policy.MaxExtra > 0 {
+			tb.adaptive = &policy
+		}
+	}
+}
+
+// adaptLocked closes the observation window once it has passed.
+func (tb *TokenBucket) adaptLocked() {
+	p := tb.adaptive
+	now := tb.clock.Now()
+	if tb.adaptMark.Until.IsZero() {
+		tb.adaptMark = UsageSnapshot{Until: tb.createdAt}
+	}
+	if now.Sub(tb.adaptMark.Until) < p.Window {
+		return
+	}
+	allowed, denied := tb.allowed-tb.adaptMark.Allowed, tb.denied-tb.adaptMark.Denied
+	tb.adaptMark = UsageSnapshot{Until: now, Allowed: tb.allowed, Denied: tb.denied}
+
+	extra := tb.adaptExtra - p.Step
+	var denyRate float64
+	if denied > 0 {
+		denyRate = float64(denied) / float64(allowed+denied)
+	}
+	if denied > 0 && denyRate >= p.DenyRate {
+		extra = tb.adaptExtra + p.Step
+	}
+	extra = min(max(extra, 0), p.MaxExtra)
+	if extra == tb.adaptExtra {
+		return
+	}
+	baseline := tb.capacity
+	if p.Apply {
+		baseline -= tb.adaptExtra
+	}
+	capacity := baseline + extra
+	tb.adaptExtra = extra
+	if p.Apply {
+		tb.capacity = capacity
+		tb.tokens = min(tb.tokens, capacity)
+		tb.publishLocked()
+	}
+	switch {
+	case p.OnChange != nil:
+		go p.OnChange(capacity)
+	case !p.Apply:
+		log.Printf("Token bucket %q: capacity %d recommended, %.0f%% denied over the last %s\n", tb.name, capacity, 100*denyRate, p.Window)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveCapacityRisesInBurstsAndDecaysBack(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	policy := AdaptivePolicy{Window: 5 * time.Second, DenyRate: 0.5, Step: 2, MaxExtra: 4, Apply: true}
+	tb := NewTokenBucket(1, 2, time.Second, WithClock(clock), WithAdaptiveCapacity(policy), withoutRefillLog())
+	defer tb.Stop()
+
+	// window runs one observation window, making three requests a second
+	// for one token each if busy.
+	window := func(busy bool) int64 {
+		for i := 0; i < 5; i++ {
+			if busy {
+				tb.Allow()
+				tb.Allow()
+				tb.Allow()
+			}
+			clock.Advance(time.Second)
+		}
+		return tb.Config().Capacity
+	}
+
+	for i, want := range []int64{4, 6, 6} {
+		if got := window(true); got != want {
+			t.Fatalf("burst window %d: capacity %d, want %d", i+1, got, want)
+		}
+	}
+	for i, want := range []int64{4, 2, 2} {
+		if got := window(false); got != want {
+			t.Fatalf("calm window %d: capacity %d, want %d", i+1, got, want)
+		}
+	}
+	if got := tb.AvailableTokens(); got != 2 {
+		t.Fatalf("tokens = %d after decaying back, want no more than the baseline 2", got)
+	}
+
+	window(true)
+	tb.Reconfigure(Config{Rate: 1, Capacity: 3, Interval: time.Second})
+	if got := window(false); got != 3 {
+		t.Fatalf("after Reconfigure: capacity %d, want the new baseline 3", got)
+	}
+}
+
+func TestAdaptiveCapacityRecommendsWithoutApply(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	recommended := make(chan int64, 10)
+	policy := AdaptivePolicy{Window: 2 * time.Second, DenyRate: 0.5, Step: 3, MaxExtra: 3,
+		OnChange: func(capacity int64) { recommended <- capacity }}
+	tb := NewTokenBucket(1, 1, time.Second, WithClock(clock), WithAdaptiveCapacity(policy), withoutRefillLog())
+	defer tb.Stop()
+
+	for i := 0; i < 2; i++ {
+		tb.AllowN(1)
+		tb.AllowN(1)
+		clock.Advance(time.Second)
+	}
+	if got := <-recommended; got != 4 {
+		t.Fatalf("recommended capacity %d, want 4", got)
+	}
+	if got := tb.Config().Capacity; got != 1 {
+		t.Fatalf("capacity %d without Apply, want it left at 1", got)
+	}
+	clock.Advance(2 * time.Second)
+	if got := <-recommended; got != 1 {
+		t.Fatalf("recommended capacity %d after a calm window, want back to 1", got)
+	}
+}
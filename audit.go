@@ -0,0 +1,43 @@
+package main
+
+// WithAuditSink streams every allow/deny decision to ch, for processing
+// elsewhere such as shipping it to a security pipeline. Sends never block:
+// when ch is full the decision is dropped and counted in AuditDropped, so a
+// slow consumer costs it events rather than stalling callers. The channel
+// is never closed by the bucket.
+func WithAuditSink(ch chan<- Decision) Option {
+	return func(tb *TokenBucket) {
+		tb.auditSink = ch
+	}
+}
+
+// AuditDropped is how many decisions WithAuditSink has dropped because its
+// channel was full.
+func (tb *TokenBucket) AuditDropped() int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.auditDropped
+}
+
+// decisionLocked describes a decision for n tokens that has just been made.
+// reason is what a denial was counted under.
+func (tb *TokenBucket) decisionLocked(n int64, allowed bool, reason DenyReason) Decision {
+	d := Decision{Time: tb.clock.Now(), Bucket: tb.name, N: n, Allowed: allowed, TokensAfter: tb.tokens, Tag: tb.tag}
+	if !allowed {
+		d.Reason = reason
+	}
+	return d
+}
+
+// auditLocked sends a decision to the audit sink, if there is one.
+func (tb *TokenBucket) auditLocked(n int64, allowed bool, reason DenyReason) {
+	if tb.auditSink == nil {
+		return
+	}
+	select {
+	case tb.auditSink <- tb.decisionLocked(n, allowed, reason):
+	default:
+		tb.auditDropped++
+	}
+}
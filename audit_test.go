@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditSinkReceivesDecisions(t *testing.T) {
+	clock := NewManualClock(time.Unix(100, 0))
+	sink := make(chan Decision, 8)
+	tb := NewTokenBucket(1, 3, time.Hour, WithClock(clock), WithName("api"), WithAuditSink(sink), withoutRefillLog())
+	defer tb.Stop()
+
+	tb.AllowN(2)
+	tb.AllowTagged(2, "search")
+	want := []Decision{
+		{Time: time.Unix(100, 0), Bucket: "api", N: 2, Allowed: true, TokensAfter: 1},
+		{Time: time.Unix(100, 0), Bucket: "api", N: 2, Reason: DenyEmpty, TokensAfter: 1, Tag: "search"},
+	}
+	for i, w := range want {
+		if got := <-sink; !got.Time.Equal(w.Time) || got.Bucket != w.Bucket || got.N != w.N || got.Allowed != w.Allowed ||
+			got.Reason != w.Reason || got.TokensAfter != w.TokensAfter || got.Tag != w.Tag {
+			t.Fatalf("decision %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	tb.Allow()
+	if got := <-sink; !got.Allowed || got.N != 1 || got.TokensAfter != 0 {
+		t.Fatalf("Allow's decision = %+v", got)
+	}
+}
+
+func TestAuditSinkDropsWhenFull(t *testing.T) {
+	sink := make(chan Decision, 2)
+	tb := NewTokenBucket(1, 100, time.Hour, WithAuditSink(sink), withoutRefillLog())
+	defer tb.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			tb.Allow()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Allow blocked on a full audit sink")
+	}
+	if got := tb.AuditDropped(); got != 8 {
+		t.Fatalf("AuditDropped = %d, want 8 beyond the channel's room for 2", got)
+	}
+	if len(sink) != 2 {
+		t.Fatalf("%d decisions delivered, want 2", len(sink))
+	}
+}
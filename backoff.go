@@ -0,0 +1,89 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DenyBackoff grows the Retry-After the middleware sends a client with each
+// denial in a row, so clients that retry exactly when told do not all come
+// back together. The nth consecutive denial for a key suggests base *
+// factor^(n-1), capped at maxDelay, and an allowed request starts the key's
+// count again.
+type DenyBackoff struct {
+	keyFn    KeyFunc
+	base     time.Duration
+	factor   float64
+	maxDelay time.Duration
+	maxKeys  int
+
+	mu      sync.Mutex
+	lru     list.List // of *denyStreak, most recently denied first
+	streaks map[string]*list.Element
+}
+
+type denyStreak struct {
+	key    string
+	denied int
+}
+
+// NewDenyBackoff tracks denial streaks by keyFn's key, for at most maxKeys
+// keys: past that, the key denied least recently is forgotten and starts
+// again from base, so churning through keys cannot grow memory without
+// bound.
+func NewDenyBackoff(keyFn KeyFunc, base time.Duration, factor float64, maxDelay time.Duration, maxKeys int) *DenyBackoff {
+	return &DenyBackoff{keyFn: keyFn, base: base, factor: factor, maxDelay: maxDelay, maxKeys: max(maxKeys, 1), streaks: make(map[string]*list.Element)}
+}
+
+// WithDenyBackoff makes denials send the larger of b's backoff and the
+// bucket's own Retry-After, the time until a token is due, so the header
+// never invites a retry the bucket would turn down.
+func WithDenyBackoff(b *DenyBackoff) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.backoff = b
+	}
+}
+
+// denied counts a denial for r's key and returns the delay to suggest,
+// given that the bucket's tokens are due after due.
+func (b *DenyBackoff) denied(r *http.Request, due time.Duration) time.Duration {
+	key := b.keyFn(r)
+	b.mu.Lock()
+	e, ok := b.streaks[key]
+	if ok {
+		b.lru.MoveToFront(e)
+	} else {
+		e = b.lru.PushFront(&denyStreak{key: key})
+		b.streaks[key] = e
+		for len(b.streaks) > b.maxKeys {
+			oldest := b.lru.Remove(b.lru.Back()).(*denyStreak)
+			delete(b.streaks, oldest.key)
+		}
+	}
+	s := e.Value.(*denyStreak)
+	s.denied++
+	n := s.denied
+	b.mu.Unlock()
+
+	delay := float64(b.base) * math.Pow(b.factor, float64(n-1))
+	backoff := b.maxDelay
+	if delay < float64(b.maxDelay) {
+		backoff = time.Duration(delay)
+	}
+	return max(backoff, due)
+}
+
+// allowed ends r's key's streak.
+func (b *DenyBackoff) allowed(r *http.Request) {
+	key := b.keyFn(r)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if e, ok := b.streaks[key]; ok {
+		b.lru.Remove(e)
+		delete(b.streaks, key)
+	}
+}
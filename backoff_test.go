@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDenyBackoffGrowsRetryAfterToTheCap(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Second, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	backoff := NewDenyBackoff(KeyByIP, 2*time.Second, 2, 5*time.Second, 100)
+	h := Middleware(tb, WithDenyBackoff(backoff))(statusHandler(http.StatusOK))
+
+	request := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := request("192.0.2.1"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", rec.Code)
+	}
+	for i, want := range []string{"2", "4", "5", "5"} {
+		rec := request("192.0.2.1")
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != want {
+			t.Fatalf("denial %d: status %d, Retry-After %q; want 429, %s", i+1, rec.Code, rec.Header().Get("Retry-After"), want)
+		}
+	}
+	// Another client's streak is its own.
+	if got := request("192.0.2.2").Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("another key's first denial: Retry-After %q, want 2", got)
+	}
+
+	clock.Advance(time.Second)
+	if rec := request("192.0.2.1"); rec.Code != http.StatusOK {
+		t.Fatalf("after a refill: status %d", rec.Code)
+	}
+	if got := request("192.0.2.1").Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("first denial after an allow: Retry-After %q, want the streak to restart at 2", got)
+	}
+}
+
+func TestDenyBackoffNeverUndercutsTheBucket(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Minute, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	h := Middleware(tb, WithDenyBackoff(NewDenyBackoff(KeyByIP, time.Second, 2, 4*time.Second, 100)))(statusHandler(http.StatusOK))
+
+	serve(h)
+	if got := serve(h).Header().Get("Retry-After"); got != "60" {
+		t.Fatalf("Retry-After %q, want the bucket's 60 over the 1s backoff", got)
+	}
+}
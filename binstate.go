@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// binaryStateMagic starts every binary-encoded ManagerState, naming the
+// format and its version.
+const binaryStateMagic = "TBS1"
+
+// maxBinaryKey bounds the key length DecodeBinary accepts, so a corrupt
+// length cannot make it allocate without limit.
+const maxBinaryKey = 1 << 20
+
+// EncodeBinary writes s in a compact varint encoding, several times smaller
+// and faster to produce and read than JSON for a manager with many keys.
+// JSON stays the readable option; DecodeBinary reads this one back. Times
+// keep their instant but not their location.
+func (s ManagerState) EncodeBinary(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	buf := append([]byte(nil), binaryStateMagic...)
+	buf = appendTime(buf, s.SavedAt)
+	buf = binary.AppendUvarint(buf, uint64(len(s.Buckets)))
+	for key, b := range s.Buckets {
+		buf = binary.AppendUvarint(buf, uint64(len(key)))
+		buf = append(buf, key...)
+		buf = binary.AppendVarint(buf, b.Config.Rate)
+		buf = binary.AppendVarint(buf, b.Config.Capacity)
+		buf = binary.AppendVarint(buf, int64(b.Config.Interval))
+		disabled := byte(0)
+		if b.Config.Disabled {
+			disabled = 1
+		}
+		buf = append(buf, disabled)
+		buf = binary.AppendVarint(buf, b.Tokens)
+		buf = appendTime(buf, b.LastRefill)
+		buf = binary.AppendVarint(buf, b.Credited)
+		buf = appendTime(buf, b.LastSeen)
+
+		if len(buf) >= 64<<10 {
+			if _, err := bw.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// DecodeBinary replaces s with the state EncodeBinary wrote to r.
+func (s *ManagerState) DecodeBinary(r io.Reader) error {
+	d := binaryDecoder{r: bufio.NewReader(r)}
+	magic := make([]byte, len(binaryStateMagic))
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != binaryStateMagic {
+		return errors.New("not a binary token bucket state")
+	}
+
+	state := ManagerState{SavedAt: d.time()}
+	n := d.uvarint()
+	state.Buckets = make(map[string]BucketState, min(n, 1<<16))
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		key := d.key()
+		var b BucketState
+		b.Config.Rate = d.varint()
+		b.Config.Capacity = d.varint()
+		b.Config.Interval = time.Duration(d.varint())
+		b.Config.Disabled = d.byte() != 0
+		b.Tokens = d.varint()
+		b.LastRefill = d.time()
+		b.Credited = d.varint()
+		b.LastSeen = d.time()
+		state.Buckets[key] = b
+	}
+	if d.err != nil {
+		return fmt.Errorf("decoding binary token bucket state: %w", d.err)
+	}
+	*s = state
+	return nil
+}
+
+// appendTime encodes t as a presence byte, then seconds and nanoseconds
+// since the Unix epoch, which covers every time.Time; the zero Time is the
+// presence byte alone.
+func appendTime(buf []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return append(buf, 0)
+	}
+	buf = append(buf, 1)
+	buf = binary.AppendVarint(buf, t.Unix())
+	return binary.AppendUvarint(buf, uint64(t.Nanosecond()))
+}
+
+// binaryDecoder reads the fields of a binary state, keeping the first error
+// so the caller can check once at the end.
+type binaryDecoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *binaryDecoder) fail(err error) {
+	if d.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+}
+
+func (d *binaryDecoder) varint() int64 {
+	v, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return v
+}
+
+func (d *binaryDecoder) uvarint() uint64 {
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return v
+}
+
+func (d *binaryDecoder) byte() byte {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+	}
+	return b
+}
+
+func (d *binaryDecoder) key() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > maxBinaryKey {
+		d.fail(fmt.Errorf("key of %d bytes", n))
+		return ""
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		d.fail(err)
+	}
+	return string(buf)
+}
+
+func (d *binaryDecoder) time() time.Time {
+	if d.byte() == 0 || d.err != nil {
+		return time.Time{}
+	}
+	sec := d.varint()
+	nsec := d.uvarint()
+	if nsec >= uint64(time.Second) {
+		d.fail(fmt.Errorf("%d nanoseconds", nsec))
+	}
+	return time.Unix(sec, int64(nsec))
+}
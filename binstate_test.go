@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func sampleManagerState(keys int) ManagerState {
+	saved := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	state := ManagerState{SavedAt: saved, Buckets: make(map[string]BucketState, keys)}
+	for i := 0; i < keys; i++ {
+		b := BucketState{
+			Config:     Config{Rate: int64(i%7 + 1), Capacity: int64(i%50 + 10), Interval: time.Duration(i%3+1) * time.Second, Disabled: i%11 == 0},
+			Tokens:     int64(i%60 - 5),
+			LastRefill: saved.Add(-time.Duration(i) * time.Millisecond),
+			Credited:   int64(i % 3),
+		}
+		if i%2 == 0 {
+			b.LastSeen = saved.Add(-time.Duration(i) * time.Second)
+		}
+		state.Buckets[fmt.Sprintf("ip:10.%d.%d.%d", i>>16&255, i>>8&255, i&255)] = b
+	}
+	return state
+}
+
+// inUTC puts every time in s in UTC, which is how both encodings' results
+// are compared.
+func inUTC(s ManagerState) ManagerState {
+	out := ManagerState{SavedAt: s.SavedAt.UTC(), Buckets: make(map[string]BucketState, len(s.Buckets))}
+	for k, b := range s.Buckets {
+		b.LastRefill, b.LastSeen = b.LastRefill.UTC(), b.LastSeen.UTC()
+		out.Buckets[k] = b
+	}
+	return out
+}
+
+func TestBinaryStateRoundTripsLikeJSON(t *testing.T) {
+	state := sampleManagerState(1000)
+	state.Buckets["ancient"] = BucketState{Config: Config{Rate: 1, Capacity: 1, Interval: time.Hour}, LastRefill: time.Date(1, 1, 1, 0, 0, 0, 1, time.UTC)}
+
+	var bin bytes.Buffer
+	if err := state.EncodeBinary(&bin); err != nil {
+		t.Fatal(err)
+	}
+	var fromBinary ManagerState
+	if err := fromBinary.DecodeBinary(bytes.NewReader(bin.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON ManagerState
+	if err := json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(inUTC(fromBinary), inUTC(fromJSON)) || !reflect.DeepEqual(inUTC(fromBinary), inUTC(state)) {
+		t.Fatal("binary and JSON round trips restored different states")
+	}
+	if bin.Len()*2 > len(data) {
+		t.Fatalf("binary state is %d bytes, JSON %d; want it well under half", bin.Len(), len(data))
+	}
+}
+
+func TestDecodeBinaryRejectsBadInput(t *testing.T) {
+	var bin bytes.Buffer
+	if err := sampleManagerState(10).EncodeBinary(&bin); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"JSON":      []byte(`{"buckets":{}}`),
+		"truncated": bin.Bytes()[:bin.Len()-3],
+	} {
+		s := ManagerState{SavedAt: time.Unix(1, 0)}
+		if err := s.DecodeBinary(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: DecodeBinary succeeded", name)
+		}
+		if !s.SavedAt.Equal(time.Unix(1, 0)) {
+			t.Errorf("%s: a failed DecodeBinary changed the state", name)
+		}
+	}
+}
+
+func BenchmarkStateEncoding(b *testing.B) {
+	state := sampleManagerState(100_000)
+	var bin bytes.Buffer
+	state.EncodeBinary(&bin)
+	data, _ := json.Marshal(state)
+
+	b.Run("binary/encode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var buf bytes.Buffer
+			state.EncodeBinary(&buf)
+		}
+		b.ReportMetric(float64(bin.Len()), "bytes")
+	})
+	b.Run("json/encode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			json.Marshal(state)
+		}
+		b.ReportMetric(float64(len(data)), "bytes")
+	})
+	b.Run("binary/decode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var s ManagerState
+			s.DecodeBinary(bytes.NewReader(bin.Bytes()))
+		}
+	})
+	b.Run("json/decode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var s ManagerState
+			json.Unmarshal(data, &s)
+		}
+	})
+}
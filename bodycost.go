@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// UnknownLength is how BodyCostMiddleware treats a request whose size it
+// cannot tell in advance, such as a chunked upload.
+type UnknownLength struct {
+	// Reject turns such requests away with 411 Length Required.
+	Reject bool
+	// Cost is what an accepted one is charged. Its body is cut off after
+	// Cost*bytesPerToken bytes, so it cannot carry more than it paid for.
+	Cost int64
+}
+
+// BodyCostMiddleware charges each request one token per bytesPerToken bytes
+// of its declared Content-Length, rounded up, before the handler runs, so a
+// large upload is refused before any of it is read. A request costing more
+// than tb's capacity can never be afforded and gets 413; one the bucket
+// cannot cover right now gets 429, as from Middleware; neither body is
+// read. Requests without a Content-Length are handled as unknown says. An
+// empty body costs nothing. opts are Middleware's; those about what happens
+// after the handler runs do not apply.
+func BodyCostMiddleware(tb *TokenBucket, bytesPerToken int64, unknown UnknownLength, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	var cfg middlewareConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	bytesPerToken = max(bytesPerToken, 1)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cost := (r.ContentLength + bytesPerToken - 1) / bytesPerToken
+			if r.ContentLength < 0 {
+				if unknown.Reject {
+					w.WriteHeader(http.StatusLengthRequired)
+					fmt.Fprintln(w, "Length Required.")
+					return
+				}
+				cost = max(unknown.Cost, 0)
+				r.Body = http.MaxBytesReader(w, r.Body, cost*bytesPerToken)
+			}
+			ok, info := tb.allowNInfo(cost)
+			tb.trace(r.Context(), cost, ok, info.Remaining)
+			switch {
+			case !ok && cost > info.Limit:
+				recordLimitInfo(r, info)
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				fmt.Fprintln(w, "Request Entity Too Large.")
+				return
+			case !ok:
+				cfg.deny(w, r, tb, info, http.StatusTooManyRequests)
+				return
+			}
+			if cfg.backoff != nil {
+				cfg.backoff.allowed(r)
+			}
+			recordLimitInfo(r, info)
+			cfg.sampleHeaders(w, info)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), LimitInfoKey, info)))
+		})
+	}
+}
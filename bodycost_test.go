@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readingHandler reads the whole body and reports how much it got.
+func readingHandler(read *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		*read = len(b)
+		if err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	})
+}
+
+// trackedBody records whether anything was read from it.
+type trackedBody struct {
+	io.Reader
+	touched bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	b.touched = true
+	return b.Reader.Read(p)
+}
+
+func TestBodyCostChargesByContentLength(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+	var read int
+	h := BodyCostMiddleware(tb, 100, UnknownLength{Reject: true})(readingHandler(&read))
+
+	for _, tc := range []struct {
+		size       int
+		status     int
+		tokensLeft int64
+	}{
+		{size: 0, status: http.StatusOK, tokensLeft: 10},
+		{size: 1, status: http.StatusOK, tokensLeft: 9},
+		{size: 100, status: http.StatusOK, tokensLeft: 8},
+		{size: 250, status: http.StatusOK, tokensLeft: 5},
+		{size: 1001, status: http.StatusRequestEntityTooLarge, tokensLeft: 5},
+		{size: 600, status: http.StatusTooManyRequests, tokensLeft: 5},
+		{size: 500, status: http.StatusOK, tokensLeft: 0},
+	} {
+		body := &trackedBody{Reader: strings.NewReader(strings.Repeat("x", tc.size))}
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.ContentLength = int64(tc.size)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Fatalf("%d bytes: status %d, want %d", tc.size, rec.Code, tc.status)
+		}
+		if got := tb.AvailableTokens(); got != tc.tokensLeft {
+			t.Fatalf("%d bytes: %d tokens left, want %d", tc.size, got, tc.tokensLeft)
+		}
+		if rejected := tc.status != http.StatusOK; rejected && body.touched {
+			t.Fatalf("%d bytes: the body of a rejected request was read", tc.size)
+		}
+	}
+}
+
+func TestBodyCostUnknownLength(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+	chunked := func(size int) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/upload", io.NopCloser(strings.NewReader(strings.Repeat("x", size))))
+		req.ContentLength = -1
+		return req
+	}
+	var read int
+
+	rec := httptest.NewRecorder()
+	BodyCostMiddleware(tb, 100, UnknownLength{Reject: true})(readingHandler(&read)).ServeHTTP(rec, chunked(10))
+	if rec.Code != http.StatusLengthRequired || tb.AvailableTokens() != 10 {
+		t.Fatalf("rejecting unknown lengths: status %d, %d tokens; want 411, 10", rec.Code, tb.AvailableTokens())
+	}
+
+	h := BodyCostMiddleware(tb, 100, UnknownLength{Cost: 3})(readingHandler(&read))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, chunked(250))
+	if rec.Code != http.StatusOK || read != 250 || tb.AvailableTokens() != 7 {
+		t.Fatalf("fixed cost: status %d, read %d, %d tokens; want 200, 250, 7", rec.Code, read, tb.AvailableTokens())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, chunked(301))
+	if rec.Code != http.StatusRequestEntityTooLarge || read != 300 {
+		t.Fatalf("body beyond what it paid for: status %d, read %d; want it cut off at 300 bytes", rec.Code, read)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrWaitBudgetExceeded is returned by WaitNBudget when the share of the
+// deadline it was allowed to wait for has run out, while the overall
+// deadline has not.
+var ErrWaitBudgetExceeded = errors.New("token bucket wait exceeded its share of the deadline")
+
+// WaitNBudget is WaitNContext that spends at most maxFraction, clamped to
+// [0, 1], of the time left before ctx's deadline waiting, leaving the rest
+// for the work the tokens are for. If that sub-deadline passes first it
+// fails with ErrWaitBudgetExceeded, so the caller can tell the limiter gave
+// up rather than the request running out of time; if ctx itself ends it
+// returns ctx's error as usual. A ctx without a deadline has no budget to
+// divide, and the wait is plain WaitNContext.
+func (tb *TokenBucket) WaitNBudget(ctx context.Context, n int64, maxFraction float64) error {
+	deadline, ok := ctx.Deadline()
+	if !ok || maxFraction >= 1 {
+		return tb.WaitNContext(ctx, n)
+	}
+	budget := time.Duration(float64(time.Until(deadline)) * max(maxFraction, 0))
+	sub, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	err := tb.WaitNContext(sub, n)
+	if err != nil && ctx.Err() == nil && errors.Is(sub.Err(), context.DeadlineExceeded) {
+		return ErrWaitBudgetExceeded
+	}
+	return err
+}
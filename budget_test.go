@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitNBudgetGivesUpAtItsShareOfTheDeadline(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+	if err := tb.WaitNBudget(context.Background(), 1, 0.5); err != nil {
+		t.Fatalf("WaitNBudget with a token available: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 800*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := tb.WaitNBudget(ctx, 1, 0.25)
+	elapsed := time.Since(start)
+	if !errors.Is(err, ErrWaitBudgetExceeded) {
+		t.Fatalf("WaitNBudget = %v, want ErrWaitBudgetExceeded", err)
+	}
+	if elapsed < 150*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Fatalf("gave up after %v, want about a quarter of 800ms", elapsed)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("the request's own deadline passed too")
+	}
+	if n := queued(tb); n != 0 {
+		t.Fatalf("%d waiters left queued", n)
+	}
+}
+
+func TestWaitNBudgetServedWithinItsShare(t *testing.T) {
+	tb := NewTokenBucket(1, 1, 50*time.Millisecond, withoutRefillLog())
+	defer tb.Stop()
+	tb.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := tb.WaitNBudget(ctx, 1, 0.5); err != nil {
+		t.Fatalf("WaitNBudget = %v, want a token within the next refill", err)
+	}
+}
+
+func TestWaitNBudgetReportsTheRequestDeadline(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+	tb.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tb.WaitNBudget(ctx, 1, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitNBudget = %v, want the request's own DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"time"
+)
+
+type busyLoopDetector struct {
+	window   time.Duration
+	multiple float64
+	logger   Logger
+
+	start  time.Time // of the current window
+	denied int64
+	warned bool
+}
+
+// WithBusyLoopWarning watches for callers hammering the bucket in a tight
+// retry loop instead of backing off, which usually means a bug on their
+// side. When the denials within one window come to more than multiple
+// times what the rate earns in a window (counted as at least one token),
+// it logs a warning suggesting backoff to logger. It warns at most once per
+// window, however long the loop runs, so the warning cannot flood the log.
+// Off unless this option is given.
+func WithBusyLoopWarning(window time.Duration, multiple float64, logger Logger) Option {
+	return func(tb *TokenBucket) {
+		if window > 0 {
+			tb.busyLoop = &busyLoopDetector{window: window, multiple: multiple, logger: logger}
+		}
+	}
+}
+
+// busyLoopDeniedLocked counts a denial towards the current window.
+func (tb *TokenBucket) busyLoopDeniedLocked() {
+	d := tb.busyLoop
+	now := tb.clock.Now()
+	if d.start.IsZero() || now.Sub(d.start) >= d.window {
+		d.start, d.denied, d.warned = now, 0, false
+	}
+	d.denied++
+	if d.warned || tb.interval <= 0 {
+		return
+	}
+	earned := max(float64(tb.rate)*float64(d.window)/float64(tb.interval), 1)
+	if float64(d.denied) > d.multiple*earned {
+		d.warned = true
+		// Logged outside the lock, in case logger spends from this bucket.
+		go d.logger.Printf("token bucket %q: %d requests denied within %s, more than %gx the rate allows; add backoff between retries", tb.name, d.denied, d.window, d.multiple)
+	}
+}
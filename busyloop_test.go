@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type chanLogger chan string
+
+func (l chanLogger) Printf(format string, args ...any) { l <- fmt.Sprintf(format, args...) }
+
+func TestBusyLoopWarningFiresOncePerWindow(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	logs := make(chanLogger, 10)
+	tb := NewTokenBucket(1, 1, time.Second, WithClock(clock), WithName("api"),
+		WithBusyLoopWarning(10*time.Second, 100, logs), withoutRefillLog())
+	defer tb.Stop()
+	tb.Allow()
+
+	// 10 tokens a window, so up to 1000 denials are tolerated.
+	for i := 0; i < 1000; i++ {
+		tb.Allow()
+	}
+	select {
+	case line := <-logs:
+		t.Fatalf("warned at the threshold: %s", line)
+	case <-time.After(20 * time.Millisecond):
+	}
+	for i := 0; i < 5000; i++ {
+		tb.Allow()
+	}
+	if line := <-logs; !strings.Contains(line, `"api"`) || !strings.Contains(line, "backoff") {
+		t.Fatalf("warning = %q, want it to name the bucket and suggest backoff", line)
+	}
+	select {
+	case line := <-logs:
+		t.Fatalf("second warning within the window: %s", line)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(10 * time.Second)
+	tb.AllowN(2)
+	for i := 0; i < 1001; i++ {
+		tb.AllowN(2)
+	}
+	select {
+	case <-logs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no warning in the next window")
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "time"
+
+// Chain is a sequence of buckets a request must pass in order, such as a
+// per-client limit followed by a per-route and a global one.
+type Chain []*TokenBucket
+
+// AllowN takes n tokens from every bucket in the chain or from none. The
+// buckets are tried in order and the first denial stops the walk: what the
+// earlier ones gave is refunded, deniedBy is the index of the bucket that
+// said no, and retryAfter is how long until it and every bucket after it
+// will have n tokens, so that a retry then is not turned down further
+// along. On success deniedBy is -1 and retryAfter zero.
+func (c Chain) AllowN(n int64) (ok bool, deniedBy int, retryAfter time.Duration) {
+	for i, tb := range c {
+		if tb.AllowN(n) {
+			continue
+		}
+		for _, got := range c[:i] {
+			got.Refund(n)
+		}
+		for _, rest := range c[i:] {
+			retryAfter = max(retryAfter, rest.TimeUntilAvailable(n))
+		}
+		return false, i, retryAfter
+	}
+	return true, -1, 0
+}
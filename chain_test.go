@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChainRollsBackAndReportsTheDenier(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	newBucket := func(capacity int64, interval time.Duration) *TokenBucket {
+		return NewTokenBucket(1, capacity, interval, WithClock(clock), withoutRefillLog())
+	}
+	a, b, c := newBucket(5, time.Second), newBucket(2, time.Second), newBucket(5, time.Minute)
+	for _, tb := range []*TokenBucket{a, b, c} {
+		defer tb.Stop()
+	}
+	chain := Chain{a, b, c}
+
+	if ok, deniedBy, retry := chain.AllowN(2); !ok || deniedBy != -1 || retry != 0 {
+		t.Fatalf("AllowN(2) = %v, %d, %v; want true, -1, 0", ok, deniedBy, retry)
+	}
+
+	// b is empty now; a's tokens must come back, and c is never charged.
+	c.AllowN(2)
+	ok, deniedBy, retry := chain.AllowN(2)
+	if ok || deniedBy != 1 {
+		t.Fatalf("AllowN(2) = %v, %d; want false, denied by b (1)", ok, deniedBy)
+	}
+	if got := []int64{a.AvailableTokens(), b.AvailableTokens(), c.AvailableTokens()}; got[0] != 3 || got[1] != 0 || got[2] != 1 {
+		t.Fatalf("tokens after a denied chain = %v, want [3 0 1]", got)
+	}
+	// b is refilled in 2s, but c, further along, needs another minute.
+	if retry != time.Minute {
+		t.Fatalf("retryAfter = %v, want the 1m c needs", retry)
+	}
+
+	if ok, deniedBy, _ := chain.AllowN(3); ok || deniedBy != 1 {
+		t.Fatalf("AllowN(3) = %v, %d; want false, denied by b (1)", ok, deniedBy)
+	}
+	if got := a.AvailableTokens(); got != 3 {
+		t.Fatalf("a has %d tokens after a rollback, want 3", got)
+	}
+	if ok, deniedBy, _ := (Chain{c, a}).AllowN(2); ok || deniedBy != 0 {
+		t.Fatalf("chain led by an empty bucket = %v, %d; want false, 0", ok, deniedBy)
+	}
+}
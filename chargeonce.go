@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// ChargeOnce charges a bucket at most one token however many times Allow is
+// called, so a handler that retries internally still costs its caller a
+// single request. The middleware puts one in each admitted request's
+// context, already charged for the token it took.
+type ChargeOnce struct {
+	tb      *TokenBucket
+	mu      sync.Mutex
+	charged bool
+}
+
+func NewChargeOnce(tb *TokenBucket) *ChargeOnce {
+	return &ChargeOnce{tb: tb}
+}
+
+// Allow takes a token from the bucket on the first call that finds one
+// available; once that has happened every later call is allowed for free.
+func (c *ChargeOnce) Allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.charged {
+		c.charged = c.tb.Allow()
+	}
+	return c.charged
+}
+
+type chargeOnceKey struct{}
+
+func WithChargeOnce(ctx context.Context, c *ChargeOnce) context.Context {
+	return context.WithValue(ctx, chargeOnceKey{}, c)
+}
+
+func ChargeOnceFromContext(ctx context.Context) (*ChargeOnce, bool) {
+	c, ok := ctx.Value(chargeOnceKey{}).(*ChargeOnce)
+	return c, ok
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChargeOnceDebitsOneToken(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour)
+	defer tb.Stop()
+	ctx := WithChargeOnce(context.Background(), NewChargeOnce(tb))
+
+	for i := 0; i < 2; i++ {
+		c, ok := ChargeOnceFromContext(ctx)
+		if !ok {
+			t.Fatal("no ChargeOnce in context")
+		}
+		if !c.Allow() {
+			t.Fatalf("Allow() call %d denied", i)
+		}
+	}
+	if got := tb.AvailableTokens(); got != 4 {
+		t.Fatalf("%d tokens left, want 4", got)
+	}
+}
+
+func TestMiddlewareChargeOnceIsAlreadyCharged(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour)
+	defer tb.Stop()
+	h := Middleware(tb)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := ChargeOnceFromContext(r.Context())
+		if !ok || !c.Allow() || !c.Allow() {
+			t.Error("request's ChargeOnce missing or denied")
+		}
+	}))
+
+	serve(h)
+	if got := tb.AvailableTokens(); got != 4 {
+		t.Fatalf("%d tokens left, want 4: inner Allow calls must not charge again", got)
+	}
+}
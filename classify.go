@@ -0,0 +1,88 @@
+package main
+
+import (
+	"container/list"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Classifier maps a client IP to a class, such as its country or ASN, for
+// reputation-based limits. It returns "" for an address it cannot place.
+type Classifier func(ip net.IP) string
+
+// FromClass keys by the class classify gives the client IP, so every
+// client in a class shares one bucket: "class:" followed by the class.
+// Lookups are cached for at most cacheSize addresses, the least recently
+// used being forgotten first, so a costly classifier, such as a GeoIP
+// database, runs once per address rather than once per request. A client
+// classify cannot place, or whose address does not parse, gets "", for
+// FirstKey to fall back on.
+func FromClass(classify Classifier, cacheSize int) KeyFunc {
+	c := &classCache{classify: classify, size: max(cacheSize, 1), entries: make(map[string]*list.Element)}
+	return func(r *http.Request) string {
+		if class := c.lookup(KeyByIP(r)); class != "" {
+			return "class:" + class
+		}
+		return ""
+	}
+}
+
+// ClassConfig returns a ConfigFunc for FromClass keys, giving each class
+// its own Config from classes, such as a stricter one for a suspect ASN.
+// Classes not in classes, and keys that are not FromClass's, get fallback.
+func ClassConfig(classes map[string]Config, fallback Config) ConfigFunc {
+	return func(key string) Config {
+		if class, ok := strings.CutPrefix(key, "class:"); ok {
+			if cfg, ok := classes[class]; ok {
+				return cfg
+			}
+		}
+		return fallback
+	}
+}
+
+type classCache struct {
+	classify Classifier
+	size     int
+
+	mu      sync.Mutex
+	lru     list.List // of *classEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type classEntry struct {
+	addr, class string
+}
+
+// lookup returns addr's class, classifying it if it is not cached.
+// Classification runs outside the lock, so concurrent first lookups of one
+// address may each classify it.
+func (c *classCache) lookup(addr string) string {
+	c.mu.Lock()
+	if e, ok := c.entries[addr]; ok {
+		c.lru.MoveToFront(e)
+		class := e.Value.(*classEntry).class
+		c.mu.Unlock()
+		return class
+	}
+	c.mu.Unlock()
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	class := c.classify(ip)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[addr]; !ok {
+		c.entries[addr] = c.lru.PushFront(&classEntry{addr: addr, class: class})
+		for len(c.entries) > c.size {
+			oldest := c.lru.Remove(c.lru.Back()).(*classEntry)
+			delete(c.entries, oldest.addr)
+		}
+	}
+	return class
+}
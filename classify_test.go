@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFromClassSharesABucketPerClass(t *testing.T) {
+	lookups := 0
+	classify := func(ip net.IP) string {
+		lookups++
+		if ip.To4() != nil && ip.To4()[0] == 203 {
+			return "AS64500"
+		}
+		return ""
+	}
+	m := NewLimiterManager(ClassConfig(map[string]Config{
+		"AS64500": {Rate: 1, Capacity: 2, Interval: time.Hour},
+	}, Config{Rate: 1, Capacity: 10, Interval: time.Hour}))
+	defer m.StopAll()
+	keyFn := FirstKey(FromClass(classify, 100))
+	captureLog(t)
+	h := m.Middleware(keyFn)(statusHandler(http.StatusOK))
+	request := func(addr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if got := request("203.0.113.1:1000"); got != http.StatusOK {
+		t.Fatalf("first client in the class: status %d", got)
+	}
+	if got := request("203.0.113.200:1000"); got != http.StatusOK {
+		t.Fatalf("second client in the class: status %d", got)
+	}
+	if got := request("203.0.113.1:2000"); got != http.StatusTooManyRequests {
+		t.Fatalf("third request from the class: status %d, want 429 from its shared capacity of 2", got)
+	}
+	if got := m.GetOrCreate("class:AS64500").Config().Capacity; got != 2 {
+		t.Fatalf("class bucket capacity = %d, want the class's 2", got)
+	}
+
+	if got := request("198.51.100.1:1000"); got != http.StatusOK {
+		t.Fatalf("unclassified client: status %d", got)
+	}
+	if got := m.GetOrCreate("ip:198.51.100.1").Config().Capacity; got != 10 {
+		t.Fatalf("unclassified client's capacity = %d, want the fallback's 10", got)
+	}
+
+	if lookups != 3 {
+		t.Fatalf("classifier ran %d times for 3 distinct addresses, want the cache to spare repeats", lookups)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock is the bucket's source of time for timestamps and time-based
+// calculations. Refills are driven by a real ticker unless the clock is a
+// ManualClock, which fires them itself as it is advanced.
+type Clock interface {
+	Now() time.Time
+}
+
+// ticker is what the refill loop needs from a time.Ticker. A ticker whose
+// C is nil delivers its ticks some other way.
+type ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// tickerClock is implemented by clocks that drive refills themselves,
+// calling tick once per period.
+type tickerClock interface {
+	newTicker(d time.Duration, tick func()) ticker
+}
+
+func newTicker(c Clock, d time.Duration, tick func()) ticker {
+	if tc, ok := c.(tickerClock); ok {
+		return tc.newTicker(d, tick)
+	}
+	return realTicker{time.NewTicker(d)}
+}
+
+// timerClock is implemented by clocks that run timers themselves.
+type timerClock interface {
+	runAt(at time.Time, f func()) (stop func() bool)
+}
+
+// runAt calls f once c reaches at, like time.AfterFunc. stop cancels the
+// call, reporting whether it did so before f ran.
+func runAt(c Clock, at time.Time, f func()) (stop func() bool) {
+	if tc, ok := c.(timerClock); ok {
+		return tc.runAt(at, f)
+	}
+	return time.AfterFunc(at.Sub(c.Now()), f).Stop
+}
+
+// Ticker delivers refill ticks for a bucket built WithTicker, as a
+// time.Ticker does.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// WithTicker drives refills from tickers made by newTicker instead of a
+// time.Ticker, e.g. to share one timer wheel between many buckets, or for a
+// test to send each tick itself. newTicker is called with the refill
+// interval at construction, and again whenever Reconfigure changes the
+// interval, once the previous ticker has been stopped. With this option
+// the clock no longer drives refills, even a ManualClock; it is still used
+// for everything else.
+func WithTicker(newTicker func(interval time.Duration) Ticker) Option {
+	return func(tb *TokenBucket) {
+		tb.newTicker = newTicker
+	}
+}
+
+// customTicker adapts a WithTicker factory to the refill loop, forwarding
+// ticks onto one channel that outlives the tickers Reset replaces.
+type customTicker struct {
+	newTicker func(time.Duration) Ticker
+	c         chan time.Time
+
+	mu      sync.Mutex
+	inner   Ticker
+	quit    chan struct{}
+	stopped bool
+}
+
+func newCustomTicker(newTicker func(time.Duration) Ticker, d time.Duration) *customTicker {
+	t := &customTicker{newTicker: newTicker, c: make(chan time.Time)}
+	t.startLocked(d)
+	return t
+}
+
+func (t *customTicker) startLocked(d time.Duration) {
+	t.inner = t.newTicker(d)
+	t.quit = make(chan struct{})
+	go t.forward(t.inner.C(), t.quit)
+}
+
+func (t *customTicker) forward(ticks <-chan time.Time, quit chan struct{}) {
+	for {
+		select {
+		case now := <-ticks:
+			select {
+			case t.c <- now:
+			case <-quit:
+				return
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+func (t *customTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *customTicker) Reset(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped {
+		return
+	}
+	t.stopLocked()
+	t.startLocked(d)
+}
+
+func (t *customTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.stopped {
+		t.stopped = true
+		t.stopLocked()
+	}
+}
+
+func (t *customTicker) stopLocked() {
+	t.inner.Stop()
+	close(t.quit)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func WithClock(c Clock) Option {
+	return func(tb *TokenBucket) {
+		tb.clock = c
+	}
+}
+
+func (tb *TokenBucket) Clock() Clock {
+	return tb.clock
+}
+
+// ManualClock is a Clock that only moves when told to. Buckets built with it
+// have no ticker or timers of their own: Advance runs their refills, and
+// serves their blocked waiters as tokens come due, synchronously and in time
+// order, so a test can step any number of buckets forward in lockstep and
+// inspect them as soon as Advance returns.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d, firing every refill and timer that
+// falls due on the way at the moment it is due.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		next := c.nextDueLocked(target)
+		if next == nil {
+			break
+		}
+		c.now = next.next
+		if next.once {
+			c.removeLocked(next)
+		} else {
+			next.next = next.next.Add(next.period)
+		}
+		tick := next.tick
+
+		// The bucket reads the clock while refilling.
+		c.mu.Unlock()
+		tick()
+		c.mu.Lock()
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+func (c *ManualClock) nextDueLocked(target time.Time) *manualTicker {
+	var next *manualTicker
+	for _, t := range c.tickers {
+		if !t.next.After(target) && (next == nil || t.next.Before(next.next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+func (c *ManualClock) newTicker(d time.Duration, tick func()) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &manualTicker{clock: c, period: d, next: c.now.Add(d), tick: tick}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// runAt runs f in its own goroutine straight away if at has already
+// passed, since no Advance will reach it.
+func (c *ManualClock) runAt(at time.Time, f func()) (stop func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !at.After(c.now) {
+		go f()
+		return func() bool { return false }
+	}
+	t := &manualTicker{clock: c, next: at, tick: f, once: true}
+	c.tickers = append(c.tickers, t)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return c.removeLocked(t)
+	}
+}
+
+func (c *ManualClock) removeLocked(t *manualTicker) bool {
+	for i, x := range c.tickers {
+		if x == t {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// manualTicker is a ticker on a ManualClock, or with once set a timer.
+type manualTicker struct {
+	clock  *ManualClock
+	period time.Duration
+	next   time.Time
+	tick   func()
+	once   bool
+}
+
+func (t *manualTicker) C() <-chan time.Time {
+	return nil
+}
+
+// Reset restarts a stopped ticker, as it does a time.Ticker.
+func (t *manualTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	t.period = d
+	t.next = t.clock.now.Add(d)
+	if !slices.Contains(t.clock.tickers, t) {
+		t.clock.tickers = append(t.clock.tickers, t)
+	}
+}
+
+func (t *manualTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	t.clock.removeLocked(t)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// queued is how many waiters tb holds, read without the accrual that Stats
+// would do, which serves waiters itself.
+func queued(tb *TokenBucket) int {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return len(tb.waiters)
+}
+
+func TestManualClockRefillsBucketsTogether(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 2, Capacity: 4, Interval: time.Second} },
+		WithManagerClock(clock))
+	defer m.StopAll()
+	keys := []string{"a", "b", "c"}
+	for _, key := range keys {
+		m.GetOrCreate(key).AllowN(4)
+	}
+
+	for step, want := range []int64{2, 4, 4} {
+		clock.Advance(time.Second)
+		for _, key := range keys {
+			if got := m.GetOrCreate(key).AvailableTokens(); got != want {
+				t.Fatalf("step %d, %s: %d tokens, want %d", step, key, got, want)
+			}
+		}
+	}
+}
+
+func TestManualClockServesWaitersBetweenRefills(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(10, 10, 10*time.Hour, WithClock(clock))
+	defer tb.Stop()
+	tb.AllowN(10)
+
+	done := make(chan error, 1)
+	go func() { done <- tb.WaitN(1) }()
+	waitForQueue(t, tb, 1)
+
+	// The token is released an hour in, long before the next refill.
+	clock.Advance(time.Hour + time.Second)
+	if got := queued(tb); got != 0 {
+		t.Fatalf("%d still waiting once Advance returned, want 0", got)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManualClockServesSpacedWaiters(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 5, time.Hour, WithClock(clock), WithMinSpacing(time.Minute))
+	defer tb.Stop()
+	tb.Allow()
+
+	done := make(chan error, 1)
+	go func() { done <- tb.Wait() }()
+	waitForQueue(t, tb, 1)
+
+	clock.Advance(59 * time.Second)
+	if got := queued(tb); got != 1 {
+		t.Fatalf("%d waiting inside the spacing, want 1", got)
+	}
+	clock.Advance(time.Second)
+	if got := queued(tb); got != 0 {
+		t.Fatalf("%d still waiting once the spacing was up, want 0", got)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManualClockTimesRetryBackoff(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 5, time.Hour, WithClock(clock))
+	defer tb.Stop()
+
+	calls := make(chan struct{}, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- tb.DoWithRetry(context.Background(), RetryPolicy{MaxRetries: 1, Backoff: time.Hour}, func() error {
+			calls <- struct{}{}
+			return errors.New("fn failed")
+		})
+	}()
+	<-calls
+	// The backoff timer is armed just after fn returns.
+	waitFor(t, func() bool {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+
+		return len(clock.tickers) == 2
+	})
+	clock.Advance(time.Hour)
+	<-calls
+	if err := <-done; err == nil {
+		t.Fatal("DoWithRetry = nil, want fn's error")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"time"
+)
+
+type EventKind int
+
+const (
+	AllowEvent   EventKind = iota // Allow()
+	AllowNEvent                   // AllowN(N)
+	AdvanceEvent                  // move the clocks forward by D
+)
+
+// Event is one step of a script for CompareLimiters.
+type Event struct {
+	Kind EventKind
+	N    int64
+	D    time.Duration
+}
+
+// Divergence is a step after which two limiters disagreed. Tokens are
+// reported when the limiters expose AvailableTokens, and are zero
+// otherwise.
+type Divergence struct {
+	Step               int
+	Event              Event
+	AllowedA, AllowedB bool
+	TokensA, TokensB   int64
+}
+
+// CompareLimiters replays script against a and b and reports every step
+// after which their decisions or token counts differ, as a testing aid for
+// changes that must not alter behaviour. For the replay to be
+// deterministic both limiters should run on a ManualClock, shared or not:
+// each Advance event moves every distinct clock once. A limiter on any
+// other clock cannot be advanced and sees only real time pass.
+func CompareLimiters(a, b Limiter, script []Event) []Divergence {
+	var clocks []*ManualClock
+	for _, l := range []Limiter{a, b} {
+		if c, ok := limiterClock(l).(*ManualClock); ok && (len(clocks) == 0 || clocks[0] != c) {
+			clocks = append(clocks, c)
+		}
+	}
+
+	var divergences []Divergence
+	for i, ev := range script {
+		d := Divergence{Step: i, Event: ev}
+		switch ev.Kind {
+		case AllowEvent:
+			d.AllowedA, d.AllowedB = a.Allow(), b.Allow()
+		case AllowNEvent:
+			d.AllowedA, d.AllowedB = a.AllowN(ev.N), b.AllowN(ev.N)
+		case AdvanceEvent:
+			for _, c := range clocks {
+				c.Advance(ev.D)
+			}
+		}
+		d.TokensA, d.TokensB = limiterTokens(a), limiterTokens(b)
+		if d.AllowedA != d.AllowedB || d.TokensA != d.TokensB {
+			divergences = append(divergences, d)
+		}
+	}
+	return divergences
+}
+
+func limiterTokens(l Limiter) int64 {
+	if t, ok := l.(interface{ AvailableTokens() int64 }); ok {
+		return t.AvailableTokens()
+	}
+	return 0
+}
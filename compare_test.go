@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func compareScript() []Event {
+	return []Event{
+		{Kind: AllowNEvent, N: 3},
+		{Kind: AllowEvent},
+		{Kind: AllowEvent},
+		{Kind: AdvanceEvent, D: time.Second},
+		{Kind: AllowNEvent, N: 2},
+		{Kind: AdvanceEvent, D: 3 * time.Second},
+		{Kind: AllowNEvent, N: 4},
+	}
+}
+
+func TestCompareLimitersIdenticalConfigsAgree(t *testing.T) {
+	clockA, clockB := NewManualClock(time.Unix(0, 0)), NewManualClock(time.Unix(0, 0))
+	a := NewTokenBucket(2, 4, time.Second, WithClock(clockA), withoutRefillLog())
+	b := NewTokenBucket(2, 4, time.Second, WithClock(clockB), withoutRefillLog())
+	defer a.Stop()
+	defer b.Stop()
+
+	if d := CompareLimiters(a, b, compareScript()); len(d) != 0 {
+		t.Fatalf("identical limiters diverged: %+v", d)
+	}
+	if !clockA.Now().Equal(time.Unix(4, 0)) || !clockB.Now().Equal(time.Unix(4, 0)) {
+		t.Fatalf("clocks at %v and %v, want both advanced by 4s", clockA.Now(), clockB.Now())
+	}
+}
+
+func TestCompareLimitersReportsDivergences(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	a := NewTokenBucket(2, 4, time.Second, WithClock(clock), withoutRefillLog())
+	b := NewTokenBucket(1, 4, time.Second, WithClock(clock), withoutRefillLog())
+	defer a.Stop()
+	defer b.Stop()
+
+	got := CompareLimiters(a, b, compareScript())
+	want := []Divergence{
+		{Step: 3, Event: Event{Kind: AdvanceEvent, D: time.Second}, TokensA: 2, TokensB: 1},
+		{Step: 4, Event: Event{Kind: AllowNEvent, N: 2}, AllowedA: true, AllowedB: false, TokensA: 0, TokensB: 1},
+	}
+	// The shared clock moves once per Advance, so both refill from there.
+	if !clock.Now().Equal(time.Unix(4, 0)) {
+		t.Fatalf("shared clock at %v, want it advanced once per event", clock.Now())
+	}
+	// Both are full again after 3s and agree from then on.
+	if !slices.Equal(got, want) {
+		t.Fatalf("divergences = %+v, want %+v", got, want)
+	}
+}
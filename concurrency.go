@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// RateAndConcurrencyLimiter admits a request only if it gets both a token
+// from the bucket and one of a fixed number of in-flight slots.
+type RateAndConcurrencyLimiter struct {
+	tb    *TokenBucket
+	slots chan struct{}
+}
+
+func NewRateAndConcurrencyLimiter(tb *TokenBucket, maxInFlight int) *RateAndConcurrencyLimiter {
+	return &RateAndConcurrencyLimiter{tb: tb, slots: make(chan struct{}, maxInFlight)}
+}
+
+// Acquire waits for a token and then a slot until ctx is done. If the token
+// is obtained but no slot frees up in time, the token is refunded. The
+// returned release gives the slot back; the token stays spent. It is safe
+// to call release more than once.
+func (l *RateAndConcurrencyLimiter) Acquire(ctx context.Context) (release func(), ok bool) {
+	if err := l.tb.WaitContext(ctx); err != nil {
+		return nil, false
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		l.tb.Refund(1)
+		return nil, false
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-l.slots })
+	}, true
+}
+
+func (l *RateAndConcurrencyLimiter) InFlight() int {
+	return len(l.slots)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateAndConcurrencyRefundsTokenWithoutSlot(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour)
+	defer tb.Stop()
+	l := NewRateAndConcurrencyLimiter(tb, 1)
+
+	release, ok := l.Acquire(context.Background())
+	if !ok {
+		t.Fatal("first Acquire failed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, ok := l.Acquire(ctx); ok {
+		t.Fatal("second Acquire got a slot while the only one was taken")
+	}
+	if got := tb.AvailableTokens(); got != 4 {
+		t.Fatalf("%d tokens left, want 4: the failed Acquire should refund its token", got)
+	}
+
+	release()
+	release()
+	if got := l.InFlight(); got != 0 {
+		t.Fatalf("InFlight() = %d after release, want 0", got)
+	}
+	if _, ok := l.Acquire(context.Background()); !ok {
+		t.Fatal("Acquire failed after the slot was released")
+	}
+	if got := l.InFlight(); got != 1 {
+		t.Fatalf("InFlight() = %d, want 1", got)
+	}
+}
+
+func TestRateAndConcurrencyNeedsToken(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour)
+	defer tb.Stop()
+	l := NewRateAndConcurrencyLimiter(tb, 2)
+
+	release, _ := l.Acquire(context.Background())
+	release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, ok := l.Acquire(ctx); ok {
+		t.Fatal("Acquire succeeded with a free slot but no token")
+	}
+	if got := l.InFlight(); got != 0 {
+		t.Fatalf("InFlight() = %d, want 0", got)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/bits"
+	"slices"
+	"time"
+)
+
+// Config describes a bucket. A Rate of zero is a fixed quota: the bucket
+// starts with Capacity tokens and never refills. Disabled turns limiting off
+// entirely, so every call is allowed and nothing is consumed.
+type Config struct {
+	Rate     int64         `json:"rate"`
+	Capacity int64         `json:"capacity"`
+	Interval time.Duration `json:"interval"`
+	Disabled bool          `json:"disabled,omitempty"`
+}
+
+func (c Config) Validate() error {
+	if problems := c.problems(); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// problems lists everything wrong with c, in field order.
+func (c Config) problems() []error {
+	var problems []error
+	if c.Rate < 0 {
+		problems = append(problems, errors.New("rate must not be negative"))
+	}
+	if c.Capacity <= 0 {
+		problems = append(problems, errors.New("capacity must be positive"))
+	}
+	if c.Interval <= 0 {
+		problems = append(problems, errors.New("interval must be positive"))
+	}
+	return problems
+}
+
+// ValidateConfigs checks every config in cfgs, keyed by bucket name, and
+// reports all that is wrong at once rather than stopping at the first bad
+// entry: the result joins one error per invalid field, each naming its
+// key, in key order. It is nil if every config is valid.
+func ValidateConfigs(cfgs map[string]Config) error {
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(cfgs)) {
+		for _, err := range cfgs[key].problems() {
+			errs = append(errs, fmt.Errorf("%q: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewTokenBucketFromConfig validates cfg and builds a bucket from it.
+func NewTokenBucketFromConfig(cfg Config, opts ...Option) (*TokenBucket, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return newTokenBucketFromConfig(cfg, opts...), nil
+}
+
+func newTokenBucketFromConfig(cfg Config, opts ...Option) *TokenBucket {
+	tb := NewTokenBucket(cfg.Rate, cfg.Capacity, cfg.Interval, opts...)
+	tb.disabled = cfg.Disabled
+	return tb
+}
+
+func (tb *TokenBucket) Config() Config {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.configLocked()
+}
+
+func (tb *TokenBucket) configLocked() Config {
+	return Config{Rate: tb.rate, Capacity: tb.capacity, Interval: tb.interval, Disabled: tb.disabled}
+}
+
+// Reconfigure applies cfg to a running bucket. The current balance is kept,
+// clamped to the new capacity, which becomes the baseline for
+// WithAdaptiveCapacity. Blocked Wait and WaitForTokens calls asking
+// for more than the new capacity fail with ErrTokensExceedCapacity.
+//
+// When the interval changes and either the average rate stays the same
+// (1 per 2s to 30 per minute, say) or the rate per interval does, the part
+// of a token earned so far is carried into the new schedule, so no accrual
+// is lost at the change. With elapsed the time since the last refill, the
+// old schedule has earned
+//
+//	earned = min(rate·elapsed/interval + phase, rate)
+//
+// of which credited whole tokens have been added; the new schedule starts
+// now with phase = earned − credited, so its next token comes after
+// (1 − phase)·newInterval/newRate rather than a full newInterval/newRate.
+// Any other rate change takes effect from the next refill with no such
+// carry-over. MigrateInterval changes the interval keeping the average
+// rate.
+func (tb *TokenBucket) Reconfigure(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	sameRate := tb.rate > 0 && sameAverageRate(tb.rate, tb.interval, cfg.Rate, cfg.Interval)
+	if cfg.Interval != tb.interval && !tb.stopped {
+		var carry float64
+		if sameRate || tb.rate > 0 && cfg.Rate == tb.rate {
+			elapsed := tb.clock.Now().Sub(tb.lastRefill)
+			earned := math.Min(float64(tb.rate)*float64(elapsed)/float64(tb.interval)+tb.phase, float64(tb.rate))
+			carry = earned - float64(tb.credited)
+		}
+		tb.ticker.Reset(cfg.Interval)
+		tb.lastRefill = tb.clock.Now()
+		tb.credited = 0
+		tb.phase = carry
+	} else if !sameRate {
+		tb.phase = 0
+	}
+	tb.rate = cfg.Rate
+	tb.capacity = cfg.Capacity
+	tb.adaptExtra = 0
+	tb.interval = cfg.Interval
+	tb.disabled = cfg.Disabled
+	if tb.tokens > tb.capacity {
+		tb.tokens = tb.capacity
+	}
+	if !tb.bypassLocked() {
+		tb.failOversizedWaitersLocked()
+	}
+	tb.serveWaitersLocked()
+	tb.notifyWatchersLocked()
+	tb.publishLocked()
+
+	return nil
+}
+
+// sameAverageRate reports whether r1 per i1 and r2 per i2 are the same rate,
+// without the rounding or overflow of dividing either out.
+func sameAverageRate(r1 int64, i1 time.Duration, r2 int64, i2 time.Duration) bool {
+	if r1 < 0 || r2 < 0 || i1 <= 0 || i2 <= 0 {
+		return false
+	}
+	hi1, lo1 := bits.Mul64(uint64(r1), uint64(i2))
+	hi2, lo2 := bits.Mul64(uint64(r2), uint64(i1))
+	return hi1 == hi2 && lo1 == lo2
+}
+
+// fasterRate reports whether r1 per i1 is a higher rate than r2 per i2,
+// comparing the cross products at full width as sameAverageRate does. A
+// negative rate never refills and counts as zero; both intervals must be
+// positive.
+func fasterRate(r1 int64, i1 time.Duration, r2 int64, i2 time.Duration) bool {
+	r1, r2 = max(r1, 0), max(r2, 0)
+	hi1, lo1 := bits.Mul64(uint64(r1), uint64(i2))
+	hi2, lo2 := bits.Mul64(uint64(r2), uint64(i1))
+	return hi1 > hi2 || hi1 == hi2 && lo1 > lo2
+}
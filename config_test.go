@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFixedQuotaDrainsThenDenies(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb, err := NewTokenBucketFromConfig(Config{Rate: 0, Capacity: 3, Interval: time.Second}, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tb.Stop()
+
+	for i := 0; i < 3; i++ {
+		if !tb.Allow() {
+			t.Fatalf("request %d denied within the quota", i)
+		}
+	}
+	clock.Advance(time.Hour)
+	if tb.Allow() {
+		t.Fatal("fixed quota refilled")
+	}
+}
+
+func TestDisabledAlwaysAllows(t *testing.T) {
+	tb, err := NewTokenBucketFromConfig(Config{Rate: 0, Capacity: 1, Interval: time.Second, Disabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tb.Stop()
+
+	for i := 0; i < 100; i++ {
+		if !tb.AllowN(5) {
+			t.Fatalf("disabled bucket denied request %d", i)
+		}
+	}
+	if got := tb.AvailableTokens(); got != 1 {
+		t.Fatalf("disabled bucket consumed tokens: %d left, want 1", got)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		ok   bool
+	}{
+		{"refilling", Config{Rate: 1, Capacity: 1, Interval: time.Second}, true},
+		{"fixed quota", Config{Rate: 0, Capacity: 1, Interval: time.Second}, true},
+		{"negative rate", Config{Rate: -1, Capacity: 1, Interval: time.Second}, false},
+		{"no capacity", Config{Rate: 1, Capacity: 0, Interval: time.Second}, false},
+		{"no interval", Config{Rate: 1, Capacity: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err == nil) != tt.ok {
+				t.Fatalf("Validate() = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestReconfigureSameAverageRateIsContinuous(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 100, 2*time.Second, WithClock(clock))
+	defer tb.Stop()
+	tb.AllowN(100)
+
+	// Tokens drained once a second for three minutes, switching from 1 per
+	// 2s to 30 per minute half-way through a token. At the same average
+	// rate, t seconds in t/2 tokens should have come out whichever schedule
+	// was in force.
+	var total int64
+	for sec := 1; sec <= 180; sec++ {
+		clock.Advance(time.Second)
+		n := tb.AvailableTokens()
+		tb.AllowN(n)
+		total += n
+		if want := int64(sec / 2); total != want {
+			t.Fatalf("after %ds: %d tokens released, want %d", sec, total, want)
+		}
+		if sec == 61 {
+			if err := tb.Reconfigure(Config{Rate: 30, Capacity: 100, Interval: time.Minute}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestValidateConfigsReportsEveryBadEntry(t *testing.T) {
+	err := ValidateConfigs(map[string]Config{
+		"search": {Rate: 1, Capacity: 10, Interval: time.Second},
+		"upload": {Rate: -1, Capacity: 0, Interval: time.Second},
+		"login":  {Rate: 1, Capacity: 5},
+	})
+	if err == nil {
+		t.Fatal("ValidateConfigs accepted invalid configs")
+	}
+	want := `"login": interval must be positive` + "\n" +
+		`"upload": rate must not be negative` + "\n" +
+		`"upload": capacity must be positive`
+	if err.Error() != want {
+		t.Fatalf("error =\n%s\nwant\n%s", err, want)
+	}
+	if strings.Contains(err.Error(), "search") {
+		t.Fatal("the valid entry was reported")
+	}
+
+	if err := ValidateConfigs(map[string]Config{"search": {Rate: 1, Capacity: 10, Interval: time.Second}}); err != nil {
+		t.Fatalf("all valid: %v", err)
+	}
+}
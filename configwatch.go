@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConfigWatcher keeps a bucket in step with a JSON config file, polling it
+// for changes and applying each new version with Reconfigure:
+//
+//	{"rate": 5, "capacity": 20, "interval": "1s"}
+//
+// interval is a Go duration string. A version that fails to parse or
+// validate is logged and skipped, and the bucket keeps its last good config
+// until the file is fixed.
+type ConfigWatcher struct {
+	path string
+	tb   *TokenBucket
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+
+	ticker   ticker
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type configFile struct {
+	Rate     int64  `json:"rate"`
+	Capacity int64  `json:"capacity"`
+	Interval string `json:"interval"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// WatchConfig applies the config at path to tb and then checks the file for
+// changes every poll, on tb's clock. It fails, leaving tb untouched, if the
+// file can't be loaded to begin with.
+func WatchConfig(path string, tb *TokenBucket, poll time.Duration) (*ConfigWatcher, error) {
+	if poll <= 0 {
+		return nil, errors.New("poll interval must be positive")
+	}
+	w := &ConfigWatcher{path: path, tb: tb, stop: make(chan struct{})}
+	if _, err := w.reload(); err != nil {
+		return nil, err
+	}
+	w.ticker = newTicker(tb.clock, poll, w.check)
+	go w.watch()
+	return w, nil
+}
+
+// LoadConfigFile reads and validates a config file in the format
+// ConfigWatcher expects.
+func LoadConfigFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var f configFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	interval, err := time.ParseDuration(f.Interval)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: interval: %w", path, err)
+	}
+	cfg := Config{Rate: f.Rate, Capacity: f.Capacity, Interval: interval, Disabled: f.Disabled}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (w *ConfigWatcher) watch() {
+	defer w.ticker.Stop()
+
+	for {
+		select {
+		case <-w.ticker.C():
+			w.check()
+
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// check reloads the file if it has changed since it was last read. A bad
+// version is only reported once, not on every poll.
+func (w *ConfigWatcher) check() {
+	changed, err := w.reload()
+	if changed && err != nil {
+		log.Printf("Config reload from %s failed, keeping the current config: %v\n", w.path, err)
+	} else if changed {
+		log.Printf("Config reloaded from %s\n", w.path)
+	}
+}
+
+func (w *ConfigWatcher) reload() (changed bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		// Reported once, like a bad version; the next good write is a change.
+		if w.modTime.IsZero() && w.size < 0 {
+			return false, err
+		}
+		w.modTime, w.size = time.Time{}, -1
+		return true, err
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false, nil
+	}
+	w.modTime, w.size = info.ModTime(), info.Size()
+
+	cfg, err := LoadConfigFile(w.path)
+	if err != nil {
+		return true, err
+	}
+	return true, w.tb.Reconfigure(cfg)
+}
+
+// Stop ends the polling. The bucket keeps whatever config it last had.
+func (w *ConfigWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig replaces the file at path, stamping it with mtime so a
+// rewrite within the file system's timestamp resolution still counts as a
+// change.
+func writeConfig(t *testing.T, path, data string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigWatcherReloadsChangedFile(t *testing.T) {
+	logs := captureLog(t)
+	path := filepath.Join(t.TempDir(), "limits.json")
+	mtime := time.Unix(1_700_000_000, 0)
+	writeConfig(t, path, `{"rate": 5, "capacity": 20, "interval": "1s"}`, mtime)
+
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Hour, WithClock(clock))
+	defer tb.Stop()
+	w, err := WatchConfig(path, tb, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	want := Config{Rate: 5, Capacity: 20, Interval: time.Second}
+	if got := tb.Config(); got != want {
+		t.Fatalf("initial config %+v, want %+v", got, want)
+	}
+
+	writeConfig(t, path, `{"rate": 50, "capacity": 200, "interval": "1m"}`, mtime.Add(time.Second))
+	clock.Advance(9 * time.Second)
+	if got := tb.Config(); got != want {
+		t.Fatalf("config changed before the watcher polled: %+v", got)
+	}
+	clock.Advance(time.Second)
+	want = Config{Rate: 50, Capacity: 200, Interval: time.Minute}
+	if got := tb.Config(); got != want {
+		t.Fatalf("after a poll: config %+v, want %+v", got, want)
+	}
+	if !strings.Contains(logs.String(), "Config reloaded from "+path) {
+		t.Fatalf("no reload logged: %q", logs.String())
+	}
+}
+
+func TestConfigWatcherKeepsLastGoodConfig(t *testing.T) {
+	logs := captureLog(t)
+	path := filepath.Join(t.TempDir(), "limits.json")
+	mtime := time.Unix(1_700_000_000, 0)
+	writeConfig(t, path, `{"rate": 5, "capacity": 20, "interval": "1s"}`, mtime)
+
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Hour, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	w, err := WatchConfig(path, tb, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	good := tb.Config()
+
+	for i, bad := range []string{
+		`{"rate": 5, "capacity": 20`,
+		`{"rate": 5, "capacity": 20, "interval": "soon"}`,
+		`{"rate": 5, "capacity": 0, "interval": "1s"}`,
+		`{"rate": -1, "capacity": 20, "interval": "1s"}`,
+	} {
+		logs.Reset()
+		mtime = mtime.Add(time.Second)
+		writeConfig(t, path, bad, mtime)
+		clock.Advance(time.Second)
+		if got := tb.Config(); got != good {
+			t.Fatalf("bad version %d applied: %+v", i, got)
+		}
+		if !strings.Contains(logs.String(), "keeping the current config") {
+			t.Fatalf("bad version %d not logged: %q", i, logs.String())
+		}
+
+		// Reported once, not on every poll.
+		logs.Reset()
+		clock.Advance(time.Second)
+		if logs.Len() != 0 {
+			t.Fatalf("bad version %d logged again: %q", i, logs.String())
+		}
+	}
+
+	writeConfig(t, path, `{"rate": 7, "capacity": 20, "interval": "1s"}`, mtime.Add(time.Second))
+	clock.Advance(time.Second)
+	if got := tb.Config().Rate; got != 7 {
+		t.Fatalf("after the file was fixed: rate %d, want 7", got)
+	}
+}
+
+func TestWatchConfigFailsOnBadFile(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.json")
+	writeConfig(t, bad, `{"rate": 5}`, time.Now())
+
+	tb := NewTokenBucket(1, 1, time.Hour)
+	defer tb.Stop()
+	for _, path := range []string{filepath.Join(dir, "missing.json"), bad} {
+		if w, err := WatchConfig(path, tb, time.Second); err == nil {
+			w.Stop()
+			t.Fatalf("%s: watching succeeded", path)
+		}
+	}
+	if got := tb.Config(); got != (Config{Rate: 1, Capacity: 1, Interval: time.Hour}) {
+		t.Fatalf("a failed start changed the bucket: %+v", got)
+	}
+}
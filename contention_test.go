@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkAllowParallel measures Allow on one shared bucket from a given
+// number of goroutines. The bucket is large enough never to deny, so only
+// the cost of the decision and its lock is measured.
+func BenchmarkAllowParallel(b *testing.B) {
+	for _, goroutines := range []int{1, 4, 16, 64, 256} {
+		b.Run(fmt.Sprintf("goroutines=%d", goroutines), func(b *testing.B) {
+			tb := NewTokenBucket(1<<40, 1<<40, time.Hour)
+			defer tb.Stop()
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				n := b.N / goroutines
+				if g < b.N%goroutines {
+					n++
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < n; i++ {
+						tb.Allow()
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// TestAllowNeverOverAllowsUnderContention hammers a bucket from many
+// goroutines while its clock moves, and checks that all of them together
+// were never allowed more than the bucket could have handed out: its
+// initial capacity plus everything earned over the run.
+func TestAllowNeverOverAllowsUnderContention(t *testing.T) {
+	const (
+		capacity   = 100
+		rate       = 5
+		interval   = 10 * time.Millisecond
+		goroutines = 256
+		steps      = 20
+		step       = interval / 4
+	)
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(rate, capacity, interval, WithClock(clock))
+	defer tb.Stop()
+
+	var allowed atomic.Int64
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				if tb.Allow() {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	for i := 0; i < steps; i++ {
+		clock.Advance(step)
+		runtime.Gosched()
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	earned := int64(rate * (steps * step / interval))
+	if got, limit := allowed.Load(), capacity+earned; got > limit {
+		t.Fatalf("allowed %d requests, more than capacity+refills = %d", got, limit)
+	}
+}
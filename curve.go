@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// AccrualCurve gives the total number of tokens a bucket has earned a given
+// time after it last ran empty. It must be monotonic non-decreasing: a
+// curve that falls stops crediting until it climbs back above its peak.
+type AccrualCurve func(elapsedSinceEmpty time.Duration) int64
+
+// WithAccrualCurve replaces the linear refill with curve, shaping how a
+// throttled client regains access. Each time the bucket runs empty (taken
+// to zero, or below it by a deny penalty) the curve starts again from
+// zero, and from then on the bucket holds what the curve has earned so far,
+// less what has been taken since, up to capacity. Before the bucket first
+// runs empty the curve is measured from its creation. The configured rate
+// and rounding mode are not used, but refills still happen every interval,
+// so a waiter is served no later than the next one.
+func WithAccrualCurve(curve AccrualCurve) Option {
+	return func(tb *TokenBucket) {
+		tb.curve = curve
+	}
+}
+
+// LinearCurve earns rate tokens per interval, like a bucket without a
+// curve but measured from the moment it ran empty.
+func LinearCurve(rate int64, interval time.Duration) AccrualCurve {
+	return func(elapsed time.Duration) int64 {
+		return clampTokens(float64(rate) * float64(elapsed) / float64(interval))
+	}
+}
+
+// ExponentialCurve starts slow and speeds up, earning rate*(2^(t/interval)-1)
+// tokens after t: behind LinearCurve for the first interval, level with it
+// at one interval, and doubling its lead every interval after that.
+func ExponentialCurve(rate int64, interval time.Duration) AccrualCurve {
+	return func(elapsed time.Duration) int64 {
+		return clampTokens(float64(rate) * (math.Exp2(float64(elapsed)/float64(interval)) - 1))
+	}
+}
+
+// clampTokens floors x to a token count that fits in an int64.
+func clampTokens(x float64) int64 {
+	if x >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return max(int64(x), 0)
+}
+
+// curveSinceLocked is when the curve started counting.
+func (tb *TokenBucket) curveSinceLocked() time.Time {
+	if tb.curveStart.IsZero() {
+		return tb.createdAt
+	}
+	return tb.curveStart
+}
+
+// accrueCurveLocked credits whatever the curve has earned since it last
+// did.
+func (tb *TokenBucket) accrueCurveLocked() {
+	earned := tb.curve(tb.clock.Now().Sub(tb.curveSinceLocked()))
+	if earned > tb.curveEarned {
+		n := earned - tb.curveEarned
+		tb.curveEarned = earned
+		tb.creditLocked(n)
+	}
+}
+
+// curveEmptiedLocked restarts the curve if the bucket has just run empty.
+func (tb *TokenBucket) curveEmptiedLocked() {
+	if tb.curve != nil && tb.tokens <= 0 {
+		tb.curveStart = tb.clock.Now()
+		tb.curveEarned = 0
+	}
+}
+
+// curveTimeUntilLocked is how long until the curve has earned deficit more
+// tokens, found by searching it, or InfDuration if it never will.
+func (tb *TokenBucket) curveTimeUntilLocked(deficit int64) time.Duration {
+	from := tb.clock.Now().Sub(tb.curveSinceLocked())
+	target := tb.curveEarned + deficit
+	if tb.curve(from) >= target {
+		return 0
+	}
+
+	// Double the step until the curve gets there, then bisect.
+	lo, hi := from, from
+	for step := time.Duration(1); tb.curve(hi) < target; step *= 2 {
+		if step > InfDuration/4 || hi > InfDuration-step {
+			return InfDuration
+		}
+		lo, hi = hi, from+step
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if tb.curve(mid) >= target {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi - from
+}
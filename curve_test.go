@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExponentialCurveSlowerEarlyFasterLater(t *testing.T) {
+	start := time.Unix(0, 0)
+	newBucket := func(curve AccrualCurve) (*TokenBucket, *ManualClock) {
+		clock := NewManualClock(start)
+		tb := NewTokenBucket(10, 1000, 10*time.Second, WithClock(clock), WithAccrualCurve(curve))
+		tb.AllowN(1000)
+		return tb, clock
+	}
+	linear, linearClock := newBucket(LinearCurve(10, 10*time.Second))
+	defer linear.Stop()
+	exp, expClock := newBucket(ExponentialCurve(10, 10*time.Second))
+	defer exp.Stop()
+
+	for _, step := range []struct {
+		at          time.Duration
+		linear, exp int64
+	}{
+		{2 * time.Second, 2, 1},
+		{5 * time.Second, 5, 4},
+		{10 * time.Second, 10, 10},
+		{20 * time.Second, 20, 30},
+		{30 * time.Second, 30, 70},
+	} {
+		linearClock.Advance(step.at - linearClock.Now().Sub(start))
+		expClock.Advance(step.at - expClock.Now().Sub(start))
+		if got := linear.AvailableTokens(); got != step.linear {
+			t.Fatalf("linear at %s: %d tokens, want %d", step.at, got, step.linear)
+		}
+		if got := exp.AvailableTokens(); got != step.exp {
+			t.Fatalf("exponential at %s: %d tokens, want %d", step.at, got, step.exp)
+		}
+	}
+}
+
+func TestAccrualCurveRestartsWhenEmptied(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 100, time.Second, WithClock(clock), WithAccrualCurve(ExponentialCurve(1, time.Second)))
+	defer tb.Stop()
+	tb.AllowN(100)
+
+	clock.Advance(3 * time.Second)
+	if got := tb.AvailableTokens(); got != 7 {
+		t.Fatalf("3s after emptying: %d tokens, want 7", got)
+	}
+	// A partial take carries on along the curve...
+	tb.AllowN(2)
+	clock.Advance(time.Second)
+	if got := tb.AvailableTokens(); got != 13 {
+		t.Fatalf("4s after emptying: %d tokens, want 15 earned less 2 taken", got)
+	}
+	// ...but emptying the bucket starts it over.
+	tb.AllowN(13)
+	clock.Advance(time.Second)
+	if got := tb.AvailableTokens(); got != 1 {
+		t.Fatalf("1s after emptying again: %d tokens, want 1", got)
+	}
+}
+
+func TestAccrualCurveTimesWaits(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 100, time.Hour, WithClock(clock), WithAccrualCurve(ExponentialCurve(1, time.Second)))
+	defer tb.Stop()
+	tb.AllowN(100)
+
+	// 2^t - 1 reaches 7 at t = 3s, well before the hourly refill.
+	if got := tb.TimeUntilAvailable(7); got != 3*time.Second {
+		t.Fatalf("TimeUntilAvailable(7) = %s, want 3s", got)
+	}
+	done := make(chan error, 1)
+	go func() { done <- tb.WaitNContext(context.Background(), 7) }()
+	waitFor(t, func() bool { return queued(tb) == 1 })
+	clock.Advance(3*time.Second - time.Nanosecond)
+	if queued(tb) != 1 {
+		t.Fatal("waiter served early")
+	}
+	clock.Advance(time.Nanosecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// WithDecay stops an idle bucket hoarding a full burst. While the bucket
+// goes unused, the most it may hold falls from capacity toward floor,
+// halving the gap every halfLife, and any tokens above that are lost.
+// Refills still top it up to the lowered ceiling. The next request, allowed
+// or not, counts as use and restores the full capacity, as does having
+// anyone queued in Wait.
+func WithDecay(floor int64, halfLife time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.decayFloor = floor
+		tb.decayHalfLife = halfLife
+	}
+}
+
+// ceilingLocked is the most the bucket may hold right now.
+func (tb *TokenBucket) ceilingLocked() int64 {
+	if tb.decayHalfLife <= 0 || tb.decayFloor >= tb.capacity || len(tb.waiters) > 0 {
+		return tb.capacity
+	}
+	since := tb.lastActive
+	if since.IsZero() {
+		since = tb.createdAt
+	}
+	idle := tb.clock.Now().Sub(since)
+	if idle <= 0 {
+		return tb.capacity
+	}
+	gap := float64(tb.capacity-tb.decayFloor) * math.Exp2(-float64(idle)/float64(tb.decayHalfLife))
+	return tb.decayFloor + int64(gap)
+}
+
+func (tb *TokenBucket) decayLocked() {
+	if tb.decayHalfLife <= 0 || tb.tokens <= tb.decayFloor {
+		return
+	}
+	if ceiling := tb.ceilingLocked(); tb.tokens > ceiling {
+		tb.tokens = max(ceiling, tb.decayFloor)
+		tb.publishLocked()
+	}
+}
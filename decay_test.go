@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecayShrinksIdleBucketTowardFloor(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1000, 24*time.Hour, WithClock(clock), WithDecay(100, time.Minute))
+	defer tb.Stop()
+
+	for _, step := range []struct {
+		at   time.Duration
+		want int64
+	}{
+		{0, 1000},
+		{time.Minute, 550},
+		{2 * time.Minute, 325},
+		{3 * time.Minute, 212},
+		{10 * time.Minute, 100},
+		{time.Hour, 100},
+	} {
+		clock.Advance(step.at - clock.Now().Sub(time.Unix(0, 0)))
+		if got := tb.AvailableTokens(); got != step.want {
+			t.Fatalf("idle for %s: %d tokens, want %d", step.at, got, step.want)
+		}
+	}
+}
+
+func TestDecayedBucketRefillsToFullCapacityOnceUsed(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(10, 20, time.Second, WithClock(clock), WithDecay(5, time.Minute))
+	defer tb.Stop()
+
+	clock.Advance(time.Hour)
+	if got := tb.AvailableTokens(); got != 5 {
+		t.Fatalf("idle: %d tokens, want the floor of 5", got)
+	}
+	// Use resets the idle time, so refills can fill the bucket again.
+	tb.Allow()
+	clock.Advance(time.Second)
+	tb.Allow()
+	if got := tb.AvailableTokens(); got != 13 {
+		t.Fatalf("in use: %d tokens, want 13", got)
+	}
+	clock.Advance(time.Second)
+	tb.Allow()
+	if got := tb.AvailableTokens(); got != 18 {
+		t.Fatalf("in use: %d tokens, want 18", got)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var defaultBucket atomic.Pointer[TokenBucket]
+
+// SetDefault makes tb the process-wide bucket behind the package-level Allow
+// and AllowN, as log.SetOutput does for the log package's helpers. It is
+// safe to call at any time, concurrently with Allow and AllowN: each call
+// uses whichever bucket was the default when it started. The bucket it
+// replaces is not stopped, since its owner may still be using it.
+func SetDefault(tb *TokenBucket) {
+	defaultBucket.Store(tb)
+}
+
+// Default returns the process-wide bucket, creating it on first use if
+// SetDefault has not been called: 10 tokens a second with a burst of 10.
+func Default() *TokenBucket {
+	if tb := defaultBucket.Load(); tb != nil {
+		return tb
+	}
+	tb := NewTokenBucket(10, 10, time.Second, WithName("default"), withoutRefillLog())
+	if !defaultBucket.CompareAndSwap(nil, tb) {
+		// Lost to another first use or a SetDefault.
+		tb.Stop()
+	}
+	return defaultBucket.Load()
+}
+
+// Allow is Default().Allow().
+func Allow() bool {
+	return Default().Allow()
+}
+
+// AllowN is Default().AllowN(n).
+func AllowN(n int64) bool {
+	return Default().AllowN(n)
+}
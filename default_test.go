@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPackageLevelAllowUsesSetDefault(t *testing.T) {
+	prev := defaultBucket.Load()
+	defer SetDefault(prev)
+
+	tb := NewTokenBucket(1, 3, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+	SetDefault(tb)
+	if Default() != tb {
+		t.Fatal("Default() is not the bucket passed to SetDefault")
+	}
+
+	if !Allow() {
+		t.Fatal("Allow() denied with 3 tokens in the default bucket")
+	}
+	if got := tb.AvailableTokens(); got != 2 {
+		t.Fatalf("default bucket has %d tokens after Allow(), want 2", got)
+	}
+	if AllowN(3) {
+		t.Fatal("AllowN(3) allowed with 2 tokens left")
+	}
+	if !AllowN(2) || Allow() {
+		t.Fatal("AllowN(2) should take the last 2 tokens and leave Allow() nothing")
+	}
+}
+
+func TestDefaultIsCreatedOnFirstUse(t *testing.T) {
+	prev := defaultBucket.Load()
+	defer SetDefault(prev)
+
+	SetDefault(nil)
+	tb := Default()
+	if tb == nil || Default() != tb {
+		t.Fatal("Default() did not create and keep a bucket")
+	}
+	defer tb.Stop()
+	if cfg := tb.Config(); cfg.Rate != 10 || cfg.Capacity != 10 || cfg.Interval != time.Second {
+		t.Fatalf("lazy default config = %+v, want 10 per second, capacity 10", cfg)
+	}
+	if !Allow() {
+		t.Fatal("Allow() denied on a fresh default bucket")
+	}
+}
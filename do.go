@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy controls how DoWithRetry retries a failed call. Each retry
+// waits Backoff, doubling after every attempt up to MaxBackoff, and then
+// acquires a fresh token before calling fn again.
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retryable decides whether an error is worth retrying. A nil Retryable
+	// retries every error.
+	Retryable func(error) bool
+}
+
+// Do waits for a token, then runs fn and returns its error.
+func (tb *TokenBucket) Do(ctx context.Context, fn func() error) error {
+	return tb.DoWithRetry(ctx, RetryPolicy{}, fn)
+}
+
+// Go waits for a token, then runs fn in a new goroutine, pacing how fast a
+// fan-out launches its workers. If ctx is done before a token is available
+// it returns ctx's error and fn is never started. Go does not wait for fn.
+func (tb *TokenBucket) Go(ctx context.Context, fn func()) error {
+	if err := tb.WaitContext(ctx); err != nil {
+		return err
+	}
+	go fn()
+	return nil
+}
+
+// DoWithRetry is Do with retries. It returns fn's last error once retries
+// are exhausted, or ctx's error if the context ends while waiting for a
+// token or sitting out a backoff. Backoffs are timed on the bucket's clock.
+func (tb *TokenBucket) DoWithRetry(ctx context.Context, policy RetryPolicy, fn func() error) error {
+	backoff := policy.Backoff
+
+	for attempt := 0; ; attempt++ {
+		if err := tb.WaitContext(ctx); err != nil {
+			return err
+		}
+
+		err := fn()
+		if err == nil || attempt >= policy.MaxRetries {
+			return err
+		}
+		if policy.Retryable != nil && !policy.Retryable(err) {
+			return err
+		}
+
+		if backoff > 0 {
+			due := make(chan struct{})
+			stop := runAt(tb.clock, tb.clock.Now().Add(backoff), func() { close(due) })
+			select {
+			case <-due:
+			case <-ctx.Done():
+				stop()
+				return ctx.Err()
+			}
+			backoff *= 2
+			if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+				backoff = policy.MaxBackoff
+			}
+		}
+	}
+}
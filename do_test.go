@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoRunsFnAfterToken(t *testing.T) {
+	tb := NewTokenBucket(1, 2, time.Hour)
+	defer tb.Stop()
+	errFn := errors.New("fn failed")
+
+	calls := 0
+	err := tb.Do(context.Background(), func() error {
+		calls++
+		return errFn
+	})
+	if err != errFn {
+		t.Fatalf("Do = %v, want %v", err, errFn)
+	}
+	if calls != 1 {
+		t.Fatalf("fn ran %d times, want 1", calls)
+	}
+	if got := tb.AvailableTokens(); got != 1 {
+		t.Fatalf("%d tokens left, want 1", got)
+	}
+}
+
+func TestDoCancelledWhileWaiting(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour)
+	defer tb.Stop()
+	tb.Allow()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	ran := false
+	go func() {
+		done <- tb.Do(ctx, func() error {
+			ran = true
+			return nil
+		})
+	}()
+	waitForQueue(t, tb, 1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do = %v, want context.Canceled", err)
+	}
+	if ran {
+		t.Fatal("fn ran without a token")
+	}
+}
+
+func TestDoWithRetryCancelledDuringBackoff(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour)
+	defer tb.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := tb.DoWithRetry(ctx, RetryPolicy{MaxRetries: 3, Backoff: time.Hour}, func() error {
+		calls++
+		cancel()
+		return errors.New("fn failed")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DoWithRetry = %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Fatalf("fn ran %d times, want 1", calls)
+	}
+}
+
+func TestDoWithRetryExhausted(t *testing.T) {
+	errFn := errors.New("fn failed")
+	tests := []struct {
+		name      string
+		retryable func(error) bool
+		wantCalls int
+	}{
+		{"retry every error", nil, 3},
+		{"not retryable", func(error) bool { return false }, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := NewTokenBucket(1, 10, time.Hour)
+			defer tb.Stop()
+
+			calls := 0
+			policy := RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, Retryable: tt.retryable}
+			err := tb.DoWithRetry(context.Background(), policy, func() error {
+				calls++
+				return errFn
+			})
+			if err != errFn {
+				t.Fatalf("DoWithRetry = %v, want %v", err, errFn)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("fn ran %d times, want %d", calls, tt.wantCalls)
+			}
+			if got, want := tb.AvailableTokens(), int64(10-tt.wantCalls); got != want {
+				t.Fatalf("%d tokens left, want %d: each attempt should take a token", got, want)
+			}
+		})
+	}
+}
+
+func TestGoPacesLaunches(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Second, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+
+	const jobs = 4
+	var launched atomic.Int64
+	errs := make(chan error, 1)
+	go func() {
+		for i := 0; i < jobs; i++ {
+			if err := tb.Go(context.Background(), func() { launched.Add(1) }); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+
+	// The first job takes the initial token; each one after it needs a
+	// refill.
+	waitFor(t, func() bool { return launched.Load() == 1 })
+	for want := int64(2); want <= jobs; want++ {
+		waitFor(t, func() bool { return queued(tb) == 1 })
+		if got := launched.Load(); got != want-1 {
+			t.Fatalf("%d launched before the refill, want %d", got, want-1)
+		}
+		clock.Advance(time.Second)
+		waitFor(t, func() bool { return launched.Load() == want })
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGoDoesNotLaunchWhenTheContextEnds(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+	tb.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var launched atomic.Bool
+	if err := tb.Go(ctx, func() { launched.Store(true) }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Go error = %v, want context.DeadlineExceeded", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if launched.Load() {
+		t.Fatal("fn ran although no token was obtained")
+	}
+}
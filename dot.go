@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DOT renders the chain as a Graphviz graph, for documenting how requests
+// flow through it: one node per bucket showing its name, rate and
+// capacity, joined in order by edges labelled "and", since a request must
+// pass them all. Pipe it to dot -Tsvg to draw it.
+func (c Chain) DOT() string {
+	return bucketsDOT("chain", "and", c)
+}
+
+// FirstAvailableDOT is Chain.DOT for buckets combined by
+// AllowFirstAvailable, with edges labelled "else", since each bucket is
+// only tried when the ones before it could not cover the request.
+func FirstAvailableDOT(buckets ...*TokenBucket) string {
+	return bucketsDOT("first_available", "else", buckets)
+}
+
+func bucketsDOT(graph, edge string, buckets []*TokenBucket) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n\trankdir=LR;\n\tnode [shape=box];\n", graph)
+	fmt.Fprintf(&b, "\trequest [shape=point];\n")
+	for i, tb := range buckets {
+		name := tb.Name()
+		if name == "" {
+			name = fmt.Sprintf("bucket %d", i)
+		}
+		cfg := tb.Config()
+		fmt.Fprintf(&b, "\tb%d [label=%s];\n", i, strconv.Quote(fmt.Sprintf("%s\n%d per %s, capacity %d", name, cfg.Rate, cfg.Interval, cfg.Capacity)))
+	}
+	for i := range buckets {
+		if i == 0 {
+			fmt.Fprintf(&b, "\trequest -> b0;\n")
+			continue
+		}
+		fmt.Fprintf(&b, "\tb%d -> b%d [label=%q];\n", i-1, i, edge)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
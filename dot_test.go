@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChainDOT(t *testing.T) {
+	client := NewTokenBucket(1, 10, time.Second, WithName("per-client"), withoutRefillLog())
+	defer client.Stop()
+	global := NewTokenBucket(100, 500, time.Minute, WithName(`global "all"`), withoutRefillLog())
+	defer global.Stop()
+	unnamed := NewTokenBucket(2, 4, time.Hour, withoutRefillLog())
+	defer unnamed.Stop()
+
+	want := `digraph chain {
+	rankdir=LR;
+	node [shape=box];
+	request [shape=point];
+	b0 [label="per-client\n1 per 1s, capacity 10"];
+	b1 [label="global \"all\"\n100 per 1m0s, capacity 500"];
+	b2 [label="bucket 2\n2 per 1h0m0s, capacity 4"];
+	request -> b0;
+	b0 -> b1 [label="and"];
+	b1 -> b2 [label="and"];
+}
+`
+	if got := (Chain{client, global, unnamed}).DOT(); got != want {
+		t.Fatalf("DOT() =\n%s\nwant\n%s", got, want)
+	}
+
+	want = `digraph first_available {
+	rankdir=LR;
+	node [shape=box];
+	request [shape=point];
+	b0 [label="per-client\n1 per 1s, capacity 10"];
+	b1 [label="bucket 1\n2 per 1h0m0s, capacity 4"];
+	request -> b0;
+	b0 -> b1 [label="else"];
+}
+`
+	if got := FirstAvailableDOT(client, unnamed); got != want {
+		t.Fatalf("FirstAvailableDOT() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+)
+
+// DrainAndWait readies the bucket for shutdown. From now on every Allow is
+// denied and any new Wait or WaitForTokens fails with ErrDraining, even if
+// limiting is suspended or disabled. Callers already queued are left to
+// finish: refills keep serving them, and they may still give up through
+// their own context. DrainAndWait returns nil once nobody is left queued,
+// or ctx's error if that takes too long. A bucket stays draining for the
+// rest of its life.
+func (tb *TokenBucket) DrainAndWait(ctx context.Context) error {
+	if tb.unset() {
+		return nil
+	}
+	tb.mu.Lock()
+	tb.draining = true
+	if tb.drained == nil {
+		tb.drained = make(chan struct{})
+		tb.signalDrainedLocked()
+	}
+	drained := tb.drained
+	tb.mu.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// signalDrainedLocked releases DrainAndWait once the queues are empty.
+func (tb *TokenBucket) signalDrainedLocked() {
+	if tb.drained == nil || len(tb.waiters) > 0 || len(tb.watchers) > 0 {
+		return
+	}
+	select {
+	case <-tb.drained:
+	default:
+		close(tb.drained)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDrainAndWaitReleasesQueuedWaiters(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 2, time.Second, WithClock(clock))
+	defer tb.Stop()
+	tb.AllowN(2)
+
+	served := make(chan error, 1)
+	go func() { served <- tb.WaitN(1) }()
+	waitForQueue(t, tb, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelled := make(chan error, 1)
+	go func() { cancelled <- tb.WaitNContext(ctx, 2) }()
+	waitForQueue(t, tb, 2)
+
+	drained := make(chan error, 1)
+	go func() { drained <- tb.DrainAndWait(context.Background()) }()
+	waitFor(t, func() bool {
+		tb.mu.Lock()
+		defer tb.mu.Unlock()
+		return tb.draining
+	})
+
+	if tb.Allow() {
+		t.Fatal("Allow admitted a request while draining")
+	}
+	if err := tb.WaitN(1); !errors.Is(err, ErrDraining) {
+		t.Fatalf("new WaitN: got %v, want ErrDraining", err)
+	}
+
+	clock.Advance(time.Second)
+	if err := <-served; err != nil {
+		t.Fatalf("queued WaitN: got %v, want it served", err)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("DrainAndWait returned %v with a waiter still queued", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled WaitN: got %v", err)
+	}
+	if err := <-drained; err != nil {
+		t.Fatalf("DrainAndWait: got %v, want nil", err)
+	}
+	if err := tb.DrainAndWait(context.Background()); err != nil {
+		t.Fatalf("second DrainAndWait: got %v, want nil", err)
+	}
+}
+
+func TestDrainAndWaitRespectsDeadline(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour)
+	defer tb.Stop()
+	tb.Allow()
+	go tb.WaitN(1)
+	waitForQueue(t, tb, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := tb.DrainAndWait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+)
+
+// WithDriftCompensation credits refills by the time that has actually
+// passed on the bucket's clock rather than by the number of ticks. A
+// ticker drops ticks when the refill goroutine falls behind and fires a
+// little late, so over days of uptime counting ticks drifts below the
+// configured rate. With this option each tick credits every whole interval
+// elapsed since the last refill, capped at capacity, and the refill schedule
+// stays anchored to the bucket's creation instead of sliding with each late
+// tick. A tick that arrives before a full interval has passed credits
+// nothing.
+func WithDriftCompensation() Option {
+	return func(tb *TokenBucket) {
+		tb.driftCompensation = true
+	}
+}
+
+// CatchUpPolicy decides what a refill credits when several intervals have
+// passed since the previous one, as after the process was paused or its
+// refill goroutine starved. Either way the refill schedule stays anchored
+// to the bucket's creation, as with WithDriftCompensation.
+type CatchUpPolicy int
+
+const (
+	// CatchUp credits every interval missed, up to capacity, so a bucket
+	// that was drained before the pause comes back as full as if the pause
+	// had not happened. It is what WithDriftCompensation does.
+	CatchUp CatchUpPolicy = iota + 1
+	// SingleStep credits one interval's worth however long the pause, so
+	// the bucket does not hand out a burst the moment it resumes.
+	SingleStep
+)
+
+// WithCatchUpPolicy sets what refills do after a pause. Without it, a
+// bucket credits one interval's worth per tick its ticker delivers, and
+// ticks that arrive late or not at all are simply lost.
+func WithCatchUpPolicy(p CatchUpPolicy) Option {
+	return func(tb *TokenBucket) {
+		tb.catchUp = p
+	}
+}
+
+// elapsedPeriodsLocked moves lastRefill on by every whole interval that has
+// passed since it and returns how many to credit, capped at what it takes
+// to fill the bucket from empty so that a long pause cannot overflow the
+// credit.
+func (tb *TokenBucket) elapsedPeriodsLocked() int64 {
+	periods := int64(tb.clock.Now().Sub(tb.lastRefill) / tb.interval)
+	if periods <= 0 {
+		return 0
+	}
+	tb.lastRefill = tb.lastRefill.Add(time.Duration(periods) * tb.interval)
+	if tb.rate > 0 {
+		periods = min(periods, tb.capacity/tb.rate+2)
+	}
+	return periods
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// jitteredRefills drives tb's refills by hand on clock for ten thousand
+// seconds' worth of ticks that each arrive up to 300ms late, with one in
+// ten dropped, as a starved ticker would deliver them. It returns how much
+// time passed and how many tokens came out.
+func jitteredRefills(tb *TokenBucket, clock *stalledClock) (elapsed time.Duration, released int64) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	start := clock.Now()
+	for i := 0; i < 10_000; i++ {
+		clock.advance(time.Second + time.Duration(rng.Int64N(int64(300*time.Millisecond))))
+		if rng.IntN(10) != 0 {
+			tb.tick()
+		}
+		n := tb.AvailableTokens()
+		tb.AllowN(n)
+		released += n
+	}
+	return clock.Now().Sub(start), released
+}
+
+func TestDriftCompensationTracksElapsedTime(t *testing.T) {
+	clock := &stalledClock{now: time.Unix(0, 0)}
+	tb := NewTokenBucket(1, 5, time.Second, WithClock(clock), WithDriftCompensation())
+	defer tb.Stop()
+	tb.AllowN(5)
+
+	elapsed, released := jitteredRefills(tb, clock)
+	if want := int64(elapsed / time.Second); released < want-1 || released > want {
+		t.Fatalf("%d tokens released over %v, want %d", released, elapsed, want)
+	}
+}
+
+func TestWithoutDriftCompensationFallsBehind(t *testing.T) {
+	clock := &stalledClock{now: time.Unix(0, 0)}
+	tb := NewTokenBucket(1, 5, time.Second, WithClock(clock))
+	defer tb.Stop()
+	tb.AllowN(5)
+
+	elapsed, released := jitteredRefills(tb, clock)
+	if want := int64(elapsed / time.Second); released > want*9/10 {
+		t.Fatalf("%d tokens released over %v, want well short of %d from ticks alone", released, elapsed, want)
+	}
+}
+
+func TestCatchUpPolicyAfterPause(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		after int64 // tokens once the refill after the pause lands
+	}{
+		{"catch up", []Option{WithCatchUpPolicy(CatchUp)}, 10},
+		{"single step", []Option{WithCatchUpPolicy(SingleStep)}, 2},
+		{"single step with drift compensation", []Option{WithDriftCompensation(), WithCatchUpPolicy(SingleStep)}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &stalledClock{now: time.Unix(0, 0)}
+			tb := NewTokenBucket(2, 10, time.Second, append(tt.opts, WithClock(clock))...)
+			defer tb.Stop()
+			tb.AllowN(10)
+
+			// Five intervals pass without a tick, then one arrives.
+			clock.advance(5 * time.Second)
+			tb.tick()
+			if got := tb.AvailableTokens(); got != tt.after {
+				t.Fatalf("after the pause: %d tokens, want %d", got, tt.after)
+			}
+
+			// Refills carry on one interval at a time on the same schedule.
+			tb.AllowN(tb.AvailableTokens())
+			clock.advance(time.Second)
+			tb.tick()
+			if got := tb.AvailableTokens(); got != 2 {
+				t.Fatalf("the next refill: %d tokens, want 2", got)
+			}
+		})
+	}
+}
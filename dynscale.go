@@ -0,0 +1,55 @@
+package main
+
+import "math"
+
+// WithDynamicScale ties the bucket to an external health signal, such as a
+// downstream's circuit-breaker state, latency or error rate: fn returns a
+// multiplier that scales both the refill rate and the capacity from their
+// configured values, so a degraded dependency is throttled harder and a
+// healthy one gets the full limits back. fn is consulted once when the
+// bucket is built and then once per refill interval, just after each
+// refill, and the multiplier it returns applies to the interval that
+// follows. Multipliers are clamped to [0, 1], and NaN counts as 0; at 0
+// no refills arrive at all. A scaled-down capacity stops refills topping
+// the bucket up past it, without taking away tokens it already holds,
+// and, as with WithDecay, anyone queued in Wait restores the full capacity
+// so a large wait is not starved. fn runs without the bucket's lock held,
+// so it may call back into the bucket.
+func WithDynamicScale(fn func() float64) Option {
+	return func(tb *TokenBucket) {
+		tb.dynamicScale = fn
+	}
+}
+
+// consultScale asks the WithDynamicScale function for the multiplier to use
+// from now on, reporting false if there is none.
+func (tb *TokenBucket) consultScale() (float64, bool) {
+	if tb.dynamicScale == nil {
+		return 0, false
+	}
+	m := tb.dynamicScale()
+	if math.IsNaN(m) {
+		return 0, true
+	}
+	return min(max(m, 0), 1), true
+}
+
+// rateScaleLocked is the fraction of the configured rate in effect now,
+// after soft start and dynamic scaling.
+func (tb *TokenBucket) rateScaleLocked() float64 {
+	scale := tb.softStartScaleLocked()
+	if tb.dynamicScale != nil {
+		scale *= tb.scale
+	}
+	return scale
+}
+
+// scaledCeilingLocked is ceilingLocked lowered to the dynamically scaled
+// capacity.
+func (tb *TokenBucket) scaledCeilingLocked() int64 {
+	ceiling := tb.ceilingLocked()
+	if tb.dynamicScale == nil || len(tb.waiters) > 0 {
+		return ceiling
+	}
+	return min(ceiling, int64(math.Ceil(float64(tb.capacity)*tb.scale)))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDynamicScaleScalesTheRealizedRate(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var health atomic.Uint64 // math.Float64bits of the multiplier
+	health.Store(math.Float64bits(1))
+	tb := NewTokenBucket(10, 10, time.Second, WithClock(clock), withoutRefillLog(),
+		WithDynamicScale(func() float64 { return math.Float64frombits(health.Load()) }))
+	defer tb.Stop()
+	tb.AllowN(10)
+
+	// released drains the bucket every second for secs seconds.
+	released := func(secs int) int64 {
+		var total int64
+		for i := 0; i < secs; i++ {
+			clock.Advance(time.Second)
+			n := tb.AvailableTokens()
+			tb.AllowN(n)
+			total += n
+		}
+		return total
+	}
+
+	if got := released(5); got != 50 {
+		t.Fatalf("healthy: %d tokens in 5s, want 50", got)
+	}
+
+	// A new multiplier is picked up at the next refill and applies from
+	// the interval after it.
+	health.Store(math.Float64bits(0.5))
+	released(1)
+	if got := released(5); got != 25 {
+		t.Fatalf("at half health: %d tokens in 5s, want 25", got)
+	}
+	clock.Advance(3 * time.Second)
+	if got := tb.AvailableTokens(); got != 5 {
+		t.Fatalf("idle at half health: %d tokens, want capacity scaled to 5", got)
+	}
+	tb.AllowN(5)
+
+	health.Store(math.Float64bits(math.NaN()))
+	released(1)
+	if got := released(3); got != 0 {
+		t.Fatalf("NaN health: %d tokens in 3s, want none", got)
+	}
+
+	health.Store(math.Float64bits(7))
+	released(1)
+	if got := released(5); got != 50 {
+		t.Fatalf("multiplier above 1: %d tokens in 5s, want it clamped to the configured 50", got)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// WithEarlyDrop starts shedding load before the bucket runs dry: once fewer
+// than threshold tokens remain, non-blocking Allow calls are denied at
+// random, with a probability rising linearly from 0 at threshold tokens to
+// 1 at none, (threshold-tokens)/threshold. Callers get backpressure earlier
+// and more smoothly than at the hard cliff at zero. Blocking waiters are
+// never dropped this way.
+func WithEarlyDrop(threshold int64) Option {
+	return func(tb *TokenBucket) {
+		tb.earlyDrop = threshold
+	}
+}
+
+// WithRand gives the bucket its own random source, e.g. a seeded one so
+// that WithEarlyDrop decisions are reproducible in tests.
+func WithRand(r *rand.Rand) Option {
+	return func(tb *TokenBucket) {
+		tb.rand = r
+	}
+}
+
+// AdmitPriority is AllowN for a request with a priority in [0, 1], values
+// outside it being clamped and NaN counting as 0, that decides how much of WithEarlyDrop's
+// shedding it is spared. It is dropped early with probability
+//
+//	(threshold-tokens)/threshold * (1-priority)
+//
+// so priority 0 is shed like any Allow call, and priority 1 is never shed
+// early, only refused once the tokens run out. Under scarcity higher
+// priorities are admitted more often, without queueing as WaitNPriority
+// does. Without WithEarlyDrop it is plain AllowN.
+func (tb *TokenBucket) AdmitPriority(n int64, priority float64) bool {
+	if tb.unset() {
+		return n >= 0
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if math.IsNaN(priority) {
+		priority = 0
+	}
+	tb.accrueLocked()
+	ok, _ := tb.admitLocked(tb.costLocked(n), 0, min(max(priority, 0), 1))
+	return ok
+}
+
+// earlyDropLocked decides whether to shed a request that is spared shield,
+// in [0, 1], of the drop probability.
+func (tb *TokenBucket) earlyDropLocked(shield float64) bool {
+	if tb.earlyDrop <= 0 || tb.tokens >= tb.earlyDrop || tb.bypassLocked() {
+		return false
+	}
+	p := float64(tb.earlyDrop-tb.tokens) / float64(tb.earlyDrop) * (1 - shield)
+	if tb.rand != nil {
+		return tb.rand.Float64() < p
+	}
+	return rand.Float64() < p
+}
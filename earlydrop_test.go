@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestEarlyDropDenyRateFollowsCurve(t *testing.T) {
+	tests := []struct {
+		tokens int64
+		want   float64
+	}{
+		{8, 0},
+		{6, 0.25},
+		{4, 0.5},
+		{2, 0.75},
+	}
+	for _, tt := range tests {
+		tb := NewTokenBucket(1, 8, time.Hour, WithEarlyDrop(8), WithRand(rand.New(rand.NewPCG(1, 2))))
+		tb.AllowN(8 - tt.tokens)
+
+		const trials = 10000
+		denied := 0
+		for i := 0; i < trials; i++ {
+			if tb.Allow() {
+				// Hold the balance where it is.
+				tb.Refund(1)
+			} else {
+				denied++
+			}
+		}
+		tb.Stop()
+
+		if got := float64(denied) / trials; got < tt.want-0.02 || got > tt.want+0.02 {
+			t.Errorf("with %d of 8 tokens: deny rate %.3f, want %.2f", tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func TestAdmitPriorityFavoursHighPriority(t *testing.T) {
+	tb := NewTokenBucket(1, 8, time.Hour, WithEarlyDrop(8), WithRand(rand.New(rand.NewPCG(1, 2))))
+	defer tb.Stop()
+	// 2 of 8 tokens left: a plain Allow is dropped 75% of the time.
+	tb.AllowN(6)
+
+	admitRate := func(priority float64) float64 {
+		const trials = 10000
+		admitted := 0
+		for i := 0; i < trials; i++ {
+			if tb.AdmitPriority(1, priority) {
+				admitted++
+				tb.Refund(1)
+			}
+		}
+		return float64(admitted) / trials
+	}
+	for _, tt := range []struct {
+		priority, want float64
+	}{
+		{0, 0.25},
+		{0.5, 0.625},
+		{1, 1},
+		{-3, 0.25},
+		{7, 1},
+		{math.NaN(), 0.25},
+	} {
+		if got := admitRate(tt.priority); got < tt.want-0.02 || got > tt.want+0.02 {
+			t.Errorf("priority %v: admitted %.3f, want %.3f", tt.priority, got, tt.want)
+		}
+	}
+
+	// Priority spares only the early drop, not the hard limit. Draining
+	// is itself subject to early drop, so keep at it until it lands.
+	for tb.Stats().Tokens > 0 {
+		tb.AllowN(tb.Stats().Tokens)
+	}
+	if tb.AdmitPriority(1, 1) {
+		t.Fatal("admitted with no tokens left")
+	}
+}
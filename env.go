@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultEnvInterval = time.Second
+
+// NewFromEnv builds a bucket from <prefix>_RATE and <prefix>_CAPACITY, both
+// required integers, and <prefix>_INTERVAL, an optional Go duration string
+// such as "2s" that defaults to one second. Errors name the variable at
+// fault.
+func NewFromEnv(prefix string, opts ...Option) (*TokenBucket, error) {
+	cfg, err := ConfigFromEnv(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return NewTokenBucketFromConfig(cfg, opts...)
+}
+
+func ConfigFromEnv(prefix string) (Config, error) {
+	cfg := Config{Interval: defaultEnvInterval}
+
+	rate, err := envInt(prefix + "_RATE")
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.Rate = rate
+
+	capacity, err := envInt(prefix + "_CAPACITY")
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.Capacity = capacity
+
+	if v, ok := os.LookupEnv(prefix + "_INTERVAL"); ok {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("%s_INTERVAL: %w", prefix, err)
+		}
+		cfg.Interval = interval
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", prefix, err)
+	}
+	return cfg, nil
+}
+
+func envInt(name string) (int64, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return 0, fmt.Errorf("%s: not set", name)
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewFromEnv(t *testing.T) {
+	t.Setenv("API_RATE", "5")
+	t.Setenv("API_CAPACITY", "20")
+	t.Setenv("API_INTERVAL", "2s")
+
+	tb, err := NewFromEnv("API")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tb.Stop()
+	want := Config{Rate: 5, Capacity: 20, Interval: 2 * time.Second}
+	if got := tb.Config(); got != want {
+		t.Fatalf("Config() = %+v, want %+v", got, want)
+	}
+}
+
+func TestConfigFromEnvDefaultsInterval(t *testing.T) {
+	t.Setenv("API_RATE", "5")
+	t.Setenv("API_CAPACITY", "20")
+
+	cfg, err := ConfigFromEnv("API")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Interval != time.Second {
+		t.Fatalf("Interval = %v, want 1s", cfg.Interval)
+	}
+}
+
+func TestConfigFromEnvNamesBadVariable(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"missing rate", map[string]string{"API_CAPACITY": "20"}, "API_RATE"},
+		{"missing capacity", map[string]string{"API_RATE": "5"}, "API_CAPACITY"},
+		{"malformed rate", map[string]string{"API_RATE": "five", "API_CAPACITY": "20"}, "API_RATE"},
+		{"malformed interval", map[string]string{"API_RATE": "5", "API_CAPACITY": "20", "API_INTERVAL": "2 seconds"}, "API_INTERVAL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// t.Setenv first so the variables are restored afterwards.
+			for _, name := range []string{"API_RATE", "API_CAPACITY", "API_INTERVAL"} {
+				t.Setenv(name, "")
+				os.Unsetenv(name)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := ConfigFromEnv("API")
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr+":") {
+				t.Fatalf("ConfigFromEnv() error = %v, want one naming %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RateLimitError is implemented by every error that explains why the bucket
+// turned a request down, so callers can tell the reasons apart with
+// errors.As, either for the family as a whole or for one concrete type:
+//
+//	var spacing *SpacingError
+//	if errors.As(err, &spacing) {
+//		time.Sleep(time.Until(spacing.NextAllowed))
+//	}
+//
+// Errors that are not about the budget, such as ErrStopped,
+// ErrNegativeTokens or a done context's error, are returned as they are.
+type RateLimitError interface {
+	error
+	rateLimit()
+}
+
+// EmptyError means the bucket held too few tokens. Needed is what the
+// request cost, Available the balance it found, and RetryAfter how long
+// until Needed will be there, or InfDuration if it never will be. A request
+// shed by WithEarlyDrop while tokens remained is reported this way too.
+type EmptyError struct {
+	Needed     int64
+	Available  int64
+	RetryAfter time.Duration
+}
+
+func (e *EmptyError) Error() string {
+	return fmt.Sprintf("rate limited: need %d tokens, %d available", e.Needed, e.Available)
+}
+
+// ExceedsCapacityError means the request can never succeed because it costs
+// more than the bucket holds. N is the cost and Capacity the bucket's
+// capacity at the time. It matches ErrTokensExceedCapacity with errors.Is.
+type ExceedsCapacityError struct {
+	N        int64
+	Capacity int64
+}
+
+func (e *ExceedsCapacityError) Error() string {
+	return fmt.Sprintf("%s: %d > %d", ErrTokensExceedCapacity, e.N, e.Capacity)
+}
+
+func (e *ExceedsCapacityError) Is(target error) bool {
+	return target == ErrTokensExceedCapacity
+}
+
+// CooldownError means the bucket is paying off a WithDenyPenalty debt: its
+// balance is below zero. Until is when the request will be affordable again
+// if nothing else is consumed meanwhile.
+type CooldownError struct {
+	Until time.Time
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("rate limited: cooling down until %s", e.Until.Format(time.RFC3339Nano))
+}
+
+// SpacingError means tokens were available but WithMinSpacing held the
+// request back. NextAllowed is when the spacing lets the next one through.
+type SpacingError struct {
+	NextAllowed time.Time
+}
+
+func (e *SpacingError) Error() string {
+	return fmt.Sprintf("rate limited: next request allowed at %s", e.NextAllowed.Format(time.RFC3339Nano))
+}
+
+func (*EmptyError) rateLimit()           {}
+func (*ExceedsCapacityError) rateLimit() {}
+func (*CooldownError) rateLimit()        {}
+func (*SpacingError) rateLimit()         {}
+
+// Take is AllowN that says why a request was denied: nil when the tokens
+// were consumed, otherwise a RateLimitError, ErrNegativeTokens,
+// ErrDraining, or ErrStopped for a bucket stopped WithDenyAfterStop.
+func (tb *TokenBucket) Take(n int64) error {
+	if n < 0 {
+		return ErrNegativeTokens
+	}
+	if tb.unset() {
+		return nil
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	n = tb.costLocked(n)
+	// Judged before the decision, which may charge a deny penalty.
+	err := tb.denyReasonLocked(n)
+	if tb.allowLocked(n, 0) {
+		return nil
+	}
+	return err
+}
+
+// denyReasonLocked is the error a denial of n would be reported with.
+func (tb *TokenBucket) denyReasonLocked(n int64) error {
+	switch tb.denyKindLocked(n) {
+	case DenyStopped:
+		return ErrStopped
+	case DenyDraining:
+		return ErrDraining
+	case DenyOverCapacity:
+		return &ExceedsCapacityError{N: n, Capacity: tb.capacity}
+	case DenyCooldown:
+		return &CooldownError{Until: tb.clock.Now().Add(tb.timeUntilTakeLocked(n))}
+	case DenySpacing:
+		return &SpacingError{NextAllowed: tb.lastAllowed.Add(tb.minSpacing)}
+	default:
+		return &EmptyError{Needed: n, Available: tb.tokens, RetryAfter: tb.timeUntilTakeLocked(n)}
+	}
+}
+
+// DenyReason classifies a denial: one for each error Take can report it
+// with, plus the checks of particular calls that refuse a request while
+// tokens remain, which Take reports as an EmptyError. The set is fixed, so
+// it is safe to use as a metrics label.
+type DenyReason int
+
+const (
+	DenyEmpty DenyReason = iota
+	DenyOverCapacity
+	DenyCooldown
+	DenySpacing
+	DenyDraining
+	DenyStopped
+	DenyFloor     // AllowNSoft: the request would leave less than its floor
+	DenyWatermark // AllowAboveWatermark: the balance is below the watermark
+	DenyEarlyDrop // shed by WithEarlyDrop while tokens remained
+	DenyPolicy    // a per-call policy such as AllowNFair's share refused it
+	numDenyReasons
+
+	// denyInferred asks takeLocked to work the reason out from the
+	// bucket's state.
+	denyInferred DenyReason = -1
+)
+
+var denyReasonNames = [numDenyReasons]string{"empty", "over_capacity", "cooldown", "spacing", "draining", "stopped", "floor", "watermark", "early_drop", "policy"}
+
+func (r DenyReason) String() string {
+	if r < 0 || r >= numDenyReasons {
+		return fmt.Sprintf("DenyReason(%d)", int(r))
+	}
+	return denyReasonNames[r]
+}
+
+// DenyCounts counts denials by reason, indexed by DenyReason.
+type DenyCounts [numDenyReasons]int64
+
+// MarshalJSON renders the counts as an object keyed by reason name, such as
+// {"empty":3,"spacing":1,...}, with every reason present so dashboards see
+// the same keys whether or not a reason has occurred yet.
+func (c DenyCounts) MarshalJSON() ([]byte, error) {
+	m := make(map[string]int64, len(c))
+	for r, n := range c {
+		m[DenyReason(r).String()] = n
+	}
+	return json.Marshal(m)
+}
+
+// String renders the counts as, e.g., "{empty=3 spacing=1}", in DenyReason
+// order and leaving out reasons that never occurred.
+func (c DenyCounts) String() string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for r, n := range c {
+		if n == 0 {
+			continue
+		}
+		if b.Len() > 1 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%d", DenyReason(r), n)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// denyKindLocked is the reason a denial of n would be counted under, absent
+// a floor or a policy check.
+func (tb *TokenBucket) denyKindLocked(n int64) DenyReason {
+	switch {
+	case tb.stopped && tb.denyAfterStop:
+		return DenyStopped
+	case tb.draining:
+		return DenyDraining
+	case !tb.bypassLocked() && n > tb.capacity:
+		return DenyOverCapacity
+	case tb.tokens < 0:
+		return DenyCooldown
+	case tb.tokens >= n && !tb.spacedLocked():
+		return DenySpacing
+	default:
+		return DenyEmpty
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTakeReportsDenyReason(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name  string
+		opts  []Option
+		setup func(tb *TokenBucket)
+		n     int64
+		check func(t *testing.T, err error)
+		// reason is what the denial is counted under, or -1 if it isn't.
+		reason DenyReason
+	}{
+		{
+			name:  "empty",
+			setup: func(tb *TokenBucket) { tb.AllowN(8) },
+			n:     3,
+			check: func(t *testing.T, err error) {
+				var empty *EmptyError
+				if !errors.As(err, &empty) || *empty != (EmptyError{Needed: 3, Available: 2, RetryAfter: 500 * time.Millisecond}) {
+					t.Fatalf("got %#v, want an EmptyError needing 3 of 2, retry in 500ms", err)
+				}
+			},
+			reason: DenyEmpty,
+		},
+		{
+			name: "exceeds capacity",
+			n:    11,
+			check: func(t *testing.T, err error) {
+				var exceeds *ExceedsCapacityError
+				if !errors.As(err, &exceeds) || *exceeds != (ExceedsCapacityError{N: 11, Capacity: 10}) {
+					t.Fatalf("got %#v, want an ExceedsCapacityError of 11 > 10", err)
+				}
+				if !errors.Is(err, ErrTokensExceedCapacity) {
+					t.Fatal("does not match ErrTokensExceedCapacity")
+				}
+			},
+			reason: DenyOverCapacity,
+		},
+		{
+			name: "cooldown",
+			opts: []Option{WithDenyPenalty(4)},
+			// The denial charges the penalty and leaves the bucket at -4.
+			setup: func(tb *TokenBucket) { tb.AllowN(10); tb.AllowN(1) },
+			n:     1,
+			check: func(t *testing.T, err error) {
+				var cooldown *CooldownError
+				if !errors.As(err, &cooldown) || !cooldown.Until.Equal(start.Add(2500*time.Millisecond)) {
+					t.Fatalf("got %#v, want a CooldownError until 2.5s in", err)
+				}
+			},
+			reason: DenyCooldown,
+		},
+		{
+			name:  "spacing",
+			opts:  []Option{WithMinSpacing(time.Second)},
+			setup: func(tb *TokenBucket) { tb.Allow() },
+			n:     1,
+			check: func(t *testing.T, err error) {
+				var spacing *SpacingError
+				if !errors.As(err, &spacing) || !spacing.NextAllowed.Equal(start.Add(time.Second)) {
+					t.Fatalf("got %#v, want a SpacingError for 1s in", err)
+				}
+			},
+			reason: DenySpacing,
+		},
+		{
+			name:  "stopped",
+			opts:  []Option{WithDenyAfterStop(true)},
+			setup: func(tb *TokenBucket) { tb.Stop() },
+			n:     1,
+			check: func(t *testing.T, err error) {
+				if err != ErrStopped {
+					t.Fatalf("got %v, want ErrStopped", err)
+				}
+			},
+			reason: DenyStopped,
+		},
+		{
+			name: "negative",
+			n:    -1,
+			check: func(t *testing.T, err error) {
+				if err != ErrNegativeTokens {
+					t.Fatalf("got %v, want ErrNegativeTokens", err)
+				}
+			},
+			reason: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(start)
+			tb := NewTokenBucket(2, 10, time.Second, append(tt.opts, WithClock(clock))...)
+			defer tb.Stop()
+			if tt.setup != nil {
+				tt.setup(tb)
+			}
+
+			before := tb.Stats().DeniedBy
+			err := tb.Take(tt.n)
+			tt.check(t, err)
+			want := before
+			if tt.reason >= 0 {
+				want[tt.reason]++
+			}
+			if got := tb.Stats().DeniedBy; got != want {
+				t.Fatalf("denials by reason went from %v to %v, want %v", before, got, want)
+			}
+
+			// Only the budget reasons belong to the family.
+			var rl RateLimitError
+			if budget := err != ErrStopped && err != ErrNegativeTokens; errors.As(err, &rl) != budget {
+				t.Fatalf("errors.As(%v, RateLimitError) = %v, want %v", err, !budget, budget)
+			}
+		})
+	}
+}
+
+func TestDeniedByPolicyChecks(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		deny   func(tb *TokenBucket) bool
+		reason DenyReason
+	}{
+		{
+			name:   "floor",
+			deny:   func(tb *TokenBucket) bool { return tb.AllowNSoft(4, 8) },
+			reason: DenyFloor,
+		},
+		{
+			name:   "floor above an over-capacity request",
+			deny:   func(tb *TokenBucket) bool { return tb.AllowNSoft(11, 1) },
+			reason: DenyOverCapacity,
+		},
+		{
+			name:   "watermark",
+			deny:   func(tb *TokenBucket) bool { return tb.AllowAboveWatermark(1, 11) },
+			reason: DenyWatermark,
+		},
+		{
+			// Every call falls under a threshold this far above the
+			// balance, so the full bucket sheds it.
+			name:   "early drop",
+			opts:   []Option{WithEarlyDrop(math.MaxInt64)},
+			deny:   func(tb *TokenBucket) bool { return tb.Allow() },
+			reason: DenyEarlyDrop,
+		},
+		{
+			name:   "early drop of a request that would not fit anyway",
+			opts:   []Option{WithEarlyDrop(math.MaxInt64)},
+			deny:   func(tb *TokenBucket) bool { return tb.AllowN(11) },
+			reason: DenyOverCapacity,
+		},
+		{
+			name: "fair share",
+			deny: func(tb *TokenBucket) bool {
+				tb.AllowNFair(2)
+				return tb.AllowNFair(1)
+			},
+			reason: DenyPolicy,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := make(chan Decision, 4)
+			tb := NewTokenBucket(2, 10, time.Second, append(tt.opts, WithAuditSink(sink), WithHistory(4), withoutRefillLog())...)
+			defer tb.Stop()
+
+			if tt.deny(tb) {
+				t.Fatal("request allowed, want it denied")
+			}
+			var want DenyCounts
+			want[tt.reason] = 1
+			if got := tb.Stats().DeniedBy; got != want {
+				t.Fatalf("denials by reason = %v, want %v", got, want)
+			}
+			var last Decision
+			for len(sink) > 0 {
+				last = <-sink
+			}
+			if last.Allowed || last.Reason != tt.reason {
+				t.Fatalf("audited decision = %+v, want denied for %v", last, tt.reason)
+			}
+			if h := tb.History(); len(h) == 0 || h[len(h)-1].Reason != tt.reason {
+				t.Fatalf("history = %+v, want the denial last, for %v", h, tt.reason)
+			}
+		})
+	}
+}
+
+func TestTakeSucceedsWithNil(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+	if err := tb.Take(10); err != nil {
+		t.Fatalf("Take(10) = %v, want nil", err)
+	}
+	if got := tb.AvailableTokens(); got != 0 {
+		t.Fatalf("%d tokens left, want 0", got)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+)
+
+// WithCostEstimate lets requests whose cost is only known afterwards be
+// charged up front from experience: Observe feeds in each request's actual
+// cost, and AllowEstimated charges an exponentially weighted moving average
+// of them. alpha, between 0 and 1, is the weight of each new observation;
+// higher values follow changes sooner, lower ones smooth out spikes more.
+// The estimate always lags reality: after a step change in cost it takes
+// a few observations, more the lower alpha is, to catch up.
+func WithCostEstimate(alpha float64) Option {
+	return func(tb *TokenBucket) {
+		tb.costAlpha = min(max(alpha, 0), 1)
+	}
+}
+
+// Observe records what a request actually cost, for AllowEstimated. The
+// first observation becomes the estimate outright; without WithCostEstimate
+// later ones have no weight, so it stays there.
+func (tb *TokenBucket) Observe(cost int64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if !tb.costObserved {
+		tb.costEstimate = float64(cost)
+		tb.costObserved = true
+		return
+	}
+	tb.costEstimate += tb.costAlpha * (float64(cost) - tb.costEstimate)
+}
+
+// EstimatedCost is what AllowEstimated charges now: the moving average of
+// observed costs rounded up, so a fractional cost is not undercharged, or 1
+// before anything has been observed.
+func (tb *TokenBucket) EstimatedCost() int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.estimatedCostLocked()
+}
+
+func (tb *TokenBucket) estimatedCostLocked() int64 {
+	if !tb.costObserved {
+		return 1
+	}
+	return max(int64(math.Ceil(tb.costEstimate)), 0)
+}
+
+// AllowEstimated is AllowN for the estimated cost of a request.
+func (tb *TokenBucket) AllowEstimated() bool {
+	if tb.unset() {
+		return true
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	return tb.allowLocked(tb.costLocked(tb.estimatedCostLocked()), 0)
+}
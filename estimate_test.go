@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowEstimatedConvergesOnAStepChange(t *testing.T) {
+	tb := NewTokenBucket(1, 1000, time.Hour, WithCostEstimate(0.5), withoutRefillLog())
+	defer tb.Stop()
+
+	if got := tb.EstimatedCost(); got != 1 {
+		t.Fatalf("estimate with no observations = %d, want 1", got)
+	}
+	for i := 0; i < 3; i++ {
+		tb.Observe(10)
+	}
+	if got := tb.EstimatedCost(); got != 10 {
+		t.Fatalf("estimate after steady cost 10 = %d, want 10", got)
+	}
+
+	// Cost steps up to 50: the estimate lags, closing half the gap each
+	// time, and never overshoots.
+	var charges []int64
+	for i := 0; i < 6; i++ {
+		tb.Observe(50)
+		before := tb.AvailableTokens()
+		if !tb.AllowEstimated() {
+			t.Fatalf("AllowEstimated denied with %d tokens", before)
+		}
+		charges = append(charges, before-tb.AvailableTokens())
+	}
+	want := []int64{30, 40, 45, 48, 49, 50}
+	for i := range want {
+		if charges[i] != want[i] {
+			t.Fatalf("charges after the step = %v, want %v", charges, want)
+		}
+	}
+}
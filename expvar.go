@@ -0,0 +1,21 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+)
+
+// PublishExpvar registers the bucket's Stats as the expvar variable name,
+// taken afresh every time the variable is read, so it shows up in
+// /debug/vars wherever expvar.Handler is served. expvar names are global to
+// the process and can't be unregistered: publishing two buckets under one
+// name is an error rather than the panic expvar.Publish would give.
+func (tb *TokenBucket) PublishExpvar(name string) error {
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %q already published", name)
+	}
+	expvar.Publish(name, expvar.Func(func() any {
+		return tb.Stats()
+	}))
+	return nil
+}
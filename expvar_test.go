@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// expvarTokens reads the tokens field of the Stats published as name.
+func expvarTokens(t *testing.T, name string) int64 {
+	t.Helper()
+	var got struct{ Tokens int64 }
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &got); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return got.Tokens
+}
+
+func TestPublishExpvarReportsCurrentTokens(t *testing.T) {
+	search := NewTokenBucket(1, 10, time.Hour)
+	defer search.Stop()
+	upload := NewTokenBucket(1, 4, time.Hour)
+	defer upload.Stop()
+	// Unique per run, since expvar names can't be unregistered.
+	searchVar, uploadVar := fmt.Sprintf("test.search.%d", search.id), fmt.Sprintf("test.upload.%d", upload.id)
+	if err := search.PublishExpvar(searchVar); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.PublishExpvar(uploadVar); err != nil {
+		t.Fatal(err)
+	}
+
+	search.AllowN(3)
+	if got := expvarTokens(t, searchVar); got != 7 {
+		t.Fatalf("search: %d tokens, want 7", got)
+	}
+	if got := expvarTokens(t, uploadVar); got != 4 {
+		t.Fatalf("upload: %d tokens, want 4", got)
+	}
+	upload.AllowN(4)
+	if got := expvarTokens(t, uploadVar); got != 0 {
+		t.Fatalf("upload after AllowN(4): %d tokens, want 0", got)
+	}
+}
+
+func TestPublishExpvarRejectsTakenName(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour)
+	defer tb.Stop()
+	name := fmt.Sprintf("test.taken.%d", tb.id)
+	if err := tb.PublishExpvar(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := tb.PublishExpvar(name); err == nil {
+		t.Fatal("publishing a name twice succeeded")
+	}
+}
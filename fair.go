@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// FairLimiter shares one bucket between many keys. While the pool has
+// plenty of tokens it is first come, first served. Once the balance drops to
+// the contention threshold (a quarter of capacity), a key is only admitted
+// if its recent consumption divided by its weight is no higher than the
+// average across recently active keys, so heavy users are held back until
+// lighter ones catch up. Recent consumption halves every bucket interval,
+// which lets a key that went quiet regain its share.
+type FairLimiter struct {
+	tb *TokenBucket
+
+	mu        sync.Mutex
+	tenants   map[string]*fairTenant
+	lastDecay time.Time
+}
+
+type fairTenant struct {
+	usage  float64
+	weight float64
+}
+
+func NewFairLimiter(tb *TokenBucket) *FairLimiter {
+	return &FairLimiter{
+		tb:        tb,
+		tenants:   make(map[string]*fairTenant),
+		lastDecay: tb.clock.Now(),
+	}
+}
+
+// AllowKey takes a token from the shared bucket on behalf of key. Weights
+// are relative; a weight of 2 entitles a key to twice the share of a key
+// with weight 1. Non-positive weights count as 1.
+func (f *FairLimiter) AllowKey(key string, weight float64) bool {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.decayLocked()
+
+	t := f.tenants[key]
+	if t == nil {
+		t = &fairTenant{}
+		f.tenants[key] = t
+	}
+	t.weight = weight
+
+	threshold := f.tb.Config().Capacity / 4
+	if f.tb.AvailableTokens() <= threshold && t.usage/t.weight > f.meanShareLocked() {
+		return false
+	}
+
+	if !f.tb.Allow() {
+		return false
+	}
+	t.usage++
+	return true
+}
+
+func (f *FairLimiter) meanShareLocked() float64 {
+	total := 0.0
+	for _, t := range f.tenants {
+		total += t.usage / t.weight
+	}
+	return total / float64(len(f.tenants))
+}
+
+func (f *FairLimiter) decayLocked() {
+	interval := f.tb.Config().Interval
+	now := f.tb.clock.Now()
+	if now.Sub(f.lastDecay) >= 32*interval {
+		f.tenants = make(map[string]*fairTenant)
+		f.lastDecay = now
+		return
+	}
+	for now.Sub(f.lastDecay) >= interval {
+		f.lastDecay = f.lastDecay.Add(interval)
+		for k, t := range f.tenants {
+			if t.usage /= 2; t.usage < 0.01 {
+				delete(f.tenants, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFairLimiterEqualWeightsShareEqually(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 8, 100*time.Millisecond, WithClock(clock))
+	defer tb.Stop()
+	f := NewFairLimiter(tb)
+
+	// Both tenants want more than the pool refills, and the noisy one asks
+	// four times as often, so past the initial burst the pool stays
+	// contended.
+	var noisy, quiet int
+	for i := 0; i < 400; i++ {
+		clock.Advance(100 * time.Millisecond)
+		for j := 0; j < 4; j++ {
+			if f.AllowKey("noisy", 1) {
+				noisy++
+			}
+			if j == 0 && f.AllowKey("quiet", 1) {
+				quiet++
+			}
+		}
+	}
+
+	total := noisy + quiet
+	if share := float64(quiet) / float64(total); share < 0.45 || share > 0.55 {
+		t.Fatalf("quiet tenant got %d of %d tokens (%.0f%%), want close to half", quiet, total, 100*share)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+// AllowNFair is AllowN with intra-interval pacing on top of the burst
+// budget: besides needing n tokens, it is denied if the tokens AllowNFair
+// has already admitted in the current interval window, plus n, would come
+// to more than rate, one interval's refill. So callers going through it
+// cannot spend more than an interval's share at once, even from a full
+// bucket; other calls spend from the balance as usual without using up the
+// share. Windows are whole intervals counted from the bucket's creation,
+// and the allotment starts afresh at each one. A fixed quota (rate 0) has
+// no per-interval share, and only the balance applies.
+func (tb *TokenBucket) AllowNFair(n int64) bool {
+	if tb.unset() {
+		return n >= 0
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	n = tb.costLocked(n)
+	if tb.rate > 0 && tb.interval > 0 {
+		window := int64(tb.clock.Now().Sub(tb.createdAt) / tb.interval)
+		if window != tb.fairWindow {
+			tb.fairWindow = window
+			tb.fairUsed = 0
+		}
+		if tb.fairUsed+n > tb.rate {
+			return tb.denyLocked(n, DenyPolicy)
+		}
+	}
+	before := tb.consumed
+	ok := tb.allowLocked(n, 0)
+	tb.fairUsed += tb.consumed - before
+	return ok
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowNFairCapsEachIntervalAtTheRate(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(3, 10, time.Second, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+
+	if !tb.AllowNFair(2) || !tb.AllowNFair(1) {
+		t.Fatal("denied within the interval's allotment of 3")
+	}
+	if tb.AllowNFair(1) {
+		t.Fatal("allowed past the allotment with burst tokens left")
+	}
+	if got := tb.AvailableTokens(); got != 7 {
+		t.Fatalf("tokens = %d, want 7 left unspent", got)
+	}
+	if !tb.AllowN(1) {
+		t.Fatal("plain AllowN is not subject to the allotment")
+	}
+	clock.Advance(time.Second)
+	tb.AllowN(2)
+	if !tb.AllowNFair(3) {
+		t.Fatal("plain AllowN used up the next interval's allotment")
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if tb.AllowNFair(1) {
+		t.Fatal("allowed later in the same interval")
+	}
+	clock.Advance(500 * time.Millisecond)
+	if !tb.AllowNFair(3) || tb.AllowNFair(1) {
+		t.Fatal("the next interval did not get a fresh allotment of exactly 3")
+	}
+	if tb.AllowNFair(4) {
+		t.Fatal("allowed a request larger than one interval's share")
+	}
+
+	quota := NewTokenBucket(0, 5, time.Second, withoutRefillLog())
+	defer quota.Stop()
+	if !quota.AllowNFair(5) {
+		t.Fatal("a fixed quota applied a per-interval share")
+	}
+}
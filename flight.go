@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// LimitedGroup combines a bucket with singleflight-style deduplication, for
+// cache-miss stampedes: concurrent DoLimited calls with the same key share a
+// single call of fn, and only that one is rate limited.
+type LimitedGroup struct {
+	tb *TokenBucket
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done      chan struct{}
+	val       any
+	err       error
+	followers int // callers waiting on the leader
+}
+
+func NewLimitedGroup(tb *TokenBucket) *LimitedGroup {
+	return &LimitedGroup{tb: tb, flights: make(map[string]*flight)}
+}
+
+// DoLimited runs fn for key unless a call for key is already in flight, in
+// which case it waits for that call and returns its result, with shared
+// set. The first caller, the leader, waits for a token from the bucket and
+// then calls fn; callers that join it consume nothing. If the leader can't
+// get a token its error is what every caller in the flight gets, and fn
+// does not run. A follower whose ctx ends stops waiting and returns ctx's
+// error, while the flight carries on for the rest.
+//
+// A panic in fn is re-raised in the leader; followers get an error instead.
+// Once a flight is over, the next call for key starts a new one.
+func (g *LimitedGroup) DoLimited(ctx context.Context, key string, fn func() (any, error)) (v any, err error, shared bool) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		f.followers++
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.val, f.err, true
+		case <-ctx.Done():
+			g.mu.Lock()
+			f.followers--
+			g.mu.Unlock()
+			return nil, ctx.Err(), true
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	defer func() {
+		if p := recover(); p != nil {
+			f.err = fmt.Errorf("call for %q panicked: %v", key, p)
+			g.finish(key, f)
+			panic(p)
+		}
+		g.finish(key, f)
+	}()
+	if f.err = g.tb.WaitContext(ctx); f.err == nil {
+		f.val, f.err = fn()
+	}
+	return f.val, f.err, false
+}
+
+// Followers reports how many callers are waiting on the call in flight for
+// key besides its leader, which is how much load deduplication is saving
+// right now. inFlight is false when no call for key is running.
+func (g *LimitedGroup) Followers(key string) (n int, inFlight bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.flights[key]; ok {
+		return f.followers, true
+	}
+	return 0, false
+}
+
+func (g *LimitedGroup) finish(key string, f *flight) {
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// joined reports how many callers have joined the flight for key, or -1 if
+// there is none.
+func joined(g *LimitedGroup, key string) int {
+	if n, ok := g.Followers(key); ok {
+		return n
+	}
+	return -1
+}
+
+func TestDoLimitedDeduplicatesConcurrentCalls(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+	g := NewLimitedGroup(tb)
+
+	const callers = 20
+	release := make(chan struct{})
+	runs := 0
+	fn := func() (any, error) {
+		runs++
+		<-release
+		return "value", nil
+	}
+
+	type result struct {
+		v      any
+		err    error
+		shared bool
+	}
+	results := make(chan result, callers)
+	var wg sync.WaitGroup
+	call := func() {
+		defer wg.Done()
+		v, err, shared := g.DoLimited(context.Background(), "k", fn)
+		results <- result{v, err, shared}
+	}
+	wg.Add(1)
+	go call()
+	waitFor(t, func() bool { return joined(g, "k") == 0 })
+	for i := 1; i < callers; i++ {
+		wg.Add(1)
+		go call()
+	}
+	waitFor(t, func() bool { return joined(g, "k") == callers-1 })
+	close(release)
+	wg.Wait()
+	close(results)
+
+	var leaders int
+	for r := range results {
+		if r.v != "value" || r.err != nil {
+			t.Fatalf("got %v, %v; want the shared value", r.v, r.err)
+		}
+		if !r.shared {
+			leaders++
+		}
+	}
+	if runs != 1 || leaders != 1 {
+		t.Fatalf("fn ran %d times with %d leaders, want once with one", runs, leaders)
+	}
+	if got := tb.TotalConsumed(); got != 1 {
+		t.Fatalf("consumed %d tokens, want 1", got)
+	}
+
+	// The flight is over; the next call runs fn again.
+	if _, _, shared := g.DoLimited(context.Background(), "k", func() (any, error) { return nil, nil }); shared {
+		t.Fatal("a later call joined a finished flight")
+	}
+	if got := tb.TotalConsumed(); got != 2 {
+		t.Fatalf("consumed %d tokens, want 2", got)
+	}
+}
+
+func TestDoLimitedSharesLeadersDenial(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour)
+	defer tb.Stop()
+	tb.Allow()
+	g := NewLimitedGroup(tb)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoLimited(ctx, "k", func() (any, error) {
+			t.Error("fn ran without a token")
+			return nil, nil
+		})
+		leader <- err
+	}()
+	waitFor(t, func() bool { return queued(tb) == 1 })
+	follower := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoLimited(context.Background(), "k", nil)
+		follower <- err
+	}()
+	waitFor(t, func() bool { return joined(g, "k") == 1 })
+
+	cancel()
+	for name, ch := range map[string]chan error{"leader": leader, "follower": follower} {
+		if err := <-ch; !errors.Is(err, context.Canceled) {
+			t.Fatalf("%s: %v, want the leader's context.Canceled", name, err)
+		}
+	}
+}
+
+func TestDoLimitedPanicReleasesFollowers(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+	g := NewLimitedGroup(tb)
+
+	release := make(chan struct{})
+	leader := make(chan any, 1)
+	go func() {
+		defer func() { leader <- recover() }()
+		g.DoLimited(context.Background(), "k", func() (any, error) {
+			<-release
+			panic("boom")
+		})
+	}()
+	waitFor(t, func() bool { return joined(g, "k") == 0 })
+	follower := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoLimited(context.Background(), "k", nil)
+		follower <- err
+	}()
+	waitFor(t, func() bool { return joined(g, "k") == 1 })
+
+	close(release)
+	if p := <-leader; p != "boom" {
+		t.Fatalf("leader recovered %v, want the panic", p)
+	}
+	if err := <-follower; err == nil {
+		t.Fatal("follower got no error from a panicked call")
+	}
+}
+
+func TestFollowersCountsCallersStillWaiting(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+	g := NewLimitedGroup(tb)
+	if n, ok := g.Followers("k"); ok || n != 0 {
+		t.Fatalf("Followers before any call = %d, %v; want 0, false", n, ok)
+	}
+
+	release := make(chan struct{})
+	leader := make(chan struct{})
+	go func() {
+		defer close(leader)
+		g.DoLimited(context.Background(), "k", func() (any, error) {
+			<-release
+			return nil, nil
+		})
+	}()
+	waitFor(t, func() bool { return joined(g, "k") == 0 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	left := make(chan struct{})
+	go func() {
+		defer close(left)
+		g.DoLimited(ctx, "k", nil)
+	}()
+	go g.DoLimited(context.Background(), "k", nil)
+	waitFor(t, func() bool { return joined(g, "k") == 2 })
+
+	// A follower that gives up no longer counts.
+	cancel()
+	<-left
+	if n, ok := g.Followers("k"); !ok || n != 1 {
+		t.Fatalf("Followers after one left = %d, %v; want 1, true", n, ok)
+	}
+
+	close(release)
+	<-leader
+	if _, ok := g.Followers("k"); ok {
+		t.Fatal("Followers reports a finished flight as in flight")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "math"
+
+// fractionEpsilon absorbs the rounding of adding up decimal costs in
+// binary floating point, so ten costs of 0.3 come to exactly three tokens.
+const fractionEpsilon = 1e-9
+
+// AllowFloat is AllowN for costs that are not whole tokens, such as 0.3.
+// The balance stays in whole tokens: a fractional cost takes the whole
+// tokens it needs, rounded up, and the unspent part of the last one is
+// kept as credit for the next AllowFloat calls, so over a run of calls
+// exactly the sum of their costs is charged. Ten AllowFloat(0.3) calls take
+// three tokens between them. A call covered by the credit alone takes no
+// tokens but is otherwise decided and counted as AllowN(0). The credit is
+// not part of AvailableTokens or Stats, and only AllowFloat spends it.
+// Costs that are negative, NaN or infinite are rejected without being
+// counted.
+func (tb *TokenBucket) AllowFloat(cost float64) bool {
+	if cost < 0 || math.IsNaN(cost) || math.IsInf(cost, 0) {
+		return false
+	}
+	if tb.unset() {
+		return true
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	whole := int64(0)
+	if need := cost - tb.fracCredit; need > fractionEpsilon {
+		if need >= math.MaxInt64 {
+			return tb.denyLocked(math.MaxInt64, DenyOverCapacity)
+		}
+		whole = int64(math.Ceil(need - fractionEpsilon))
+	}
+	if !tb.allowLocked(tb.costLocked(whole), 0) {
+		return false
+	}
+	tb.fracCredit = max(tb.fracCredit+float64(whole)-cost, 0)
+	if tb.fracCredit < fractionEpsilon {
+		tb.fracCredit = 0
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAllowFloatChargesFractionsExactly(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+
+	for i := 0; i < 10; i++ {
+		if !tb.AllowFloat(0.3) {
+			t.Fatalf("AllowFloat(0.3) call %d denied with %.1f of 3 tokens spent", i+1, 0.3*float64(i))
+		}
+	}
+	if got := tb.AvailableTokens(); got != 0 {
+		t.Fatalf("tokens = %d after 3.0 spent, want 0", got)
+	}
+	if tb.AllowFloat(0.3) {
+		t.Fatal("eleventh AllowFloat(0.3) allowed past 3 tokens")
+	}
+	if got := tb.Stats(); got.Allowed != 10 || got.Denied != 1 {
+		t.Fatalf("allowed %d, denied %d; want 10 and 1", got.Allowed, got.Denied)
+	}
+}
+
+func TestAllowFloatWholeAndInvalidCosts(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+
+	if !tb.AllowFloat(2) || tb.AvailableTokens() != 3 {
+		t.Fatal("AllowFloat(2) did not take two whole tokens")
+	}
+	if !tb.AllowFloat(1.5) || !tb.AllowFloat(0.5) || tb.AvailableTokens() != 1 {
+		t.Fatalf("1.5 then 0.5 left %d tokens, want 1", tb.AvailableTokens())
+	}
+	for _, cost := range []float64{-0.1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if tb.AllowFloat(cost) {
+			t.Errorf("AllowFloat(%v) allowed", cost)
+		}
+	}
+	if got := tb.Stats(); got.Denied != 0 || tb.AvailableTokens() != 1 {
+		t.Fatalf("invalid costs were counted or charged: denied %d, tokens %d", got.Denied, tb.AvailableTokens())
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// HerdEvent describes buckets running empty together: Keys distinct keys'
+// buckets ran out of tokens within Window up to At, out of Active buckets
+// the manager held.
+type HerdEvent struct {
+	At     time.Time
+	Window time.Duration
+	Keys   int
+	Active int
+}
+
+// WithHerdDetection watches for buckets that exhaust in lockstep, which
+// points at something systemic, such as a shared downstream hiccuping,
+// rather than one noisy client. A bucket exhausts when its balance drops
+// below one token, having held at least one. Whenever, within window on
+// the manager's clock, the buckets of at least fraction of the keys the
+// manager holds, and at least two keys, have exhausted, onHerd is called
+// in its own goroutine and HerdEvents counts it. A herd is reported once:
+// the next report needs a fresh set of exhaustions, all after the last
+// one reported.
+func WithHerdDetection(window time.Duration, fraction float64, onHerd func(HerdEvent)) ManagerOption {
+	return func(m *LimiterManager) {
+		m.herd = &herdDetector{window: window, fraction: fraction, onHerd: onHerd, exhausted: make(map[string]time.Time)}
+	}
+}
+
+// HerdEvents is how many herds WithHerdDetection has reported.
+func (m *LimiterManager) HerdEvents() int64 {
+	if m.herd == nil {
+		return 0
+	}
+	m.herd.mu.Lock()
+	defer m.herd.mu.Unlock()
+
+	return m.herd.events
+}
+
+type herdDetector struct {
+	window   time.Duration
+	fraction float64
+	onHerd   func(HerdEvent)
+	clock    Clock // the manager's, set once its options are applied
+
+	mu        sync.Mutex
+	active    int
+	exhausted map[string]time.Time // last exhaustion of each key, within window
+	since     time.Time            // exhaustions up to here were already reported
+	events    int64
+}
+
+// watch makes the detector follow tb's exhaustions as key's.
+func (h *herdDetector) watch(key string, tb *TokenBucket) {
+	tb.mu.Lock()
+	tb.onEmpty = func() { h.exhaust(key) }
+	tb.mu.Unlock()
+}
+
+// unwatch stops the detector following tb, so a bucket that has left the
+// manager is not counted.
+func (h *herdDetector) unwatch(tb *TokenBucket) {
+	tb.mu.Lock()
+	tb.onEmpty = nil
+	tb.mu.Unlock()
+}
+
+// exhaust records that key's bucket has just run empty. It is called with
+// that bucket's lock held, and takes no lock but h's.
+func (h *herdDetector) exhaust(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.clock.Now()
+	h.exhausted[key] = now
+	for k, at := range h.exhausted {
+		if now.Sub(at) > h.window || !at.After(h.since) {
+			delete(h.exhausted, k)
+		}
+	}
+	keys := len(h.exhausted)
+	if keys < 2 || float64(keys) < h.fraction*float64(h.active) {
+		return
+	}
+	h.since = now
+	h.exhausted = make(map[string]time.Time)
+	h.events++
+	if h.onHerd != nil {
+		go h.onHerd(HerdEvent{At: now, Window: h.window, Keys: keys, Active: h.active})
+	}
+}
+
+func (h *herdDetector) added() {
+	h.mu.Lock()
+	h.active++
+	h.mu.Unlock()
+}
+
+func (h *herdDetector) removed(key string) {
+	h.mu.Lock()
+	h.active--
+	delete(h.exhausted, key)
+	h.mu.Unlock()
+}
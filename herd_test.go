@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHerdDetection(t *testing.T) {
+	newManager := func() (*LimiterManager, *ManualClock, func() []HerdEvent) {
+		clock := NewManualClock(time.Unix(0, 0))
+		var mu sync.Mutex
+		var herds []HerdEvent
+		m := NewLimiterManager(func(string) Config { return Config{Rate: 1, Capacity: 2, Interval: time.Hour} },
+			WithHerdDetection(time.Second, 0.5, func(e HerdEvent) {
+				mu.Lock()
+				herds = append(herds, e)
+				mu.Unlock()
+			}),
+			WithManagerClock(clock))
+		for i := 0; i < 10; i++ {
+			m.GetOrCreate(fmt.Sprint("key", i))
+		}
+		return m, clock, func() []HerdEvent {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]HerdEvent(nil), herds...)
+		}
+	}
+
+	t.Run("simultaneous", func(t *testing.T) {
+		m, clock, herds := newManager()
+		defer m.StopAll()
+		for i := 0; i < 6; i++ {
+			m.GetOrCreate(fmt.Sprint("key", i)).AllowN(2)
+			clock.Advance(100 * time.Millisecond)
+		}
+		waitFor(t, func() bool { return len(herds()) == 1 })
+		want := HerdEvent{At: time.Unix(0, int64(400*time.Millisecond)), Window: time.Second, Keys: 5, Active: 10}
+		if got := herds()[0]; got != want {
+			t.Fatalf("herd = %+v, want %+v", got, want)
+		}
+		if got := m.HerdEvents(); got != 1 {
+			t.Fatalf("HerdEvents = %d, want 1 for the one herd", got)
+		}
+	})
+
+	t.Run("staggered", func(t *testing.T) {
+		m, clock, herds := newManager()
+		defer m.StopAll()
+		for i := 0; i < 10; i++ {
+			m.GetOrCreate(fmt.Sprint("key", i)).AllowN(2)
+			clock.Advance(300 * time.Millisecond)
+		}
+		if got := m.HerdEvents(); got != 0 {
+			t.Fatalf("HerdEvents = %d for drains 300ms apart, want 0", got)
+		}
+		if got := herds(); len(got) != 0 {
+			t.Fatalf("herds reported for staggered drains: %+v", got)
+		}
+	})
+}
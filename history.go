@@ -0,0 +1,100 @@
+package main
+
+import (
+	"time"
+)
+
+type Decision struct {
+	Time        time.Time
+	Bucket      string // the bucket's name
+	N           int64
+	Allowed     bool
+	Reason      DenyReason // why, when not allowed
+	TokensAfter int64
+	Tag         string // set by AllowTagged
+}
+
+// WithHistory keeps the last n allow/deny decisions for inspection through
+// History. History is off unless this option is given.
+func WithHistory(n int) Option {
+	return func(tb *TokenBucket) {
+		if n > 0 {
+			tb.history = &decisionRing{buf: make([]Decision, n)}
+		}
+	}
+}
+
+// History returns the recorded decisions, oldest first, or nil if the bucket
+// was built without WithHistory.
+func (tb *TokenBucket) History() []Decision {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.history == nil {
+		return nil
+	}
+	return tb.history.snapshot()
+}
+
+// AllowTagged is AllowN with a caller-supplied tag, such as the operation
+// name, recorded with the decision in History so the budget's consumers can
+// be told apart afterwards. The tag never affects the decision, and costs
+// nothing when history is off.
+func (tb *TokenBucket) AllowTagged(n int64, tag string) bool {
+	if tb.unset() {
+		return n >= 0
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	tb.tag = tag
+	ok := tb.allowLocked(tb.costLocked(n), 0)
+	tb.tag = ""
+	return ok
+}
+
+type decisionRing struct {
+	buf  []Decision
+	next int
+	full bool
+}
+
+func (r *decisionRing) add(d Decision) {
+	r.buf[r.next] = d
+	r.next++
+	if r.next == len(r.buf) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+func (r *decisionRing) snapshot() []Decision {
+	if !r.full {
+		return append([]Decision(nil), r.buf[:r.next]...)
+	}
+	out := make([]Decision, 0, len(r.buf))
+	out = append(out, r.buf[r.next:]...)
+	return append(out, r.buf[:r.next]...)
+}
+
+// RealizedRate is the tokens actually consumed per second over the trailing
+// window, for comparison against the configured rate. It is computed from
+// History, so it needs WithHistory and reports 0 without it; a ring too
+// small to hold every decision in the window under-reports.
+func (tb *TokenBucket) RealizedRate(window time.Duration) float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.history == nil || window <= 0 {
+		return 0
+	}
+	since := tb.clock.Now().Add(-window)
+	var tokens int64
+	for _, d := range tb.history.snapshot() {
+		if d.Allowed && !d.Time.Before(since) {
+			tokens += d.N
+		}
+	}
+	return float64(tokens) / window.Seconds()
+}
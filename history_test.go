@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryKeepsLastDecisionsInOrder(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 3, time.Hour, WithClock(clock), WithHistory(4))
+	defer tb.Stop()
+
+	// Six decisions: three allowed, then three denied, one second apart.
+	for i := 0; i < 6; i++ {
+		tb.AllowN(1)
+		clock.Advance(time.Second)
+	}
+
+	got := tb.History()
+	if len(got) != 4 {
+		t.Fatalf("History() holds %d decisions, want 4", len(got))
+	}
+	wantAllowed := []bool{true, false, false, false}
+	for i, d := range got {
+		if want := time.Unix(int64(i+2), 0); !d.Time.Equal(want) {
+			t.Errorf("decision %d at %v, want %v", i, d.Time, want)
+		}
+		if d.N != 1 || d.Allowed != wantAllowed[i] {
+			t.Errorf("decision %d = %+v, want N=1 Allowed=%v", i, d, wantAllowed[i])
+		}
+	}
+	if got[0].TokensAfter != 0 {
+		t.Errorf("TokensAfter of the last allow = %d, want 0", got[0].TokensAfter)
+	}
+}
+
+func TestHistoryOffByDefault(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour)
+	defer tb.Stop()
+	tb.Allow()
+	if h := tb.History(); h != nil {
+		t.Fatalf("History() = %v without WithHistory, want nil", h)
+	}
+}
+
+func TestAllowTaggedRecordsTags(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour, WithHistory(8))
+	defer tb.Stop()
+
+	tb.AllowTagged(2, "export")
+	tb.AllowN(1)
+	tb.AllowTagged(1, "export")
+
+	got := tb.History()
+	want := []struct {
+		tag     string
+		allowed bool
+	}{{"export", true}, {"", true}, {"export", false}}
+	if len(got) != len(want) {
+		t.Fatalf("History() holds %d decisions, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Tag != w.tag || got[i].Allowed != w.allowed {
+			t.Errorf("decision %d = %+v, want Tag=%q Allowed=%v", i, got[i], w.tag, w.allowed)
+		}
+	}
+}
+
+func TestAllowTaggedWithoutHistory(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour)
+	defer tb.Stop()
+
+	if !tb.AllowTagged(3, "export") || tb.AllowTagged(1, "export") {
+		t.Fatal("AllowTagged decided differently from AllowN")
+	}
+	if h := tb.History(); h != nil {
+		t.Fatalf("History() = %v with history off, want nil", h)
+	}
+}
+
+func TestRealizedRateOverTrailingWindow(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(100, 100, time.Hour, WithClock(clock), WithHistory(100))
+	defer tb.Stop()
+
+	// An hour-old burst, then 30 allows and some denials spread over the
+	// last minute.
+	tb.AllowN(40)
+	clock.Advance(time.Hour)
+	tb.AllowN(tb.AvailableTokens() - 30)
+	clock.Advance(time.Second)
+	for i := 0; i < 30; i++ {
+		if !tb.Allow() {
+			t.Fatalf("allow %d denied", i)
+		}
+		tb.AllowN(50)
+		clock.Advance(2 * time.Second)
+	}
+
+	if got := tb.RealizedRate(time.Minute); got != 0.5 {
+		t.Fatalf("RealizedRate(1m) = %v, want 0.5", got)
+	}
+	if got := tb.RealizedRate(10 * time.Second); got != 0.5 {
+		t.Fatalf("RealizedRate(10s) = %v, want 0.5", got)
+	}
+}
+
+func TestRealizedRateNeedsHistory(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+	tb.AllowN(5)
+	if got := tb.RealizedRate(time.Minute); got != 0 {
+		t.Fatalf("RealizedRate without history = %v, want 0", got)
+	}
+}
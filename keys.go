@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// FirstKey tries each strategy in order and keys the request by the first
+// non-empty result, falling back to FromIP when none yields one:
+//
+//	clients.Middleware(FirstKey(FromBearerSub, FromHeader("X-API-Key")))
+//
+// The built-in strategies prefix their keys with where they came from, so
+// a client can't pass a header value that collides with another client's
+// IP or subject and drain their bucket.
+func FirstKey(strategies ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		for _, fn := range strategies {
+			if key := fn(r); key != "" {
+				return key
+			}
+		}
+		return FromIP(r)
+	}
+}
+
+// FromIP keys by the client address, as KeyByIP does.
+func FromIP(r *http.Request) string {
+	return "ip:" + KeyByIP(r)
+}
+
+// FromHeader keys by the value of the named request header.
+func FromHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return name + ":" + v
+		}
+		return ""
+	}
+}
+
+// FromBearerSub keys by the "sub" claim of a JWT bearer token. The token's
+// signature is not checked: this only groups requests, so it must sit behind
+// whatever authenticates them, or a client can claim any subject.
+func FromBearerSub(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Sub == "" {
+		return ""
+	}
+	return "sub:" + claims.Sub
+}
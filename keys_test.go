@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func bearer(payload string) string {
+	return "Bearer e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+func TestFirstKeyFallsBackInOrder(t *testing.T) {
+	key := FirstKey(FromBearerSub, FromHeader("X-API-Key"))
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"bearer and api key", map[string]string{"Authorization": bearer(`{"sub":"alice"}`), "X-API-Key": "k1"}, "sub:alice"},
+		{"api key only", map[string]string{"X-API-Key": "k1"}, "X-API-Key:k1"},
+		{"neither", nil, "ip:192.0.2.1"},
+		{"bearer without sub", map[string]string{"Authorization": bearer(`{"iss":"x"}`), "X-API-Key": "k1"}, "X-API-Key:k1"},
+		{"malformed bearer", map[string]string{"Authorization": "Bearer not-a-jwt"}, "ip:192.0.2.1"},
+		{"basic auth", map[string]string{"Authorization": "Basic dTpw"}, "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := key(r); got != tt.want {
+				t.Fatalf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
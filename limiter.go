@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Limiter is the part of the TokenBucket API that decorators wrap.
+type Limiter interface {
+	Allow() bool
+	AllowN(n int64) bool
+	WaitNContext(ctx context.Context, n int64) error
+}
+
+func limiterName(l Limiter) string {
+	if n, ok := l.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+func limiterClock(l Limiter) Clock {
+	if c, ok := l.(interface{ Clock() Clock }); ok {
+		return c.Clock()
+	}
+	return realClock{}
+}
+
+// WithSlowWaitLog returns a decorator that logs every WaitNContext call on
+// the wrapped limiter taking longer than threshold. Limiting behaviour is
+// unchanged.
+func WithSlowWaitLog(threshold time.Duration, logger Logger) func(Limiter) Limiter {
+	return func(l Limiter) Limiter {
+		return &slowWaitLimiter{Limiter: l, threshold: threshold, logger: logger, clock: limiterClock(l)}
+	}
+}
+
+type slowWaitLimiter struct {
+	Limiter
+	threshold time.Duration
+	logger    Logger
+	clock     Clock
+}
+
+func (l *slowWaitLimiter) Name() string {
+	return limiterName(l.Limiter)
+}
+
+func (l *slowWaitLimiter) Clock() Clock {
+	return l.clock
+}
+
+func (l *slowWaitLimiter) WaitNContext(ctx context.Context, n int64) error {
+	start := l.clock.Now()
+	err := l.Limiter.WaitNContext(ctx, n)
+	if elapsed := l.clock.Now().Sub(start); elapsed > l.threshold {
+		l.logger.Printf("limiter %q: waited %s for %d tokens (threshold %s)", limiterName(l.Limiter), elapsed, n, l.threshold)
+	}
+	return err
+}
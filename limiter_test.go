@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowWaitLogLogsOnlySlowWaits(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Second, WithClock(clock), WithName("workers"))
+	defer tb.Stop()
+	rec := &recordLogger{}
+	lim := WithSlowWaitLog(500*time.Millisecond, rec)(tb)
+
+	// Served straight away: too fast to log.
+	if err := lim.WaitNContext(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Has to sit out a whole refill.
+	done := make(chan error, 1)
+	go func() { done <- lim.WaitNContext(context.Background(), 1) }()
+	waitForQueue(t, tb, 1)
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	lines := rec.Lines()
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want exactly 1: %q", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], `"workers"`) {
+		t.Fatalf("log line %q does not name the limiter", lines[0])
+	}
+}
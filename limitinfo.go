@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// LimitInfo is the middleware's decision on a request and the bucket's
+// state as it saw it then. Cost is the number of tokens the decision was
+// for. RetryAfter is how long until another token is available, zero if
+// one already is, or InfDuration if none ever will be.
+type LimitInfo struct {
+	Remaining  int64
+	Limit      int64
+	RetryAfter time.Duration
+
+	Allowed bool
+	Reason  DenyReason // why, when not Allowed
+	Cost    int64
+}
+
+type contextKey struct {
+	name string
+}
+
+// LimitInfoKey is the request context key under which the middleware stores
+// the LimitInfo of each admitted request.
+var LimitInfoKey = &contextKey{"limit-info"}
+
+// FromContext returns the LimitInfo the middleware stored for this request.
+func FromContext(ctx context.Context) (LimitInfo, bool) {
+	info, ok := ctx.Value(LimitInfoKey).(LimitInfo)
+	return info, ok
+}
+
+var limitInfoSlotKey = &contextKey{"limit-info-slot"}
+
+// CaptureLimitInfo is for middleware that wraps the limiter, such as
+// access logging, and so never sees the context the limiter passes on, nor
+// any context at all for a request it denies. Serve the request with the
+// returned context, and once the limiter has run, get returns its decision,
+// allowed or denied, or false if no limiter decided on the request.
+func CaptureLimitInfo(ctx context.Context) (_ context.Context, get func() (LimitInfo, bool)) {
+	slot := new(limitInfoSlot)
+	return context.WithValue(ctx, limitInfoSlotKey, slot), func() (LimitInfo, bool) {
+		return slot.info, slot.set
+	}
+}
+
+type limitInfoSlot struct {
+	info LimitInfo
+	set  bool
+}
+
+// recordLimitInfo hands info to any CaptureLimitInfo the request was
+// served under.
+func recordLimitInfo(r *http.Request, info LimitInfo) {
+	if slot, ok := r.Context().Value(limitInfoSlotKey).(*limitInfoSlot); ok {
+		slot.info, slot.set = info, true
+	}
+}
+
+// allowInfo is Allow returning the state it left the bucket in, read under
+// the same lock so it describes exactly this decision.
+func (tb *TokenBucket) allowInfo() (bool, LimitInfo) {
+	return tb.allowNInfo(1)
+}
+
+// allowNInfo is allowInfo for AllowN(n).
+func (tb *TokenBucket) allowNInfo(n int64) (bool, LimitInfo) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	n = tb.costLocked(n)
+	ok, reason := tb.admitLocked(n, 0, 0)
+	info := LimitInfo{Remaining: tb.tokens, Limit: tb.capacity, RetryAfter: InfDuration, Allowed: ok, Cost: n}
+	if !ok {
+		info.Reason = reason
+	}
+	if tb.bypassLocked() || n <= tb.capacity {
+		info.RetryAfter = tb.timeUntilTakeLocked(n)
+	}
+	return ok, info
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Logger is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// Logf emits the line only if the bucket allows it, silently dropping
+// anything over rate.
+func (tb *TokenBucket) Logf(logger Logger, format string, args ...any) {
+	if tb.Allow() {
+		logger.Printf(format, args...)
+	}
+}
+
+// SampledLogger is a Logger that spends one token per line and counts what
+// it drops. Once per bucket interval, on the next call after the interval
+// has passed, it writes a "suppressed N messages" summary for the previous
+// interval and starts counting again.
+type SampledLogger struct {
+	logger Logger
+	tb     *TokenBucket
+
+	mu          sync.Mutex
+	suppressed  int64
+	windowStart time.Time
+}
+
+func NewSampledLogger(logger Logger, tb *TokenBucket) *SampledLogger {
+	return &SampledLogger{logger: logger, tb: tb, windowStart: tb.clock.Now()}
+}
+
+func (l *SampledLogger) Printf(format string, args ...any) {
+	l.mu.Lock()
+	if now := l.tb.clock.Now(); now.Sub(l.windowStart) >= l.tb.Config().Interval {
+		l.flushLocked()
+		l.windowStart = now
+	}
+	if !l.tb.Allow() {
+		l.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	l.mu.Unlock()
+
+	l.logger.Printf(format, args...)
+}
+
+// Flush writes the summary for the current interval immediately, if anything
+// was suppressed.
+func (l *SampledLogger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.flushLocked()
+}
+
+func (l *SampledLogger) flushLocked() {
+	if l.suppressed > 0 {
+		l.logger.Printf("suppressed %d messages", l.suppressed)
+		l.suppressed = 0
+	}
+}
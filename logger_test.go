@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordLogger is a Logger that keeps every line it is given.
+type recordLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordLogger) Printf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return slices.Clone(l.lines)
+}
+
+func TestSampledLoggerDropsAndSummarizes(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 3, time.Second, WithClock(clock))
+	defer tb.Stop()
+	rec := &recordLogger{}
+	l := NewSampledLogger(rec, tb)
+
+	for i := 0; i < 10; i++ {
+		l.Printf("message %d", i)
+	}
+	want := []string{"message 0", "message 1", "message 2"}
+	if got := rec.Lines(); !slices.Equal(got, want) {
+		t.Fatalf("lines = %q, want %q", got, want)
+	}
+
+	clock.Advance(time.Second)
+	l.Printf("message 10")
+	want = append(want, "suppressed 7 messages", "message 10")
+	if got := rec.Lines(); !slices.Equal(got, want) {
+		t.Fatalf("lines = %q, want %q", got, want)
+	}
+
+	// The count restarted with the new interval, and nothing more was
+	// dropped, so there is no second summary.
+	clock.Advance(time.Second)
+	l.Printf("message 11")
+	want = append(want, "message 11")
+	if got := rec.Lines(); !slices.Equal(got, want) {
+		t.Fatalf("lines = %q, want %q", got, want)
+	}
+}
+
+func TestLogfDropsOverRate(t *testing.T) {
+	tb := NewTokenBucket(1, 2, time.Hour)
+	defer tb.Stop()
+	rec := &recordLogger{}
+	for i := 0; i < 5; i++ {
+		tb.Logf(rec, "line %d", i)
+	}
+	if got := rec.Lines(); len(got) != 2 {
+		t.Fatalf("Logf wrote %d lines through a 2-token bucket: %q", len(got), got)
+	}
+}
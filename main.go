@@ -1,103 +1,755 @@
-package main
-
-import (
-	"fmt"
-	"log"
-	"net/http"
-	"sync"
-	"time"
-)
-
-type TokenBucket struct {
-	mu       sync.Mutex    
-	capacity int64         
-	tokens   int64         
-	rate     int64         
-	interval time.Duration 
-	ticker   *time.Ticker
-	stop     chan bool
-}
-
-func NewTokenBucket(rate int64, capacity int64, interval time.Duration) *TokenBucket {
-	tb := &TokenBucket{
-		capacity: capacity,
-		tokens:   capacity,
-		rate:     rate,
-		interval: interval,
-		ticker:   time.NewTicker(interval),
-		stop:     make(chan bool),
-	}
-
-	go tb.refill()
-
-	return tb
-}
-
-func (tb *TokenBucket) refill() {
-	for {
-		select {
-		case <-tb.ticker.C:
-			tb.mu.Lock()
-			tb.tokens += tb.rate
-			if tb.tokens > tb.capacity {
-				tb.tokens = tb.capacity
-			}
-			log.Printf("Refilled tokens. Current count: %d\n", tb.tokens)
-			tb.mu.Unlock()
-
-		case <-tb.stop:
-			tb.ticker.Stop()
-			return
-		}
-	}
-}
-
-func (tb *TokenBucket) Allow() bool {
-	tb.mu.Lock()
-	defer tb.mu.Unlock()
-
-	if tb.tokens > 0 {
-		tb.tokens--
-		return true
-	}
-
-	return false
-}
-
-func (tb *TokenBucket) Stop() {
-	tb.stop <- true
-}
-
-func main() {
-	capacity := int64(10)
-	rate := int64(1)
-	interval := 2 * time.Second
-
-	limiter := NewTokenBucket(rate, capacity, interval)
-	defer limiter.Stop()
-	http.HandleFunc("/limited", func(w http.ResponseWriter, r *http.Request) {
-		if limiter.Allow() {
-			log.Println("Request ALLOWED for /limited")
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprintln(w, "Request was processed.")
-		} else {
-			log.Println("Request DENIED for /limited")
-			w.WriteHeader(http.StatusTooManyRequests)
-			fmt.Fprintln(w, "Too Many Requests.")
-		}
-	})
-
-	http.HandleFunc("/unlimited", func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Request ALLOWED for /unlimited")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "Unlimited request was processed.")
-	})
-
-	log.Println("Starting rate limiter service on :8080...")
-	log.Println("Test with: http://localhost:8080/limited")
-	log.Println("Test with: http://localhost:8080/unlimited")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal(err)
-	}
-
-}
+package main
+
+import (
+	"context"
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TokenBucket must be created with NewTokenBucket or one of its variants.
+// As a guard against a limiter field left uninitialized, a nil
+// *TokenBucket and the zero TokenBucket act as a disabled limiter in the
+// Allow and Wait methods and Stop: every request for a non-negative number of
+// tokens succeeds, nothing is consumed, and Stop does nothing. Other
+// methods still need a real bucket.
+type TokenBucket struct {
+	mu                sync.Mutex
+	id                uint64
+	name              string
+	clock             Clock
+	capacity          int64
+	tokens            int64
+	rate              int64
+	interval          time.Duration
+	lastRefill        time.Time
+	disabled          bool
+	suspended         bool
+	draining          bool
+	drained           chan struct{}
+	minCharge         int64
+	consumed          int64
+	allowed           int64
+	denied            int64
+	deniedBy          DenyCounts
+	lowWater          int64
+	usageMark         UsageSnapshot
+	history           *decisionRing
+	rollup            *RollupCollector
+	tag               string
+	waiters           []*waiter
+	watchers          []*waiter
+	waitStats         WaitStats
+	ticker            ticker
+	newTicker         func(time.Duration) Ticker
+	stop              chan struct{}
+	stopOnce          sync.Once
+	stopped           bool
+	denyAfterStop     bool
+	failOpenAfter     time.Duration
+	failingOpen       bool
+	rounding          RoundingMode
+	driftCompensation bool
+	catchUp           CatchUpPolicy
+	credited          int64
+	phase             float64
+	resetAt           time.Time
+	resetEvery        time.Duration
+	softStart         time.Duration
+	createdAt         time.Time
+	softCarry         float64
+	minSpacing        time.Duration
+	lastAllowed       time.Time
+	earlyDrop         int64
+	denyPenalty       int64
+	decayFloor        int64
+	decayHalfLife     time.Duration
+	curve             AccrualCurve
+	curveStart        time.Time
+	curveEarned       int64
+	emptySince        time.Time
+	lastActive        time.Time
+	lastServed        time.Time
+	lastDenied        time.Time
+	tracer            func(context.Context, TraceEvent)
+	rand              *rand.Rand
+	probeTokens       atomic.Int64
+	probeCapacity     atomic.Int64
+	quiet             bool
+	onRefill          func(tokens int64)
+	adaptive          *AdaptivePolicy
+	adaptExtra        int64
+	adaptMark         UsageSnapshot
+	busyLoop          *busyLoopDetector
+	costAlpha         float64
+	costEstimate      float64
+	costObserved      bool
+	fairWindow        int64
+	fairUsed          int64
+	nDist             map[int64]int64
+	auditSink         chan<- Decision
+	auditDropped      int64
+	spillAt           int
+	snapshot          snapshotCell
+	fracCredit        float64
+	dynamicScale      func() float64
+	scale             float64
+	onEmpty           func()
+}
+
+type Option func(*TokenBucket)
+
+// WithName labels the bucket in logs and debug output.
+func WithName(name string) Option {
+	return func(tb *TokenBucket) {
+		tb.name = name
+	}
+}
+
+// withoutRefillLog silences the per-refill log line, for buckets the package
+// builds for its own bookkeeping.
+func withoutRefillLog() Option {
+	return func(tb *TokenBucket) {
+		tb.quiet = true
+	}
+}
+
+func NewTokenBucket(rate int64, capacity int64, interval time.Duration, opts ...Option) *TokenBucket {
+	return NewTokenBucketContext(context.Background(), rate, capacity, interval, opts...)
+}
+
+// NewTokenBucketContext is NewTokenBucket with the bucket's lifetime tied to
+// ctx: cancelling ctx stops the bucket exactly as Stop does. Stop may still
+// be called, before or after cancellation.
+func NewTokenBucketContext(ctx context.Context, rate int64, capacity int64, interval time.Duration, opts ...Option) *TokenBucket {
+	tb := &TokenBucket{
+		clock:    realClock{},
+		capacity: capacity,
+		tokens:   capacity,
+		rate:     rate,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(tb)
+	}
+	tb.id = nextBucketID.Add(1)
+	tb.lastRefill = tb.clock.Now()
+	tb.createdAt = tb.lastRefill
+	tb.lowWater = tb.tokens
+	tb.scale, _ = tb.consultScale()
+	if tb.newTicker != nil {
+		tb.ticker = newCustomTicker(tb.newTicker, interval)
+	} else {
+		tb.ticker = newTicker(tb.clock, interval, tb.tick)
+	}
+	tb.publishLocked()
+
+	go tb.refill(ctx.Done())
+
+	return tb
+}
+
+func (tb *TokenBucket) refill(done <-chan struct{}) {
+	for {
+		select {
+		case <-tb.ticker.C():
+			tb.tick()
+
+		case <-done:
+			tb.Stop()
+			tb.ticker.Stop()
+			return
+
+		case <-tb.stop:
+			tb.ticker.Stop()
+			return
+		}
+	}
+}
+
+func (tb *TokenBucket) tick() {
+	scale, rescale := tb.consultScale()
+	tb.mu.Lock()
+	refilled := tb.tickLocked()
+	if rescale {
+		tb.scale = scale
+	}
+	tokens, onRefill := tb.tokens, tb.onRefill
+	tb.mu.Unlock()
+
+	if refilled && onRefill != nil {
+		go onRefill(tokens)
+	}
+}
+
+// OnRefillComplete calls fn with the balance right after each refill, e.g.
+// for a test to wait on refills instead of sleeping. fn runs in its own
+// goroutine, so a slow fn never holds up refills, and calls for refills in
+// quick succession may overlap or arrive out of order. A nil fn removes the
+// callback.
+func (tb *TokenBucket) OnRefillComplete(fn func(tokens int64)) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.onRefill = fn
+}
+
+// tickLocked does one refill, reporting whether it happened.
+func (tb *TokenBucket) tickLocked() bool {
+	if tb.stopped {
+		return false
+	}
+	tb.resetIfDueLocked()
+	tb.decayLocked()
+	periods := int64(1)
+	if tb.driftCompensation || tb.catchUp != 0 {
+		if periods = tb.elapsedPeriodsLocked(); periods == 0 {
+			return false
+		}
+		if tb.catchUp == SingleStep {
+			periods = 1
+		}
+	} else {
+		tb.lastRefill = tb.clock.Now()
+	}
+	if tb.failingOpen {
+		tb.failingOpen = false
+		log.Printf("Refills resumed for token bucket %q; limiting again\n", tb.name)
+	}
+	if tb.curve != nil {
+		tb.accrueCurveLocked()
+	} else {
+		refill := periods*tb.periodRefillLocked() - tb.credited
+		tb.credited = 0
+		if refill > 0 {
+			tb.creditLocked(refill)
+		}
+	}
+	if tb.adaptive != nil {
+		tb.adaptLocked()
+	}
+	if !tb.quiet {
+		log.Printf("Refilled tokens. Current count: %d\n", tb.tokens)
+	}
+	return true
+}
+
+func (tb *TokenBucket) Name() string {
+	return tb.name
+}
+
+func (tb *TokenBucket) String() string {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	return fmt.Sprintf("TokenBucket{name=%s, tokens=%d/%d, rate=%d/%s}", tb.name, tb.tokens, tb.capacity, tb.rate, tb.interval)
+}
+
+func (tb *TokenBucket) AvailableTokens() int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	return tb.tokens
+}
+
+// unset reports whether tb is nil or was never constructed. stop is set by
+// the constructor and never changes, so reading it needs no lock.
+func (tb *TokenBucket) unset() bool {
+	return tb == nil || tb.stop == nil
+}
+
+// Allow is AllowN(1). A bucket with none of the options that can change a
+// single-token decision takes it directly instead of going through the
+// general path; the outcome is the same either way.
+func (tb *TokenBucket) Allow() bool {
+	if tb.unset() {
+		return true
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	if tb.tokens < 1 || !tb.plainLocked() {
+		return tb.allowLocked(tb.costLocked(1), 0)
+	}
+	tb.tokens--
+	tb.lowWater = min(tb.lowWater, tb.tokens)
+	tb.consumed++
+	tb.allowed++
+	tb.lastServed = tb.clock.Now()
+	tb.publishLocked()
+	return true
+}
+
+// plainLocked reports whether taking a single token needs nothing beyond
+// the balance: no minimum charge, spacing, early drop, bypass, fail-open,
+// draining, deny-after-stop, decay, history, rollup, curve, n
+// distribution or audit sink to account for.
+func (tb *TokenBucket) plainLocked() bool {
+	return tb.minCharge <= 1 && tb.minSpacing <= 0 && tb.tokens >= tb.earlyDrop &&
+		!tb.disabled && !tb.suspended && tb.failOpenAfter <= 0 && !tb.draining &&
+		!(tb.stopped && tb.denyAfterStop) && tb.decayHalfLife <= 0 &&
+		tb.history == nil && tb.rollup == nil && tb.curve == nil && tb.nDist == nil &&
+		tb.auditSink == nil
+}
+
+// AllowN consumes n tokens if at least n are available. n must not be
+// negative; a negative n is denied and leaves the bucket untouched rather
+// than crediting it.
+func (tb *TokenBucket) AllowN(n int64) bool {
+	if tb.unset() {
+		return n >= 0
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	return tb.allowLocked(tb.costLocked(n), 0)
+}
+
+// AllowNEdge is AllowN that also reports whether this call was the one that
+// drained the bucket, i.e. it consumed the last token. Checking the balance
+// afterwards instead would race with other callers.
+func (tb *TokenBucket) AllowNEdge(n int64) (ok, nowEmpty bool) {
+	if tb.unset() {
+		return n >= 0, false
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	before := tb.tokens
+	ok = tb.allowLocked(tb.costLocked(n), 0)
+	return ok, ok && before > 0 && tb.tokens == 0
+}
+
+// AllowNSoft consumes n tokens only if the balance left afterwards stays at
+// or above floor, letting a call site keep headroom in reserve. floor is
+// clamped to [0, capacity].
+func (tb *TokenBucket) AllowNSoft(n, floor int64) bool {
+	if tb.unset() {
+		return n >= 0
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	if floor < 0 {
+		floor = 0
+	}
+	if floor > tb.capacity {
+		floor = tb.capacity
+	}
+
+	return tb.allowLocked(tb.costLocked(n), floor)
+}
+
+// AllowAboveWatermark consumes n tokens only if the balance before
+// consuming is at least watermark (and at least n). Unlike AllowNSoft, which
+// protects a floor left after the request, this stops admitting a class of
+// traffic altogether once the bucket has drained to the watermark, keeping
+// what remains for callers that don't check it.
+func (tb *TokenBucket) AllowAboveWatermark(n, watermark int64) bool {
+	if tb.unset() {
+		return n >= 0
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	n = tb.costLocked(n)
+	if tb.tokens < watermark {
+		return tb.denyLocked(n, DenyWatermark)
+	}
+	return tb.allowLocked(n, 0)
+}
+
+// TotalConsumed is the lifetime total of tokens successfully consumed. It
+// only ever grows: denied calls don't count, and neither Refund nor
+// Reconfigure take anything off it.
+func (tb *TokenBucket) TotalConsumed() int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.consumed
+}
+
+// Refund returns the tokens charged by a successful AllowN(n), for work that
+// ended up not being done. The balance never rises above capacity.
+func (tb *TokenBucket) Refund(n int64) {
+	if n <= 0 {
+		return
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.bypassLocked() {
+		return
+	}
+	tb.creditLocked(tb.costLocked(n))
+}
+
+// creditLocked adds n tokens, capped at capacity (or the lower ceiling of a
+// decaying or dynamically scaled bucket, without ever dropping below the
+// current balance), and passes them on to any queued waiters.
+func (tb *TokenBucket) creditLocked(n int64) {
+	tb.tokens += n
+	if ceiling := tb.scaledCeilingLocked(); tb.tokens > ceiling {
+		tb.tokens = max(ceiling, tb.tokens-n)
+	}
+	tb.serveWaitersLocked()
+	tb.notifyWatchersLocked()
+	tb.publishLocked()
+}
+
+// WithMinCharge makes every non-zero request cost at least minCharge
+// tokens, so AllowN(1) consumes max(1, minCharge). AllowN(0) still consumes
+// nothing. minCharge must not exceed capacity; larger values are treated as
+// capacity.
+func WithMinCharge(minCharge int64) Option {
+	return func(tb *TokenBucket) {
+		tb.minCharge = minCharge
+	}
+}
+
+func (tb *TokenBucket) costLocked(n int64) int64 {
+	if n <= 0 {
+		return n
+	}
+	minCharge := tb.minCharge
+	if minCharge > tb.capacity {
+		minCharge = tb.capacity
+	}
+	if n < minCharge {
+		return minCharge
+	}
+	return n
+}
+
+func (tb *TokenBucket) takeLocked(n, floor int64) bool {
+	ok, _ := tb.decideLocked(n, floor, denyInferred)
+	return ok
+}
+
+// decideLocked is takeLocked with the reason a denial is counted under
+// given by the caller, who knows it when a check of its own turned the
+// request down, and also reports that reason. With denyInferred it is
+// worked out from the bucket's state. A stopped bucket's denial is always
+// counted as DenyStopped.
+func (tb *TokenBucket) decideLocked(n, floor int64, reason DenyReason) (bool, DenyReason) {
+	tb.recordNLocked(n)
+	ok := tb.tryTakeLocked(n, floor)
+	if ok {
+		reason = denyInferred
+	} else {
+		switch {
+		case tb.stopped && tb.denyAfterStop:
+			reason = DenyStopped
+		case reason != denyInferred:
+		case floor > 0 && floor != math.MaxInt64 && tb.tokens >= n && tb.tokens >= 0 && tb.spacedLocked():
+			reason = DenyFloor
+		default:
+			reason = tb.denyKindLocked(n)
+		}
+		tb.deniedBy[reason]++
+	}
+	if tb.history != nil {
+		tb.history.add(tb.decisionLocked(n, ok, reason))
+	}
+	tb.countLocked(ok)
+	tb.auditLocked(n, ok, reason)
+	return ok, reason
+}
+
+// countLocked records a decision in the counters.
+func (tb *TokenBucket) countLocked(ok bool) {
+	if tb.decayHalfLife > 0 {
+		tb.lastActive = tb.clock.Now()
+	}
+	if ok {
+		tb.allowed++
+		tb.lastServed = tb.clock.Now()
+	} else {
+		tb.denied++
+		tb.lastDenied = tb.clock.Now()
+		if tb.busyLoop != nil {
+			tb.busyLoopDeniedLocked()
+		}
+	}
+	if tb.rollup != nil {
+		tb.rollup.record(ok)
+	}
+	tb.publishSnapshotLocked()
+}
+
+func (tb *TokenBucket) tryTakeLocked(n, floor int64) bool {
+	if n < 0 || tb.stopped && tb.denyAfterStop {
+		return false
+	}
+	if tb.bypassLocked() || tb.failOpenLocked() {
+		return true
+	}
+
+	allowed := tb.fitsLocked(n, floor)
+	if allowed {
+		if n > 0 && tb.minSpacing > 0 {
+			tb.lastAllowed = tb.clock.Now()
+		}
+		tb.tokens -= n
+		tb.lowWater = min(tb.lowWater, tb.tokens)
+		tb.consumed += n
+		tb.publishLocked()
+		tb.curveEmptiedLocked()
+	}
+	return allowed
+}
+
+// fitsLocked reports whether n tokens can be taken leaving at least floor,
+// limiting itself aside.
+func (tb *TokenBucket) fitsLocked(n, floor int64) bool {
+	// Asking for nothing succeeds even while a deny penalty has the balance
+	// below zero.
+	return n == 0 && floor <= 0 || tb.tokens-n >= floor && (n == 0 || tb.spacedLocked())
+}
+
+// WouldAllow reports whether AllowN(n) would succeed right now, applying
+// the same rules (minimum charge, spacing, bypass) without consuming
+// anything. Early drop's random denials are left out. The answer can be
+// stale as soon as it is returned, so it is for what-if tooling, not for
+// deciding whether to call AllowN.
+func (tb *TokenBucket) WouldAllow(n int64) bool {
+	if tb.unset() {
+		return n >= 0
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	n = tb.costLocked(n)
+	if n < 0 || tb.stopped && tb.denyAfterStop || tb.draining {
+		return false
+	}
+	if tb.bypassLocked() || tb.failOpenLocked() {
+		return true
+	}
+	return tb.fitsLocked(n, 0)
+}
+
+// denyLocked records a denial for n tokens, counted under reason, through
+// takeLocked, which still lets the request through if limiting is currently
+// bypassed, unless the bucket is draining.
+func (tb *TokenBucket) denyLocked(n int64, reason DenyReason) bool {
+	ok, _ := tb.refuseLocked(n, reason)
+	return ok
+}
+
+// refuseLocked is denyLocked also reporting the reason it counted.
+func (tb *TokenBucket) refuseLocked(n int64, reason DenyReason) (bool, DenyReason) {
+	if tb.draining {
+		tb.recordNLocked(n)
+		tb.countLocked(false)
+		tb.auditLocked(n, false, DenyDraining)
+		tb.deniedBy[DenyDraining]++
+		return false, DenyDraining
+	}
+	return tb.decideLocked(n, math.MaxInt64, reason)
+}
+
+// allowLocked is takeLocked for the non-blocking Allow calls, which are
+// subject to early drop and the deny penalty.
+func (tb *TokenBucket) allowLocked(n, floor int64) bool {
+	ok, _ := tb.admitLocked(n, floor, 0)
+	return ok
+}
+
+// admitLocked is allowLocked for a request spared the given share of early
+// drop's shedding, also reporting the reason a denial was counted under.
+func (tb *TokenBucket) admitLocked(n, floor int64, shield float64) (ok bool, reason DenyReason) {
+	switch {
+	case tb.draining:
+		ok, reason = tb.refuseLocked(n, DenyDraining)
+	case tb.earlyDropLocked(shield):
+		// Only a request that would otherwise have fit was shed early.
+		reason = DenyEarlyDrop
+		if !tb.fitsLocked(n, floor) {
+			reason = denyInferred
+		}
+		ok, reason = tb.refuseLocked(n, reason)
+	default:
+		ok, reason = tb.decideLocked(n, floor, denyInferred)
+	}
+	if !ok && tb.denyPenalty > 0 && !tb.stopped && !tb.draining {
+		tb.tokens = max(tb.tokens-tb.denyPenalty, -tb.capacity)
+		tb.publishLocked()
+		tb.curveEmptiedLocked()
+	}
+	return ok, reason
+}
+
+// WithFailOpenAfter guards against a stuck refill loop turning into an
+// outage: if no refill has happened for longer than staleness, every request
+// is allowed, and a warning logged, until refills resume. It has no effect
+// once the bucket has been stopped.
+func WithFailOpenAfter(staleness time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.failOpenAfter = staleness
+	}
+}
+
+func (tb *TokenBucket) failOpenLocked() bool {
+	if tb.failOpenAfter <= 0 || tb.stopped {
+		return false
+	}
+	if tb.clock.Now().Sub(tb.lastRefill) <= tb.failOpenAfter {
+		return false
+	}
+	if !tb.failingOpen {
+		tb.failingOpen = true
+		log.Printf("No refill for token bucket %q since %s; failing open\n", tb.name, tb.lastRefill.Format(time.RFC3339))
+	}
+	return true
+}
+
+func (tb *TokenBucket) LastRefill() time.Time {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.lastRefill
+}
+
+// WithDenyAfterStop makes every Allow and AllowN fail once the bucket has
+// been stopped, instead of spending what is left of the balance.
+func WithDenyAfterStop(deny bool) Option {
+	return func(tb *TokenBucket) {
+		tb.denyAfterStop = deny
+	}
+}
+
+// Stop halts refills and may be called more than once. By default the bucket
+// stays usable afterwards: Allow and AllowN keep drawing on the balance left
+// at Stop, which never refills again. Callers blocked in WaitN, and any later
+// WaitN that can't be served from the remaining balance, fail with
+// ErrStopped.
+func (tb *TokenBucket) Stop() {
+	if tb.unset() {
+		return
+	}
+	tb.stopOnce.Do(func() {
+		tb.mu.Lock()
+		tb.stopped = true
+		tb.failWaitersLocked(ErrStopped)
+		tb.mu.Unlock()
+
+		close(tb.stop)
+	})
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; serves HTTPS when set with -tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	readHeaderTimeout := flag.Duration("read-header-timeout", 5*time.Second, "maximum time to read request headers")
+	readTimeout := flag.Duration("read-timeout", 10*time.Second, "maximum time to read the whole request")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Second, "maximum time to write the response")
+	idleTimeout := flag.Duration("idle-timeout", 60*time.Second, "maximum time to keep an idle connection open")
+	metrics := flag.Bool("metrics", false, "serve Prometheus metrics at /metrics")
+	configPath := flag.String("config", "", "JSON config file for the /limited bucket, reloaded when it changes")
+	flag.Parse()
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
+
+	capacity := int64(10)
+	rate := int64(1)
+	interval := 2 * time.Second
+
+	mux := http.NewServeMux()
+
+	limiter := NewTokenBucket(rate, capacity, interval, WithName("limited"))
+	stoppers := []interface{ Stop() }{limiter}
+	if *configPath != "" {
+		watcher, err := WatchConfig(*configPath, limiter, time.Second)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stoppers = append(stoppers, watcher)
+	}
+	LimitRoute(mux, "GET /limited", limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Request ALLOWED for /limited")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Request was processed.")
+	}))
+
+	clients := NewLimiterManager(GrowingCapacity(Config{Rate: rate, Capacity: capacity, Interval: interval}, 2, 2, 100_000), WithMaxBuckets(100_000))
+	stoppers = append(stoppers, clients)
+	mux.Handle("GET /per-client", clients.Middleware(KeyByIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Request ALLOWED for /per-client from %s\n", KeyByIP(r))
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Per-client request was processed.")
+	})))
+
+	mux.HandleFunc("GET /unlimited", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Request ALLOWED for /unlimited")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Unlimited request was processed.")
+	})
+
+	if *metrics {
+		mux.Handle("GET /metrics", MetricsHandler(limiter))
+		if err := limiter.PublishExpvar("limited"); err != nil {
+			log.Fatal(err)
+		}
+		mux.Handle("GET /debug/vars", expvar.Handler())
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
+	AttachToServer(srv, stoppers...)
+
+	scheme := "http"
+	if *tlsCert != "" {
+		scheme = "https"
+	}
+	base := scheme + "://localhost" + *addr
+	if host, port, err := net.SplitHostPort(*addr); err == nil && host != "" {
+		base = scheme + "://" + net.JoinHostPort(host, port)
+	}
+
+	log.Printf("Starting rate limiter service on %s...\n", *addr)
+	log.Printf("Test with: %s/limited\n", base)
+	log.Printf("Test with: %s/per-client\n", base)
+	log.Printf("Test with: %s/unlimited\n", base)
+	if *metrics {
+		log.Printf("Metrics at: %s/metrics\n", base)
+		log.Printf("Stats at: %s/debug/vars\n", base)
+	}
+
+	var err error
+	if *tlsCert != "" {
+		err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
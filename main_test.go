@@ -0,0 +1,530 @@
+//go:debug httpmuxgo121=0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Refills and denials log on every call; keep test output readable.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// waitFor polls cond until it holds, for conditions reached by another
+// goroutine that has no way of signalling the test.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForQueue waits until n callers are queued in Wait on tb.
+func waitForQueue(t *testing.T, tb *TokenBucket, n int) {
+	t.Helper()
+	waitFor(t, func() bool { return tb.Stats().Waits.Waiting == n })
+}
+
+func TestAllowNSoftKeepsFloor(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+
+	if !tb.AllowNSoft(5, 3) {
+		t.Fatal("AllowNSoft(5, 3) denied with 10 tokens")
+	}
+	if tb.AllowNSoft(3, 3) {
+		t.Fatal("AllowNSoft(3, 3) allowed with 5 tokens, leaving 2 below the floor")
+	}
+	if !tb.AllowNSoft(2, 3) {
+		t.Fatal("AllowNSoft(2, 3) denied with 5 tokens, leaving exactly the floor")
+	}
+	if !tb.AllowN(3) {
+		t.Fatal("AllowN(3) denied with 3 tokens")
+	}
+	if tb.AllowN(1) {
+		t.Fatal("AllowN(1) allowed on an empty bucket")
+	}
+}
+
+func TestAllowNSoftClampsFloor(t *testing.T) {
+	tests := []struct {
+		name  string
+		floor int64
+		n     int64
+		want  bool
+	}{
+		{"negative floor acts as zero", -5, 10, true},
+		{"floor above capacity acts as capacity", 50, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := NewTokenBucket(1, 10, time.Hour)
+			defer tb.Stop()
+			if got := tb.AllowNSoft(tt.n, tt.floor); got != tt.want {
+				t.Fatalf("AllowNSoft(%d, %d) = %v, want %v", tt.n, tt.floor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinCharge(t *testing.T) {
+	tests := []struct {
+		n, want int64
+	}{
+		{0, 0},
+		{2, 5},
+		{5, 5},
+		{7, 7},
+	}
+	for _, tt := range tests {
+		tb := NewTokenBucket(1, 20, time.Hour, WithMinCharge(5))
+		if !tb.AllowN(tt.n) {
+			t.Fatalf("AllowN(%d) denied", tt.n)
+		}
+		if got := 20 - tb.AvailableTokens(); got != tt.want {
+			t.Errorf("AllowN(%d) debited %d, want %d", tt.n, got, tt.want)
+		}
+		tb.Stop()
+	}
+}
+
+func TestMinChargeAboveCapacityIsCapacity(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour, WithMinCharge(10))
+	defer tb.Stop()
+	if !tb.AllowN(1) {
+		t.Fatal("AllowN(1) denied on a full bucket")
+	}
+	if got := tb.AvailableTokens(); got != 0 {
+		t.Fatalf("%d tokens left, want 0", got)
+	}
+}
+
+func TestString(t *testing.T) {
+	tb := NewTokenBucket(1, 10, 2*time.Second, WithName("api"))
+	defer tb.Stop()
+	tb.AllowN(3)
+
+	want := "TokenBucket{name=api, tokens=7/10, rate=1/2s}"
+	if got := tb.String(); got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+	if got := fmt.Sprintf("%v", tb); got != want {
+		t.Fatalf("%%v = %q, want %q", got, want)
+	}
+}
+
+func TestAllowAfterStop(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		wantAllowed int
+	}{
+		{"frozen balance", nil, 3},
+		{"deny after stop", []Option{WithDenyAfterStop(true)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Unix(0, 0))
+			tb := NewTokenBucket(1, 3, time.Second, append(tt.opts, WithClock(clock))...)
+			tb.Stop()
+			tb.Stop()
+			clock.Advance(time.Hour)
+
+			allowed := 0
+			for i := 0; i < 1000; i++ {
+				if tb.Allow() {
+					allowed++
+				}
+			}
+			if allowed != tt.wantAllowed {
+				t.Fatalf("%d of 1000 allowed after Stop, want %d", allowed, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+// stalledClock moves only when set and never fires a bucket's ticker, so a
+// bucket on it only refills when the test calls tick itself: a stand-in for
+// a stuck or starved refill loop.
+type stalledClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *stalledClock) newTicker(time.Duration, func()) ticker {
+	return stalledTicker{}
+}
+
+type stalledTicker struct{}
+
+func (stalledTicker) C() <-chan time.Time   { return nil }
+func (stalledTicker) Reset(d time.Duration) {}
+func (stalledTicker) Stop()                 {}
+
+func (c *stalledClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *stalledClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func TestFailOpenAfterStalledRefills(t *testing.T) {
+	clock := &stalledClock{now: time.Unix(0, 0)}
+	tb := NewTokenBucket(1, 1, time.Hour, WithClock(clock), WithFailOpenAfter(90*time.Minute))
+	defer tb.Stop()
+
+	// Between refills the bucket still releases up to one interval's worth
+	// of tokens on its own, then runs dry.
+	tb.Allow()
+	clock.advance(90 * time.Minute)
+	if !tb.Allow() {
+		t.Fatal("token released since the last refill denied")
+	}
+	if tb.Allow() {
+		t.Fatal("allowed with no tokens before the staleness threshold")
+	}
+
+	clock.advance(time.Second)
+	for i := 0; i < 5; i++ {
+		if !tb.Allow() {
+			t.Fatalf("request %d denied after refills stalled past the threshold", i)
+		}
+	}
+
+	// A refill arriving ends fail-open. Whatever it credits is spent first.
+	tb.tick()
+	tb.Allow()
+	if tb.Allow() {
+		t.Fatal("still failing open after refills resumed")
+	}
+}
+
+func TestAllowNTakesExactlyTheRemainingTokens(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour)
+	defer tb.Stop()
+
+	if !tb.AllowN(5) {
+		t.Fatal("AllowN(5) with 5 tokens denied")
+	}
+	if got := tb.AvailableTokens(); got != 0 {
+		t.Fatalf("after AllowN(5): %d tokens, want 0", got)
+	}
+	if tb.AllowN(1) {
+		t.Fatal("AllowN(1) with no tokens allowed")
+	}
+
+	// Allow, fast path included, takes the last token too.
+	tb.Refund(1)
+	if !tb.Allow() {
+		t.Fatal("Allow with exactly 1 token denied")
+	}
+	if tb.Allow() {
+		t.Fatal("Allow with no tokens allowed")
+	}
+}
+
+func TestAllowNRejectsNegative(t *testing.T) {
+	const capacity = 5
+	tests := []struct {
+		n       int64
+		allowed bool
+		left    int64
+		err     error
+	}{
+		{-5, false, capacity, ErrNegativeTokens},
+		{-1, false, capacity, ErrNegativeTokens},
+		{0, true, capacity, nil},
+		{1, true, capacity - 1, nil},
+		{capacity, true, 0, nil},
+		{capacity + 1, false, capacity, ErrTokensExceedCapacity},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.n), func(t *testing.T) {
+			tb := NewTokenBucket(1, capacity, time.Hour)
+			defer tb.Stop()
+
+			if got := tb.AllowN(tt.n); got != tt.allowed {
+				t.Fatalf("AllowN(%d) = %v, want %v", tt.n, got, tt.allowed)
+			}
+			if got := tb.AvailableTokens(); got != tt.left {
+				t.Fatalf("after AllowN(%d): %d tokens, want %d", tt.n, got, tt.left)
+			}
+
+			tb = NewTokenBucket(1, capacity, time.Hour)
+			defer tb.Stop()
+			if err := tb.Take(tt.n); !errors.Is(err, tt.err) {
+				t.Fatalf("Take(%d) = %v, want %v", tt.n, err, tt.err)
+			}
+			if got := tb.AvailableTokens(); got != tt.left {
+				t.Fatalf("after Take(%d): %d tokens, want %d", tt.n, got, tt.left)
+			}
+		})
+	}
+}
+
+func TestAllowAboveWatermarkChecksBalanceBefore(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+	tb.AllowN(5)
+
+	// At exactly the watermark AllowNSoft with the same floor would refuse,
+	// since it would leave the balance below it.
+	if tb.AllowNSoft(1, 5) {
+		t.Fatal("AllowNSoft(1, 5) allowed with 5 tokens")
+	}
+	if !tb.AllowAboveWatermark(1, 5) {
+		t.Fatal("AllowAboveWatermark(1, 5) denied with 5 tokens")
+	}
+	if tb.AllowAboveWatermark(1, 5) {
+		t.Fatal("AllowAboveWatermark(1, 5) allowed with 4 tokens")
+	}
+	if tb.AllowAboveWatermark(5, 0) {
+		t.Fatal("AllowAboveWatermark(5, 0) allowed with 4 tokens")
+	}
+	if got := tb.AvailableTokens(); got != 4 {
+		t.Fatalf("%d tokens left, want 4: denials must not consume", got)
+	}
+}
+
+func TestNewTokenBucketContextCancelStopsRefills(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	buckets := make([]*TokenBucket, 20)
+	for i := range buckets {
+		buckets[i] = NewTokenBucketContext(ctx, 1, 1, time.Millisecond, WithDenyAfterStop(true))
+	}
+	cancel()
+
+	waitFor(t, func() bool { return runtime.NumGoroutine() <= before })
+	for _, tb := range buckets {
+		tb.Stop()
+		if tb.Allow() {
+			t.Fatal("Allow() succeeded on a bucket whose context was cancelled")
+		}
+	}
+}
+
+func TestAllowNEdgeReportsDrainingCall(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour)
+	defer tb.Stop()
+
+	steps := []struct {
+		n            int64
+		ok, nowEmpty bool
+	}{
+		{2, true, false},
+		{3, true, true},
+		{1, false, false},
+		{0, true, false},
+	}
+	for _, s := range steps {
+		ok, nowEmpty := tb.AllowNEdge(s.n)
+		if ok != s.ok || nowEmpty != s.nowEmpty {
+			t.Fatalf("AllowNEdge(%d) = %v, %v, want %v, %v", s.n, ok, nowEmpty, s.ok, s.nowEmpty)
+		}
+	}
+}
+
+func TestAllowDoesNotAllocate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"history", []Option{WithHistory(8)}},
+		{"decay", []Option{WithDecay(2, time.Minute)}},
+		{"spacing", []Option{WithMinSpacing(time.Nanosecond)}},
+		{"penalty", []Option{WithDenyPenalty(1)}},
+		{"early drop", []Option{WithEarlyDrop(5)}},
+		{"soft start", []Option{WithSoftStart(time.Minute)}},
+		{"min charge", []Option{WithMinCharge(2)}},
+		{"fail open", []Option{WithFailOpenAfter(time.Hour)}},
+		{"rollup", []Option{WithRollup(NewRollupCollector(realClock{}, 5))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Small enough that most calls are denied, so both paths run.
+			tb := NewTokenBucket(1, 10, time.Hour, tt.opts...)
+			defer tb.Stop()
+
+			if allocs := testing.AllocsPerRun(1000, func() {
+				tb.Allow()
+				tb.AllowN(3)
+			}); allocs != 0 {
+				t.Fatalf("Allow and AllowN allocate %v times per call", allocs)
+			}
+		})
+	}
+}
+
+// TestAllowMatchesAllowNOne runs Allow and AllowN(1) over the same calls on
+// twin buckets, with each option that can change a single-token decision,
+// and expects the same answers and the same bucket afterwards.
+func TestAllowMatchesAllowNOne(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func() []Option
+		// before is run on both twins ahead of step i.
+		before func(tb *TokenBucket, i int)
+	}{
+		{name: "default", opts: func() []Option { return nil }},
+		{name: "min charge", opts: func() []Option { return []Option{WithMinCharge(2)} }},
+		{name: "spacing", opts: func() []Option { return []Option{WithMinSpacing(time.Second)} }},
+		{name: "penalty", opts: func() []Option { return []Option{WithDenyPenalty(1)} }},
+		{name: "early drop", opts: func() []Option {
+			return []Option{WithEarlyDrop(4), WithRand(rand.New(rand.NewPCG(1, 2)))}
+		}},
+		{name: "decay", opts: func() []Option { return []Option{WithDecay(1, time.Second)} }},
+		{name: "soft start", opts: func() []Option { return []Option{WithSoftStart(5 * time.Second)} }},
+		{name: "history", opts: func() []Option { return []Option{WithHistory(4)} }},
+		{name: "suspended", opts: func() []Option { return nil }, before: func(tb *TokenBucket, i int) {
+			switch i {
+			case 10:
+				tb.Suspend()
+			case 20:
+				tb.Resume()
+			}
+		}},
+		{name: "stopped", opts: func() []Option { return []Option{WithDenyAfterStop(true)} }, before: func(tb *TokenBucket, i int) {
+			if i == 30 {
+				tb.Stop()
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clockA, clockB := NewManualClock(time.Unix(0, 0)), NewManualClock(time.Unix(0, 0))
+			a := NewTokenBucket(2, 5, time.Second, append(tt.opts(), WithClock(clockA))...)
+			defer a.Stop()
+			b := NewTokenBucket(2, 5, time.Second, append(tt.opts(), WithClock(clockB))...)
+			defer b.Stop()
+
+			for i := 0; i < 40; i++ {
+				if tt.before != nil {
+					tt.before(a, i)
+					tt.before(b, i)
+				}
+				// Bursts of calls then a pause, so the bucket both runs dry
+				// and refills.
+				for j := 0; j < 1+i%4; j++ {
+					if gotA, gotB := a.Allow(), b.AllowN(1); gotA != gotB {
+						t.Fatalf("step %d: Allow() = %v, AllowN(1) = %v", i, gotA, gotB)
+					}
+				}
+				if sa, sb := a.Stats(), b.Stats(); sa != sb {
+					t.Fatalf("step %d: buckets diverged:\n Allow:     %+v\n AllowN(1): %+v", i, sa, sb)
+				}
+				clockA.Advance(400 * time.Millisecond)
+				clockB.Advance(400 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestUnsetBucketActsDisabled(t *testing.T) {
+	var zero TokenBucket
+	for name, tb := range map[string]*TokenBucket{"nil": nil, "zero value": &zero} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for i := 0; i < 3; i++ {
+				if !tb.Allow() || !tb.AllowN(1000) {
+					t.Fatal("Allow denied")
+				}
+			}
+			if tb.AllowN(-1) {
+				t.Fatal("AllowN(-1) allowed")
+			}
+			if err := tb.Take(5); err != nil {
+				t.Fatalf("Take(5) = %v", err)
+			}
+			if err := tb.WaitN(5); err != nil {
+				t.Fatalf("WaitN(5) = %v", err)
+			}
+			if err := tb.WaitNContext(ctx, 5); err != nil {
+				t.Fatalf("WaitNContext = %v", err)
+			}
+			if err := tb.WaitForTokens(ctx, 5); err != nil {
+				t.Fatalf("WaitForTokens = %v", err)
+			}
+			tb.Stop()
+			tb.Stop()
+		})
+	}
+}
+
+func TestWouldAllowHasNoSideEffects(t *testing.T) {
+	opts := func(clock Clock) []Option {
+		return []Option{WithClock(clock), WithMinCharge(2), WithMinSpacing(time.Second), WithDenyPenalty(1)}
+	}
+	clockA, clockB := NewManualClock(time.Unix(0, 0)), NewManualClock(time.Unix(0, 0))
+	probed := NewTokenBucket(2, 10, 3*time.Second, opts(clockA)...)
+	defer probed.Stop()
+	plain := NewTokenBucket(2, 10, 3*time.Second, opts(clockB)...)
+	defer plain.Stop()
+
+	// The cost of each step's request; the probed bucket is asked about it
+	// first, many times over.
+	for i, n := range []int64{1, 1, 4, 4, 9, 0, 3, 10, 1, 2, 5, 5} {
+		before := probed.Stats()
+		want := plain.AllowN(n)
+		for j := 0; j < 5; j++ {
+			if got := probed.WouldAllow(n); got != want {
+				t.Fatalf("step %d: WouldAllow(%d) = %v, AllowN gave %v", i, n, got, want)
+			}
+		}
+		after := probed.Stats()
+		if after.Tokens != before.Tokens || after.Allowed != before.Allowed || after.Denied != before.Denied {
+			t.Fatalf("step %d: WouldAllow changed the bucket: %+v, then %+v", i, before, after)
+		}
+		if got := probed.AllowN(n); got != want {
+			t.Fatalf("step %d: AllowN(%d) = %v after probing, %v without", i, n, got, want)
+		}
+		clockA.Advance(700 * time.Millisecond)
+		clockB.Advance(700 * time.Millisecond)
+	}
+}
+
+// BenchmarkAllowSingleToken compares Allow's single-token fast path with the
+// general AllowN(1). The bucket is a fixed quota too large to run out, so
+// no clock is read and only the decision itself is measured.
+func BenchmarkAllowSingleToken(b *testing.B) {
+	for _, bm := range []struct {
+		name  string
+		allow func(*TokenBucket) bool
+	}{
+		{"Allow", (*TokenBucket).Allow},
+		{"AllowN(1)", func(tb *TokenBucket) bool { return tb.AllowN(1) }},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			tb := NewTokenBucket(0, 1<<62, time.Hour)
+			defer tb.Stop()
+			b.ReportAllocs()
+			for b.Loop() {
+				bm.allow(tb)
+			}
+		})
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"container/list"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ConfigFunc func(key string) Config
+
+type managedBucket struct {
+	key      string
+	tb       *TokenBucket
+	lastSeen time.Time
+	elem     *list.Element
+}
+
+type LimiterManager struct {
+	mu         sync.Mutex
+	buckets    map[string]*managedBucket
+	lru        list.List // of *managedBucket, most recently seen first
+	maxBuckets int
+	config     ConfigFunc
+	idleTTL    time.Duration
+	warmPool   int
+	pool       chan *TokenBucket
+	clock      Clock
+	stop       chan struct{}
+	stopOnce   sync.Once
+	stopped    bool
+	closed     *TokenBucket // handed out by GetOrCreate once stopped
+	// trustedKeys is replaced, never modified, so GetOrCreate can read it
+	// without the lock.
+	trustedKeys atomic.Pointer[map[string]struct{}]
+	trusted     *TokenBucket // shared by every trusted key
+	normalize   KeyNormalizer
+	herd        *herdDetector
+}
+
+type ManagerOption func(*LimiterManager)
+
+// WithIdleTTL evicts and stops buckets whose key has not been seen by
+// GetOrCreate for longer than ttl.
+func WithIdleTTL(ttl time.Duration) ManagerOption {
+	return func(m *LimiterManager) {
+		m.idleTTL = ttl
+	}
+}
+
+// WithMaxBuckets caps how many buckets the manager holds at once. Making
+// room for a new key evicts and stops the bucket whose key was seen least
+// recently, so that key starts afresh if it comes back.
+func WithMaxBuckets(n int) ManagerOption {
+	return func(m *LimiterManager) {
+		m.maxBuckets = n
+	}
+}
+
+// KeyNormalizer maps variations of a key that belong to one client, such as
+// "Key" and "key", onto a single key.
+type KeyNormalizer func(key string) string
+
+// WithKeyNormalizer applies normalize to every key the manager is given,
+// before anything else looks at it, so trivial variations of a key cannot
+// each claim a full bucket. Behind the manager's Middleware the KeyFunc
+// runs first and normalize is applied to its result; the ConfigFunc, Trust
+// and Replace all see the normalized key.
+func WithKeyNormalizer(normalize KeyNormalizer) ManagerOption {
+	return func(m *LimiterManager) {
+		m.normalize = normalize
+	}
+}
+
+// LowercaseKey is a KeyNormalizer for case-insensitive keys.
+func LowercaseKey(key string) string {
+	return strings.ToLower(key)
+}
+
+// GroupIPv6By64 is a KeyNormalizer that keys IPv6 clients by their /64
+// prefix, the block one subscriber is usually given, so rotating through
+// the addresses in it gets no extra budget. It accepts a bare address or
+// one after a "prefix:" such as FromIP's "ip:", and leaves IPv4 addresses
+// and anything else unchanged.
+func GroupIPv6By64(key string) string {
+	prefix, ip, ok := parseIPKey(key)
+	if !ok || !ip.Is6() || ip.Is4In6() {
+		return key
+	}
+	return prefix + netip.PrefixFrom(ip, 64).Masked().String()
+}
+
+// GroupByCIDR returns a KeyNormalizer that keys every client inside one of
+// blocks by that block, such as a /24 an attacker rotates addresses
+// within, so the whole block shares one bucket. An address in more than one
+// block gets the most specific. Addresses outside all of them keep their
+// own bucket. Keys are read as by GroupIPv6By64, and an IPv4-mapped IPv6
+// address matches IPv4 blocks. Invalid prefixes in blocks are ignored.
+func GroupByCIDR(blocks ...netip.Prefix) KeyNormalizer {
+	var valid []netip.Prefix
+	for _, b := range blocks {
+		if b.IsValid() {
+			valid = append(valid, b.Masked())
+		}
+	}
+	slices.SortStableFunc(valid, func(a, b netip.Prefix) int { return b.Bits() - a.Bits() })
+	return func(key string) string {
+		prefix, ip, ok := parseIPKey(key)
+		if !ok {
+			return key
+		}
+		for _, b := range valid {
+			if b.Contains(ip.Unmap()) {
+				return prefix + b.String()
+			}
+		}
+		return key
+	}
+}
+
+// parseIPKey splits a key holding an IP address, bare or after a "prefix:",
+// into the prefix, kept with its colon, and the address without any zone.
+func parseIPKey(key string) (prefix string, ip netip.Addr, ok bool) {
+	ip, err := netip.ParseAddr(key)
+	if err != nil {
+		var addr string
+		if prefix, addr, ok = strings.Cut(key, ":"); !ok {
+			return "", netip.Addr{}, false
+		}
+		if ip, err = netip.ParseAddr(addr); err != nil {
+			return "", netip.Addr{}, false
+		}
+		prefix += ":"
+	}
+	return prefix, ip.WithZone(""), true
+}
+
+func (m *LimiterManager) normalizeKey(key string) string {
+	if m.normalize == nil {
+		return key
+	}
+	return m.normalize(key)
+}
+
+// WithManagerClock gives every bucket the manager creates, and its idle
+// eviction, the same clock. With a ManualClock, one Advance steps them all.
+func WithManagerClock(c Clock) ManagerOption {
+	return func(m *LimiterManager) {
+		m.clock = c
+	}
+}
+
+func NewLimiterManager(config ConfigFunc, opts ...ManagerOption) *LimiterManager {
+	m := &LimiterManager{
+		buckets: make(map[string]*managedBucket),
+		config:  config,
+		clock:   realClock{},
+		stop:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.herd != nil {
+		m.herd.clock = m.clock
+	}
+
+	if m.idleTTL > 0 {
+		go m.evictIdle(newTicker(m.clock, m.idleTTL, m.evictIdleOnce))
+	}
+	if m.warmPool > 0 {
+		m.pool = make(chan *TokenBucket, m.warmPool)
+		go m.fillPool()
+	}
+
+	return m
+}
+
+func (m *LimiterManager) evictIdle(ticker ticker) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			m.evictIdleOnce()
+
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *LimiterManager) evictIdleOnce() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	for key, e := range m.buckets {
+		if now.Sub(e.lastSeen) > m.idleTTL {
+			m.removeLocked(key, e)
+		}
+	}
+}
+
+// GetOrCreate returns the bucket for key, creating it on first sight. The
+// ConfigFunc is consulted on every call, so a key whose config changes
+// between calls has its existing bucket reconfigured in place.
+//
+// Once the manager is stopped it no longer limits: every key gets a single
+// disabled, already stopped bucket that allows everything, so requests
+// still in flight during a shutdown finish, and no bucket is started that
+// nothing would stop.
+func (m *LimiterManager) GetOrCreate(key string) *TokenBucket {
+	key = m.normalizeKey(key)
+	if trusted := m.trustedKeys.Load(); trusted != nil {
+		if _, ok := (*trusted)[key]; ok {
+			return m.trusted
+		}
+	}
+	m.mu.Lock()
+	config := m.config
+	m.mu.Unlock()
+	cfg := config(key)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stopped {
+		return m.closed
+	}
+	now := m.clock.Now()
+	e, ok := m.buckets[key]
+	if !ok {
+		tb := m.takeWarm(cfg)
+		if tb == nil {
+			tb = newTokenBucketFromConfig(cfg, WithClock(m.clock))
+		}
+		e = &managedBucket{tb: tb, lastSeen: now}
+		m.addLocked(key, e)
+		return e.tb
+	}
+	if e.tb.Config() != cfg {
+		e.tb.Reconfigure(cfg)
+	}
+	e.lastSeen = now
+	m.lru.MoveToFront(e.elem)
+
+	return e.tb
+}
+
+// Replace swaps key's bucket for a new one built from cfg, carrying over the
+// token balance (clamped to the new capacity) and refill progress, and
+// stops the old one; a key with no bucket yet gets a full one. Readers see
+// either the old bucket or the new one, never one halfway through a
+// change. A caller still holding a *TokenBucket returned earlier keeps
+// using the old, stopped instance, so call GetOrCreate for each request
+// rather than caching its result. The ConfigFunc is not changed: if it
+// disagrees with cfg, the next GetOrCreate reconfigures the new bucket to
+// match it. A stopped manager returns ErrStopped.
+func (m *LimiterManager) Replace(key string, cfg Config) error {
+	key = m.normalizeKey(key)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stopped {
+		return ErrStopped
+	}
+	e, ok := m.buckets[key]
+	if !ok {
+		m.addLocked(key, &managedBucket{tb: newTokenBucketFromConfig(cfg, WithClock(m.clock)), lastSeen: m.clock.Now()})
+		return nil
+	}
+	state := e.tb.State()
+	state.Config = cfg
+	tb, err := RestoreTokenBucket(state, WithClock(m.clock))
+	if err != nil {
+		return err
+	}
+	if m.herd != nil {
+		m.herd.unwatch(e.tb)
+		m.herd.watch(key, tb)
+	}
+	old := e.tb
+	e.tb = tb
+	old.Stop()
+	return nil
+}
+
+// Trust exempts key from limiting: GetOrCreate returns a single disabled
+// bucket shared by every trusted key, which allows everything and holds
+// nothing per key, so trusted keys are never rejected and cost no memory.
+// A bucket the key already had is stopped and dropped.
+func (m *LimiterManager) Trust(key string) {
+	key = m.normalizeKey(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.trusted == nil {
+		m.trusted = newTokenBucketFromConfig(Config{Rate: 0, Capacity: 1, Interval: time.Hour, Disabled: true}, WithName("trusted"), WithClock(m.clock), withoutRefillLog())
+	}
+	m.setTrustedLocked(func(keys map[string]struct{}) { keys[key] = struct{}{} })
+	if e, ok := m.buckets[key]; ok {
+		m.removeLocked(key, e)
+	}
+}
+
+// Untrust limits key again, from a fresh bucket on its next GetOrCreate.
+func (m *LimiterManager) Untrust(key string) {
+	key = m.normalizeKey(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.setTrustedLocked(func(keys map[string]struct{}) { delete(keys, key) })
+}
+
+// setTrustedLocked publishes a copy of the trusted keys with edit applied.
+func (m *LimiterManager) setTrustedLocked(edit func(map[string]struct{})) {
+	keys := make(map[string]struct{})
+	if old := m.trustedKeys.Load(); old != nil {
+		maps.Copy(keys, *old)
+	}
+	edit(keys)
+	m.trustedKeys.Store(&keys)
+}
+
+// addLocked holds e under key, in place of any bucket already there, and
+// then evicts the least recently seen buckets beyond the cap.
+func (m *LimiterManager) addLocked(key string, e *managedBucket) {
+	if old, ok := m.buckets[key]; ok {
+		m.removeLocked(key, old)
+	}
+	e.key = key
+	m.buckets[key] = e
+	if m.herd != nil {
+		m.herd.added()
+		m.herd.watch(key, e.tb)
+	}
+
+	// Usually e was seen just now and goes straight to the front; an
+	// imported bucket may belong further back.
+	mark := m.lru.Front()
+	for mark != nil && mark.Value.(*managedBucket).lastSeen.After(e.lastSeen) {
+		mark = mark.Next()
+	}
+	if mark == nil {
+		e.elem = m.lru.PushBack(e)
+	} else {
+		e.elem = m.lru.InsertBefore(e, mark)
+	}
+
+	for m.maxBuckets > 0 && len(m.buckets) > m.maxBuckets {
+		oldest := m.lru.Back().Value.(*managedBucket)
+		m.removeLocked(oldest.key, oldest)
+	}
+}
+
+func (m *LimiterManager) removeLocked(key string, e *managedBucket) {
+	e.tb.Stop()
+	m.lru.Remove(e.elem)
+	delete(m.buckets, key)
+	if m.herd != nil {
+		m.herd.unwatch(e.tb)
+		m.herd.removed(key)
+	}
+}
+
+// ForEach calls fn for every bucket the manager holds. It iterates over a
+// snapshot taken up front, so fn may freely call back into the manager, and
+// buckets created or evicted meanwhile may or may not be visited.
+func (m *LimiterManager) ForEach(fn func(key string, tb *TokenBucket)) {
+	m.mu.Lock()
+	keys := make([]string, 0, len(m.buckets))
+	buckets := make([]*TokenBucket, 0, len(m.buckets))
+	for key, e := range m.buckets {
+		keys = append(keys, key)
+		buckets = append(buckets, e.tb)
+	}
+	m.mu.Unlock()
+
+	for i, key := range keys {
+		fn(key, buckets[i])
+	}
+}
+
+// ReconfigureAll applies cfg to every existing bucket and makes it the
+// config for keys created from now on, replacing the manager's ConfigFunc.
+func (m *LimiterManager) ReconfigureAll(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.config = func(string) Config { return cfg }
+	m.mu.Unlock()
+
+	m.ForEach(func(_ string, tb *TokenBucket) {
+		tb.Reconfigure(cfg)
+	})
+	return nil
+}
+
+// Stop is StopAll, so a manager can go wherever something with a Stop method
+// is wanted, such as AttachToServer.
+func (m *LimiterManager) Stop() {
+	m.StopAll()
+}
+
+// StopAll stops and drops every bucket. Afterwards GetOrCreate no longer
+// creates buckets, Replace fails with ErrStopped and Import does nothing.
+func (m *LimiterManager) StopAll() {
+	m.stopOnce.Do(func() { close(m.stop) })
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.stopped {
+		m.stopped = true
+		m.closed = newTokenBucketFromConfig(Config{Rate: 0, Capacity: 1, Interval: time.Hour, Disabled: true}, WithName("stopped"), WithClock(m.clock), withoutRefillLog())
+		m.closed.Stop()
+	}
+	for key, e := range m.buckets {
+		m.removeLocked(key, e)
+	}
+	if m.trusted != nil {
+		m.trusted.Stop()
+	}
+}
+
+// GrowingCapacity returns a ConfigFunc that puts new keys on probation: a
+// key's first sighting gets initial capacity, and every later sighting
+// raises it by step until it reaches base.Capacity. Rate and interval always
+// come from base, so only the burst a fresh key can spend is reduced, which
+// makes rotating through many keys expensive.
+//
+// Sighting counts are kept for at most maxKeys keys; past that, the key
+// seen least recently is forgotten and goes back on probation if it
+// returns, so churning through keys can't grow memory without bound. Make
+// maxKeys at least the number of buckets the manager may hold (see
+// WithMaxBuckets), or a forgotten key's live bucket is shrunk back to
+// initial capacity on its next request.
+func GrowingCapacity(base Config, initial, step int64, maxKeys int) ConfigFunc {
+	type sighting struct {
+		key   string
+		count int64
+	}
+	var mu sync.Mutex
+	var lru list.List // of *sighting, most recently seen first
+	sightings := make(map[string]*list.Element)
+
+	return func(key string) Config {
+		mu.Lock()
+		defer mu.Unlock()
+
+		e, ok := sightings[key]
+		if ok {
+			lru.MoveToFront(e)
+		} else {
+			e = lru.PushFront(&sighting{key: key})
+			sightings[key] = e
+			for len(sightings) > max(maxKeys, 1) {
+				oldest := lru.Remove(lru.Back()).(*sighting)
+				delete(sightings, oldest.key)
+			}
+		}
+		s := e.Value.(*sighting)
+
+		cfg := base
+		capacity := initial + s.count*step
+		if capacity < base.Capacity {
+			s.count++
+			cfg.Capacity = capacity
+		}
+
+		return cfg
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGrowingCapacityGrowsToCeiling(t *testing.T) {
+	m := NewLimiterManager(GrowingCapacity(Config{Rate: 1, Capacity: 10, Interval: time.Hour}, 2, 3, 100))
+	defer m.StopAll()
+
+	var got []int64
+	for i := 0; i < 6; i++ {
+		got = append(got, m.GetOrCreate("a").Config().Capacity)
+	}
+	if want := []int64{2, 5, 8, 10, 10, 10}; !slices.Equal(got, want) {
+		t.Fatalf("capacity across sightings = %v, want %v", got, want)
+	}
+	if c := m.GetOrCreate("b").Config().Capacity; c != 2 {
+		t.Fatalf("new key capacity = %d, want 2", c)
+	}
+}
+
+func TestGrowingCapacityForgetsLeastRecentKeys(t *testing.T) {
+	config := GrowingCapacity(Config{Rate: 1, Capacity: 10, Interval: time.Hour}, 2, 3, 3)
+
+	for i := 0; i < 4; i++ {
+		config("a")
+	}
+	if c := config("a").Capacity; c != 10 {
+		t.Fatalf("grown key capacity = %d, want 10", c)
+	}
+	// Two other keys still leave room for "a".
+	config("b")
+	config("c")
+	if c := config("a").Capacity; c != 10 {
+		t.Fatalf("capacity after 2 other keys = %d, want 10", c)
+	}
+	for i := 0; i < 1000; i++ {
+		config(fmt.Sprint("churn-", i))
+	}
+	if c := config("a").Capacity; c != 2 {
+		t.Fatalf("capacity after churn = %d, want 2: the key should be back on probation", c)
+	}
+}
+
+func TestReconfigureAllAppliesToExistingAndNewKeys(t *testing.T) {
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 1, Capacity: 10, Interval: time.Second} })
+	defer m.StopAll()
+	for _, key := range []string{"a", "b", "c"} {
+		m.GetOrCreate(key)
+	}
+
+	want := Config{Rate: 5, Capacity: 20, Interval: time.Second}
+	if err := m.ReconfigureAll(want); err != nil {
+		t.Fatal(err)
+	}
+	visited := 0
+	m.ForEach(func(key string, tb *TokenBucket) {
+		visited++
+		if got := tb.Config(); got != want {
+			t.Errorf("%s: Config() = %+v, want %+v", key, got, want)
+		}
+	})
+	if visited != 3 {
+		t.Fatalf("ForEach visited %d buckets, want 3", visited)
+	}
+	if got := m.GetOrCreate("d").Config(); got != want {
+		t.Fatalf("new key: Config() = %+v, want %+v", got, want)
+	}
+
+	if err := m.ReconfigureAll(Config{Rate: -1, Capacity: 20, Interval: time.Second}); err == nil {
+		t.Fatal("ReconfigureAll accepted an invalid config")
+	}
+}
+
+func TestForEachDuringConcurrentChurn(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 1, Capacity: 1, Interval: time.Second} },
+		WithManagerClock(clock), WithIdleTTL(time.Second), WithMaxBuckets(50))
+	defer m.StopAll()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			m.GetOrCreate(fmt.Sprint(i))
+			if i%50 == 0 {
+				clock.Advance(2 * time.Second)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			m.ForEach(func(string, *TokenBucket) {})
+		}
+	}
+}
+
+func TestReplaceSwapsInANewBucketWithTheBalance(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cfg := Config{Rate: 1, Capacity: 10, Interval: time.Hour}
+	m := NewLimiterManager(func(string) Config { return cfg }, WithManagerClock(clock))
+	defer m.StopAll()
+
+	old := m.GetOrCreate("k")
+	old.AllowN(4)
+	cfg = Config{Rate: 2, Capacity: 20, Interval: time.Hour}
+	if err := m.Replace("k", cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tb := m.GetOrCreate("k")
+	if tb == old {
+		t.Fatal("GetOrCreate still returns the old bucket")
+	}
+	if got := tb.Config(); got != cfg {
+		t.Fatalf("new bucket config = %+v, want %+v", got, cfg)
+	}
+	if got := tb.AvailableTokens(); got != 6 {
+		t.Fatalf("new bucket has %d tokens, want the carried-over 6", got)
+	}
+	old.mu.Lock()
+	stopped := old.stopped
+	old.mu.Unlock()
+	if !stopped {
+		t.Fatal("the old bucket was not stopped")
+	}
+	clock.Advance(time.Hour)
+	if got := tb.AvailableTokens(); got != 8 {
+		t.Fatalf("new bucket has %d tokens after a refill, want 8 at the new rate", got)
+	}
+
+	if err := m.Replace("k", Config{Rate: 1, Capacity: 0, Interval: 0}); err == nil {
+		t.Fatal("Replace accepted an invalid config")
+	}
+	if m.GetOrCreate("k") != tb {
+		t.Fatal("a failed Replace changed the bucket")
+	}
+	if err := m.Replace("fresh", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetOrCreate("fresh").AvailableTokens(); got != 20 {
+		t.Fatalf("a key replaced before first use has %d tokens, want a full 20", got)
+	}
+}
+
+func TestTrustedKeysBypassLimiting(t *testing.T) {
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 1, Capacity: 2, Interval: time.Hour} })
+	defer m.StopAll()
+
+	m.GetOrCreate("internal").AllowN(2)
+	m.Trust("internal")
+	for i := 0; i < 10; i++ {
+		if !m.GetOrCreate("internal").Allow() {
+			t.Fatalf("trusted key denied on request %d", i+1)
+		}
+	}
+	if m.GetOrCreate("other") == m.GetOrCreate("internal") {
+		t.Fatal("an untrusted key got the trusted bucket")
+	}
+	var held []string
+	m.ForEach(func(key string, _ *TokenBucket) { held = append(held, key) })
+	if !slices.Equal(held, []string{"other"}) {
+		t.Fatalf("manager holds buckets for %v, want only the untrusted key", held)
+	}
+
+	m.Untrust("internal")
+	tb := m.GetOrCreate("internal")
+	if !tb.AllowN(2) || tb.Allow() {
+		t.Fatal("an untrusted key is not limited by a fresh bucket of capacity 2")
+	}
+}
+
+func TestGroupIPv6By64SharesABucket(t *testing.T) {
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 1, Capacity: 1, Interval: time.Hour} },
+		WithKeyNormalizer(GroupIPv6By64))
+	defer m.StopAll()
+	h := m.Middleware(FromIP)(statusHandler(http.StatusOK))
+	request := func(addr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if got := request("[2001:db8:1:2::1]:1234"); got != http.StatusOK {
+		t.Fatalf("first address: status %d", got)
+	}
+	if got := request("[2001:db8:1:2:ffff::9]:1234"); got != http.StatusTooManyRequests {
+		t.Fatalf("same /64: status %d, want 429 from the shared bucket", got)
+	}
+	if got := request("[2001:db8:1:3::1]:1234"); got != http.StatusOK {
+		t.Fatalf("next /64: status %d, want 200 from its own bucket", got)
+	}
+	if got := request("192.0.2.1:1234"); got != http.StatusOK {
+		t.Fatalf("IPv4: status %d", got)
+	}
+}
+
+func TestGroupByCIDRSharesABucketPerBlock(t *testing.T) {
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 1, Capacity: 1, Interval: time.Hour} },
+		WithKeyNormalizer(GroupByCIDR(netip.MustParsePrefix("198.51.100.0/24"), netip.MustParsePrefix("2001:db8::/48"))))
+	defer m.StopAll()
+
+	shared := m.GetOrCreate("ip:198.51.100.1")
+	for i := 2; i < 255; i++ {
+		if m.GetOrCreate(fmt.Sprintf("ip:198.51.100.%d", i)) != shared {
+			t.Fatalf("198.51.100.%d got its own bucket inside the /24", i)
+		}
+	}
+	if !shared.Allow() || m.GetOrCreate("ip:198.51.100.77").Allow() {
+		t.Fatal("addresses in the /24 do not spend one budget")
+	}
+	if other := m.GetOrCreate("ip:198.51.101.1"); other == shared || !other.Allow() {
+		t.Fatal("an address outside the /24 does not get its own bucket")
+	}
+	if m.GetOrCreate("ip:198.51.101.1") == m.GetOrCreate("ip:198.51.101.2") {
+		t.Fatal("addresses outside every block were grouped")
+	}
+}
+
+func TestKeyNormalizers(t *testing.T) {
+	byCIDR := GroupByCIDR(netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("10.1.0.0/16"),
+		netip.MustParsePrefix("2001:db8::/32"), netip.Prefix{})
+	for _, tc := range []struct {
+		normalize KeyNormalizer
+		key, want string
+	}{
+		{GroupIPv6By64, "2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{GroupIPv6By64, "ip:2001:db8:1:2:3:4:5:6", "ip:2001:db8:1:2::/64"},
+		{GroupIPv6By64, "ip:fe80::1%eth0", "ip:fe80::/64"},
+		{GroupIPv6By64, "ip:192.0.2.1", "ip:192.0.2.1"},
+		{GroupIPv6By64, "ip:::ffff:192.0.2.1", "ip:::ffff:192.0.2.1"},
+		{GroupIPv6By64, "X-API-Key:abc", "X-API-Key:abc"},
+		{LowercaseKey, "X-API-Key:AbC", "x-api-key:abc"},
+		{byCIDR, "ip:10.1.2.3", "ip:10.1.0.0/16"},
+		{byCIDR, "10.9.2.3", "10.0.0.0/8"},
+		{byCIDR, "ip:::ffff:10.1.2.3", "ip:10.1.0.0/16"},
+		{byCIDR, "ip:2001:db8:0:1::5", "ip:2001:db8::/32"},
+		{byCIDR, "ip:192.0.2.1", "ip:192.0.2.1"},
+		{byCIDR, "X-API-Key:abc", "X-API-Key:abc"},
+	} {
+		if got := tc.normalize(tc.key); got != tc.want {
+			t.Errorf("normalize(%q) = %q, want %q", tc.key, got, tc.want)
+		}
+	}
+
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 1, Capacity: 1, Interval: time.Hour} },
+		WithKeyNormalizer(LowercaseKey))
+	defer m.StopAll()
+	if m.GetOrCreate("KEY") != m.GetOrCreate("key") {
+		t.Fatal("keys differing only in case got separate buckets")
+	}
+}
+
+func TestGetOrCreateAfterStopAllStartsNoBuckets(t *testing.T) {
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 1, Capacity: 1, Interval: time.Millisecond} })
+	m.GetOrCreate("early").Allow()
+	m.StopAll()
+	m.StopAll()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		tb := m.GetOrCreate(fmt.Sprint("late", i))
+		if !tb.AllowN(5) || !tb.AllowN(5) {
+			t.Fatalf("late request %d denied: a stopped manager should no longer limit", i)
+		}
+	}
+	if got := runtime.NumGoroutine(); got > before {
+		t.Fatalf("%d goroutines after late requests, want at most the %d before", got, before)
+	}
+	var held []string
+	m.ForEach(func(key string, _ *TokenBucket) { held = append(held, key) })
+	if len(held) != 0 {
+		t.Fatalf("stopped manager holds buckets for %v", held)
+	}
+	if err := m.Replace("late0", Config{Rate: 1, Capacity: 1, Interval: time.Second}); err != ErrStopped {
+		t.Fatalf("Replace after StopAll = %v, want ErrStopped", err)
+	}
+	m.Import(ManagerState{Buckets: map[string]BucketState{"restored": {Config: Config{Rate: 1, Capacity: 1, Interval: time.Second}}}})
+	m.ForEach(func(key string, _ *TokenBucket) { t.Fatalf("Import after StopAll added %q", key) })
+}
@@ -0,0 +1,67 @@
+package main
+
+// MergePolicy decides how Merge combines two buckets.
+type MergePolicy struct {
+	// Capacity is SumCapacity (the default) or MaxCapacity.
+	Capacity CapacityPolicy
+	// Rate is MinRate (the default), keeping the stricter of the two, or
+	// MaxRate, keeping the looser. Rates are compared per unit of time, and
+	// the chosen bucket's rate and interval are taken together.
+	Rate RatePolicy
+	// StopOriginals stops a and b once the merged bucket exists.
+	StopOriginals bool
+}
+
+type CapacityPolicy int
+
+const (
+	SumCapacity CapacityPolicy = iota
+	MaxCapacity
+)
+
+type RatePolicy int
+
+const (
+	MinRate RatePolicy = iota
+	MaxRate
+)
+
+// Merge builds a new bucket combining a and b, e.g. when two tenants are
+// consolidated. Its balance is the sum of theirs, clamped to the merged
+// capacity, and it uses a's clock. It is disabled only if both are.
+func Merge(a, b *TokenBucket, policy MergePolicy) *TokenBucket {
+	ca, tokensA := a.mergeSnapshot()
+	cb, tokensB := b.mergeSnapshot()
+
+	cfg := ca
+	aFaster := fasterRate(ca.Rate, ca.Interval, cb.Rate, cb.Interval)
+	if aFaster == (policy.Rate == MinRate) {
+		cfg.Rate, cfg.Interval = cb.Rate, cb.Interval
+	}
+	if policy.Capacity == MaxCapacity {
+		cfg.Capacity = max(ca.Capacity, cb.Capacity)
+	} else {
+		cfg.Capacity = ca.Capacity + cb.Capacity
+	}
+	cfg.Disabled = ca.Disabled && cb.Disabled
+
+	merged := newTokenBucketFromConfig(cfg, WithClock(a.Clock()))
+	merged.mu.Lock()
+	merged.tokens = min(tokensA+tokensB, cfg.Capacity)
+	merged.publishLocked()
+	merged.mu.Unlock()
+
+	if policy.StopOriginals {
+		a.Stop()
+		b.Stop()
+	}
+	return merged
+}
+
+func (tb *TokenBucket) mergeSnapshot() (Config, int64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	return tb.configLocked(), tb.tokens
+}
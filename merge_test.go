@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergePolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy MergePolicy
+		want   Config
+		tokens int64
+	}{
+		{"sum, min rate", MergePolicy{}, Config{Rate: 1, Capacity: 30, Interval: time.Second}, 25},
+		{"sum, max rate", MergePolicy{Rate: MaxRate}, Config{Rate: 30, Capacity: 30, Interval: 10 * time.Second}, 25},
+		{"max, min rate", MergePolicy{Capacity: MaxCapacity}, Config{Rate: 1, Capacity: 20, Interval: time.Second}, 20},
+		{"max, max rate", MergePolicy{Capacity: MaxCapacity, Rate: MaxRate}, Config{Rate: 30, Capacity: 20, Interval: 10 * time.Second}, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 1 per second against 30 per 10s.
+			a := NewTokenBucket(1, 10, time.Second)
+			defer a.Stop()
+			b := NewTokenBucket(30, 20, 10*time.Second)
+			defer b.Stop()
+			a.AllowN(5)
+
+			merged := Merge(a, b, tt.policy)
+			defer merged.Stop()
+			if got := merged.Config(); got != tt.want {
+				t.Fatalf("Config() = %+v, want %+v", got, tt.want)
+			}
+			if got := merged.AvailableTokens(); got != tt.tokens {
+				t.Fatalf("%d tokens, want %d", got, tt.tokens)
+			}
+		})
+	}
+}
+
+func TestMergeComparesRatesWithoutOverflow(t *testing.T) {
+	// 1e7 per second times an hour in nanoseconds overflows int64.
+	fast := NewTokenBucket(10_000_000, 10_000_000, time.Second)
+	defer fast.Stop()
+	slow := NewTokenBucket(1, 1, time.Hour)
+	defer slow.Stop()
+
+	for _, order := range [][2]*TokenBucket{{fast, slow}, {slow, fast}} {
+		merged := Merge(order[0], order[1], MergePolicy{Rate: MinRate})
+		got := merged.Config()
+		merged.Stop()
+		if got.Rate != 1 || got.Interval != time.Hour {
+			t.Fatalf("MinRate merge kept %d per %v, want 1 per 1h", got.Rate, got.Interval)
+		}
+	}
+}
+
+func TestMergeStopOriginals(t *testing.T) {
+	a := NewTokenBucket(1, 1, time.Hour, WithDenyAfterStop(true))
+	b := NewTokenBucket(1, 1, time.Hour, WithDenyAfterStop(true))
+	merged := Merge(a, b, MergePolicy{StopOriginals: true})
+	defer merged.Stop()
+
+	if a.Allow() || b.Allow() {
+		t.Fatal("originals still allow after a merge with StopOriginals")
+	}
+	if !merged.AllowN(2) {
+		t.Fatal("merged bucket denied the originals' combined balance")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// MetricsHandler serves the decision counters and token levels of buckets
+// in the Prometheus text exposition format, labelled by bucket name, with
+// denials also labelled by DenyReason. It is hand-rolled so the package
+// needs no client library.
+func MetricsHandler(buckets ...*TokenBucket) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w, buckets...)
+	})
+}
+
+func WriteMetrics(w io.Writer, buckets ...*TokenBucket) {
+	stats := make([]Stats, len(buckets))
+	for i, tb := range buckets {
+		stats[i] = tb.Stats()
+	}
+
+	metrics := []struct {
+		name, kind, help string
+		value            func(Stats) int64
+		// byReason, if set, replaces value with one sample per reason.
+		byReason func(Stats) DenyCounts
+	}{
+		{name: "tokenbucket_allowed_total", kind: "counter", help: "Requests allowed.", value: func(s Stats) int64 { return s.Allowed }},
+		{name: "tokenbucket_denied_total", kind: "counter", help: "Requests denied, by reason.", byReason: func(s Stats) DenyCounts { return s.DeniedBy }},
+		{name: "tokenbucket_tokens", kind: "gauge", help: "Tokens currently in the bucket.", value: func(s Stats) int64 { return s.Tokens }},
+		{name: "tokenbucket_capacity", kind: "gauge", help: "Bucket capacity.", value: func(s Stats) int64 { return s.Capacity }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range stats {
+			bucket := labelEscaper.Replace(s.Name)
+			if m.byReason == nil {
+				fmt.Fprintf(w, "%s{bucket=\"%s\"} %d\n", m.name, bucket, m.value(s))
+				continue
+			}
+			for reason, n := range m.byReason(s) {
+				fmt.Fprintf(w, "%s{bucket=\"%s\",reason=\"%s\"} %d\n", m.name, bucket, DenyReason(reason), n)
+			}
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var sampleLine = regexp.MustCompile(`^([a-z_]+)\{bucket="((?:[^"\\]|\\.)*)"(?:,reason="([a-z_]+)")?\} (-?\d+)$`)
+
+// scrape fetches h's exposition and returns each sample keyed by metric name
+// and bucket label, followed by the reason label if there is one, and each
+// metric's declared type.
+func scrape(t *testing.T, h http.Handler) (samples map[string]int64, types map[string]string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type = %q, want text/plain", ct)
+	}
+
+	samples, types = make(map[string]int64), make(map[string]string)
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, kind, _ := strings.Cut(rest, " ")
+			types[name] = kind
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("unparseable line %q", line)
+		}
+		if _, ok := types[m[1]]; !ok {
+			t.Fatalf("sample %q before its TYPE line", line)
+		}
+		key := m[1] + "/" + m[2]
+		if m[3] != "" {
+			key += "/" + m[3]
+		}
+		v, _ := strconv.ParseInt(m[4], 10, 64)
+		samples[key] = v
+	}
+	return samples, types
+}
+
+func TestMetricsHandlerExposition(t *testing.T) {
+	api := NewTokenBucket(1, 5, time.Hour, WithName("api"))
+	defer api.Stop()
+	odd := NewTokenBucket(1, 2, time.Hour, WithName(`say "hi"`))
+	defer odd.Stop()
+	api.AllowN(3)
+	api.AllowN(3)
+
+	samples, types := scrape(t, MetricsHandler(api, odd))
+	want := map[string]int64{
+		"tokenbucket_allowed_total/api":        1,
+		"tokenbucket_denied_total/api/empty":   1,
+		"tokenbucket_denied_total/api/spacing": 0,
+		"tokenbucket_tokens/api":               2,
+		"tokenbucket_capacity/api":             5,
+		`tokenbucket_capacity/say \"hi\"`:      2,
+		`tokenbucket_allowed_total/say \"hi\"`: 0,
+	}
+	for key, v := range want {
+		if got, ok := samples[key]; !ok || got != v {
+			t.Errorf("%s = %d (present %v), want %d", key, got, ok, v)
+		}
+	}
+	for name, kind := range map[string]string{
+		"tokenbucket_allowed_total": "counter",
+		"tokenbucket_denied_total":  "counter",
+		"tokenbucket_tokens":        "gauge",
+		"tokenbucket_capacity":      "gauge",
+	} {
+		if types[name] != kind {
+			t.Errorf("TYPE of %s = %q, want %q", name, types[name], kind)
+		}
+	}
+}
+
+func TestMetricsDeniedByReason(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 4, time.Second, WithName("api"), WithClock(clock), WithDenyPenalty(2))
+	defer tb.Stop()
+
+	tb.AllowN(4)
+	tb.AllowN(5) // over capacity, and the penalty puts the bucket in debt
+	tb.Allow()   // cooling down
+	tb.Allow()
+
+	samples, _ := scrape(t, MetricsHandler(tb))
+	for reason, want := range map[string]int64{
+		"empty":         0,
+		"over_capacity": 1,
+		"cooldown":      2,
+		"spacing":       0,
+		"draining":      0,
+		"stopped":       0,
+	} {
+		key := "tokenbucket_denied_total/api/" + reason
+		if got, ok := samples[key]; !ok || got != want {
+			t.Errorf("%s = %d (present %v), want %d", key, got, ok, want)
+		}
+	}
+	if s := tb.Stats(); s.DeniedBy[DenyCooldown] != 2 || s.Denied != 3 {
+		t.Fatalf("Stats: %d denied, %d of them cooling down; want 3 and 2", s.Denied, s.DeniedBy[DenyCooldown])
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type KeyFunc func(r *http.Request) string
+
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type middlewareConfig struct {
+	chargeIf         func(statusCode int) bool
+	retryAfterFormat RetryAfterFormat
+	denyWork         *TokenBucket
+	global           *TokenBucket
+	refundOnPanic    bool
+	backoff          *DenyBackoff
+	tokensPerMs      float64
+	headerSample     float64
+	headerMu         sync.Mutex
+	headerRand       *rand.Rand
+}
+
+type MiddlewareOption func(*middlewareConfig)
+
+type RetryAfterFormat int
+
+const (
+	// SecondsFormat sends Retry-After as a number of seconds. It is the
+	// default.
+	SecondsFormat RetryAfterFormat = iota
+	// DateFormat sends Retry-After as an HTTP-date, for clients that only
+	// understand that form.
+	DateFormat
+)
+
+func WithRetryAfterFormat(format RetryAfterFormat) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.retryAfterFormat = format
+	}
+}
+
+// WithChargeIf defers the decision to charge until the handler has run. A
+// token is still taken up front, so requests are denied as usual when the
+// bucket is empty, but it is refunded if chargeIf returns false for the
+// response status.
+func WithChargeIf(chargeIf func(statusCode int) bool) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.chargeIf = chargeIf
+	}
+}
+
+// WithDenyWorkLimit caps the work done for denied requests (the denial log
+// line and the Retry-After header) at rate per second, so a flood of
+// rejected traffic can't make rejecting it expensive. Denials beyond the
+// limit still get a bare 429. The internal bucket is never stopped and
+// lives as long as the process; use WithDenyWorkBucket to control its
+// lifetime.
+func WithDenyWorkLimit(rate int64) MiddlewareOption {
+	return WithDenyWorkBucket(NewTokenBucket(rate, rate, time.Second, WithName("deny-work"), withoutRefillLog()))
+}
+
+// WithDenyWorkBucket is WithDenyWorkLimit with a bucket the caller owns and
+// stops, spending one token per denial whose log line and Retry-After are
+// produced.
+func WithDenyWorkBucket(tb *TokenBucket) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.denyWork = tb
+	}
+}
+
+// WithGlobalBucket makes every request pass global as well as its own
+// bucket. The two are told apart in the response: running out of the
+// request's own bucket is the client's doing and gets 429, while an empty
+// global bucket means the whole server is overloaded and gets 503. The own
+// bucket is checked first, so abusive clients are turned away without
+// spending global tokens, and its token is refunded when the global bucket
+// then denies.
+func WithGlobalBucket(global *TokenBucket) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.global = global
+	}
+}
+
+// WithChargeByDuration charges each admitted request for the time its
+// handler ran, on the bucket's clock, for fairness between tenants whose
+// requests cost wildly different amounts. The request is admitted for the
+// usual single token; once the handler returns, tokensPerMs tokens per
+// millisecond it took, rounded down, are debited on top. The debit is taken
+// even if the balance cannot cover it, so the bucket can go into debt, as
+// far as -capacity, and a tenant whose handlers run long is denied
+// afterwards until refills pay it off. The global bucket is not charged for
+// the time.
+func WithChargeByDuration(tokensPerMs float64) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.tokensPerMs = tokensPerMs
+	}
+}
+
+// WithRefundOnPanic gives back the request's token if the handler panics,
+// since the request did no useful work. The panic is re-raised once the
+// token is returned, so recovery middleware further out still sees it.
+// Without this option the middleware does not recover at all.
+func WithRefundOnPanic(refund bool) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.refundOnPanic = refund
+	}
+}
+
+// WithHeaderSampleRate sends X-RateLimit-Limit and X-RateLimit-Remaining,
+// the bucket's capacity and the tokens left after the decision, on a
+// random fraction p of allowed responses and on every denial that gets a
+// Retry-After. At very high request rates this keeps the headers mostly
+// informative without paying for them on every response; p of 1 sends them
+// always. Without this option no such headers are sent.
+func WithHeaderSampleRate(p float64) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.headerSample = p
+	}
+}
+
+// WithHeaderRand gives WithHeaderSampleRate its own random source, e.g. a
+// seeded one so that which responses carry headers is reproducible in
+// tests.
+func WithHeaderRand(r *rand.Rand) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.headerRand = r
+	}
+}
+
+// Middleware limits every request against tb.
+func Middleware(tb *TokenBucket, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	return limit(func(*http.Request) *TokenBucket { return tb }, opts)
+}
+
+// LimitRoute registers h on mux for pattern, limited by tb. Patterns use the
+// Go 1.22 ServeMux syntax, so each route ("GET /search", "POST /upload/{id}")
+// can get its own bucket while other routes on the mux stay unlimited.
+func LimitRoute(mux *http.ServeMux, pattern string, tb *TokenBucket, h http.Handler, opts ...MiddlewareOption) {
+	mux.Handle(pattern, Middleware(tb, opts...)(h))
+}
+
+// Middleware limits each request against the bucket the manager holds for
+// its key.
+func (m *LimiterManager) Middleware(keyFn KeyFunc, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	return limit(func(r *http.Request) *TokenBucket { return m.GetOrCreate(keyFn(r)) }, opts)
+}
+
+func limit(bucketFor func(*http.Request) *TokenBucket, opts []MiddlewareOption) func(http.Handler) http.Handler {
+	var cfg middlewareConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tb := bucketFor(r)
+			ok, info := tb.allowInfo()
+			tb.trace(r.Context(), 1, ok, info.Remaining)
+			if !ok {
+				cfg.deny(w, r, tb, info, http.StatusTooManyRequests)
+				return
+			}
+			if cfg.global != nil {
+				ok, globalInfo := cfg.global.allowInfo()
+				cfg.global.trace(r.Context(), 1, ok, globalInfo.Remaining)
+				if !ok {
+					tb.Refund(1)
+					cfg.deny(w, r, cfg.global, globalInfo, http.StatusServiceUnavailable)
+					return
+				}
+			}
+			if cfg.backoff != nil {
+				cfg.backoff.allowed(r)
+			}
+			recordLimitInfo(r, info)
+			cfg.sampleHeaders(w, info)
+			ctx := context.WithValue(r.Context(), LimitInfoKey, info)
+			r = r.WithContext(WithChargeOnce(ctx, &ChargeOnce{tb: tb, charged: true}))
+			if cfg.refundOnPanic {
+				defer func() {
+					if p := recover(); p != nil {
+						cfg.refund(tb)
+						panic(p)
+					}
+				}()
+			}
+
+			if cfg.tokensPerMs > 0 {
+				start := tb.clock.Now()
+				defer func() {
+					elapsed := tb.clock.Now().Sub(start)
+					tb.debit(int64(cfg.tokensPerMs * float64(elapsed) / float64(time.Millisecond)))
+				}()
+			}
+
+			if cfg.chargeIf == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			if !cfg.chargeIf(sw.status) {
+				cfg.refund(tb)
+			}
+		})
+	}
+}
+
+// deny rejects a request that tb had no token for with status. Only the
+// status line and body are guaranteed: the log line and Retry-After are
+// subject to WithDenyWorkLimit and WithDenyWorkBucket.
+func (c *middlewareConfig) deny(w http.ResponseWriter, r *http.Request, tb *TokenBucket, info LimitInfo, status int) {
+	if c.backoff != nil {
+		info.RetryAfter = c.backoff.denied(r, info.RetryAfter)
+	}
+	recordLimitInfo(r, info)
+	if c.denyWork == nil || c.denyWork.Allow() {
+		log.Printf("Request DENIED for %s from %s\n", r.URL.Path, r.RemoteAddr)
+		if info.RetryAfter != InfDuration {
+			w.Header().Set("Retry-After", c.retryAfter(tb.clock.Now(), info.RetryAfter))
+		}
+		if c.headerSample > 0 {
+			c.setHeaders(w, info)
+		}
+	}
+	w.WriteHeader(status)
+	if status == http.StatusServiceUnavailable {
+		fmt.Fprintln(w, "Service Unavailable.")
+	} else {
+		fmt.Fprintln(w, "Too Many Requests.")
+	}
+}
+
+// sampleHeaders sends the rate limit headers on an allowed response, if it
+// falls in the WithHeaderSampleRate sample.
+func (c *middlewareConfig) sampleHeaders(w http.ResponseWriter, info LimitInfo) {
+	if c.headerSample <= 0 {
+		return
+	}
+	if c.headerSample < 1 {
+		var x float64
+		if c.headerRand != nil {
+			c.headerMu.Lock()
+			x = c.headerRand.Float64()
+			c.headerMu.Unlock()
+		} else {
+			x = rand.Float64()
+		}
+		if x >= c.headerSample {
+			return
+		}
+	}
+	c.setHeaders(w, info)
+}
+
+func (c *middlewareConfig) setHeaders(w http.ResponseWriter, info LimitInfo) {
+	w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(info.Limit, 10))
+	w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(max(info.Remaining, 0), 10))
+}
+
+// refund gives back the tokens an admitted request took.
+func (c *middlewareConfig) refund(tb *TokenBucket) {
+	tb.Refund(1)
+	if c.global != nil {
+		c.global.Refund(1)
+	}
+}
+
+func (c *middlewareConfig) retryAfter(now time.Time, d time.Duration) string {
+	secs := retryAfterSeconds(d)
+	if c.retryAfterFormat == DateFormat {
+		return now.Add(time.Duration(secs) * time.Second).UTC().Format(http.TimeFormat)
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
+// retryAfterSeconds rounds d up to whole seconds, never below one so a
+// client honouring it cannot spin.
+func retryAfterSeconds(d time.Duration) int64 {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
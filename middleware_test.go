@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statusHandler answers every request with the next status in turn.
+func statusHandler(statuses ...int) http.Handler {
+	i := 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[i%len(statuses)])
+		i++
+	})
+}
+
+func serve(h http.Handler) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec
+}
+
+func TestChargeIfRefundsUnchargedStatuses(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour)
+	defer tb.Stop()
+	h := Middleware(tb, WithChargeIf(func(status int) bool { return status < 400 }))(
+		statusHandler(http.StatusOK, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusOK))
+
+	for i, want := range []int64{2, 2, 2, 1} {
+		serve(h)
+		if got := tb.AvailableTokens(); got != want {
+			t.Fatalf("after request %d: %d tokens, want %d", i, got, want)
+		}
+	}
+}
+
+func TestChargeIfStillDeniesUpFront(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour)
+	defer tb.Stop()
+	ran := 0
+	h := Middleware(tb, WithChargeIf(func(status int) bool { return status < 400 }))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ran++
+		}))
+
+	serve(h)
+	if rec := serve(h); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", rec.Code)
+	}
+	if ran != 1 {
+		t.Fatalf("handler ran %d times, want 1", ran)
+	}
+}
+
+func TestRetryAfterFollowsBucketClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	tb := NewTokenBucket(1, 1, 10*time.Second, WithClock(clock))
+	defer tb.Stop()
+	h := Middleware(tb)(statusHandler(http.StatusOK))
+
+	if rec := serve(h); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", rec.Code)
+	}
+	for _, step := range []struct {
+		advance time.Duration
+		want    string
+	}{
+		{0, "10"},
+		{3 * time.Second, "7"},
+		{4 * time.Second, "3"},
+		{2*time.Second + 500*time.Millisecond, "1"},
+	} {
+		clock.Advance(step.advance)
+		rec := serve(h)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status %d, want 429", rec.Code)
+		}
+		if got := rec.Header().Get("Retry-After"); got != step.want {
+			t.Fatalf("after %v more: Retry-After = %q, want %q", step.advance, got, step.want)
+		}
+	}
+}
+
+func TestRetryAfterFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []MiddlewareOption
+		want string
+	}{
+		{"default", nil, "7"},
+		{"seconds", []MiddlewareOption{WithRetryAfterFormat(SecondsFormat)}, "7"},
+		{"date", []MiddlewareOption{WithRetryAfterFormat(DateFormat)}, "Tue, 02 Jan 2024 03:04:15 GMT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+			tb := NewTokenBucket(1, 1, 10*time.Second, WithClock(clock))
+			defer tb.Stop()
+			h := Middleware(tb, tt.opts...)(statusHandler(http.StatusOK))
+
+			serve(h)
+			clock.Advance(3 * time.Second)
+			if got := serve(h).Header().Get("Retry-After"); got != tt.want {
+				t.Fatalf("Retry-After = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// captureLog redirects the standard logger into the returned buffer for the
+// rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+	return &buf
+}
+
+func TestDenyWorkBucketSamplesDenialLogging(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Hour, WithClock(clock))
+	defer tb.Stop()
+	work := NewTokenBucket(5, 5, time.Second, WithClock(clock), withoutRefillLog())
+	defer work.Stop()
+	h := Middleware(tb, WithDenyWorkBucket(work))(statusHandler(http.StatusOK))
+	serve(h)
+	logs := captureLog(t)
+
+	// A flood of 100 denials a second for four seconds.
+	withHeader := 0
+	for sec := 0; sec < 4; sec++ {
+		for i := 0; i < 100; i++ {
+			rec := serve(h)
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("status %d, want 429", rec.Code)
+			}
+			if rec.Header().Get("Retry-After") != "" {
+				withHeader++
+			}
+		}
+		clock.Advance(time.Second)
+	}
+
+	if got := strings.Count(logs.String(), "Request DENIED"); got != 20 {
+		t.Fatalf("%d denials logged, want 20 at 5 per second", got)
+	}
+	if withHeader != 20 {
+		t.Fatalf("%d denials got Retry-After, want 20", withHeader)
+	}
+}
+
+func TestDenyWorkLimitBucketDoesNotLogRefills(t *testing.T) {
+	var c middlewareConfig
+	WithDenyWorkLimit(5)(&c)
+	c.denyWork.Stop()
+	if !c.denyWork.quiet {
+		t.Fatal("WithDenyWorkLimit's bucket logs its refills")
+	}
+
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Second, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	logs := captureLog(t)
+
+	clock.Advance(5 * time.Second)
+	if logs.Len() != 0 {
+		t.Fatalf("internal bucket logged %q", logs.String())
+	}
+}
+
+func TestLimitRouteLimitsRoutesIndependently(t *testing.T) {
+	search := NewTokenBucket(1, 2, time.Hour)
+	defer search.Stop()
+	upload := NewTokenBucket(1, 1, time.Hour)
+	defer upload.Stop()
+
+	mux := http.NewServeMux()
+	ok := statusHandler(http.StatusOK)
+	LimitRoute(mux, "GET /search", search, ok)
+	LimitRoute(mux, "POST /upload/{id}", upload, ok)
+	mux.Handle("GET /health", ok)
+
+	request := func(method, path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+	steps := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/upload/1", http.StatusOK},
+		{http.MethodPost, "/upload/2", http.StatusTooManyRequests},
+		{http.MethodGet, "/search", http.StatusOK},
+		{http.MethodGet, "/search", http.StatusOK},
+		{http.MethodGet, "/search", http.StatusTooManyRequests},
+		{http.MethodGet, "/health", http.StatusOK},
+		{http.MethodGet, "/health", http.StatusOK},
+		{http.MethodGet, "/health", http.StatusOK},
+	}
+	for i, s := range steps {
+		if got := request(s.method, s.path); got != s.want {
+			t.Fatalf("request %d, %s %s: status %d, want %d", i, s.method, s.path, got, s.want)
+		}
+	}
+}
+
+func TestMiddlewareStoresLimitInfo(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 3, 10*time.Second, WithClock(clock))
+	defer tb.Stop()
+	var seen []LimitInfo
+	h := Middleware(tb)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := FromContext(r.Context())
+		if !ok {
+			t.Error("no LimitInfo in the request context")
+		}
+		seen = append(seen, info)
+	}))
+
+	serve(h)
+	serve(h)
+	clock.Advance(4 * time.Second)
+	serve(h)
+
+	want := []LimitInfo{
+		{Remaining: 2, Limit: 3, Allowed: true, Cost: 1},
+		{Remaining: 1, Limit: 3, Allowed: true, Cost: 1},
+		{Remaining: 0, Limit: 3, RetryAfter: 6 * time.Second, Allowed: true, Cost: 1},
+	}
+	if !slices.Equal(seen, want) {
+		t.Fatalf("LimitInfo seen by the handler = %+v, want %+v", seen, want)
+	}
+	if _, ok := FromContext(context.Background()); ok {
+		t.Fatal("FromContext found LimitInfo in a bare context")
+	}
+}
+
+func TestCaptureLimitInfoSeesAllowedAndDenied(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, 10*time.Second, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	captureLog(t)
+
+	var logged []LimitInfo
+	logging := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, get := CaptureLimitInfo(r.Context())
+			next.ServeHTTP(w, r.WithContext(ctx))
+			info, ok := get()
+			if !ok {
+				t.Error("the logging middleware saw no decision")
+			}
+			logged = append(logged, info)
+		})
+	}
+	h := logging(Middleware(tb)(statusHandler(http.StatusOK)))
+
+	serve(h)
+	serve(h)
+	want := []LimitInfo{
+		{Remaining: 0, Limit: 1, RetryAfter: 10 * time.Second, Allowed: true, Cost: 1},
+		{Remaining: 0, Limit: 1, RetryAfter: 10 * time.Second, Reason: DenyEmpty, Cost: 1},
+	}
+	if !slices.Equal(logged, want) {
+		t.Fatalf("decisions logged = %+v, want %+v", logged, want)
+	}
+
+	_, get := CaptureLimitInfo(context.Background())
+	if _, ok := get(); ok {
+		t.Fatal("a decision was captured for a request no limiter saw")
+	}
+}
+
+func TestGlobalBucketDeniesWith503(t *testing.T) {
+	captureLog(t)
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 1, Capacity: 2, Interval: time.Hour} })
+	defer m.StopAll()
+	global := NewTokenBucket(1, 3, time.Hour)
+	defer global.Stop()
+	h := m.Middleware(func(r *http.Request) string { return r.Header.Get("X-Client") },
+		WithGlobalBucket(global))(statusHandler(http.StatusOK))
+
+	request := func(client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Client", client)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, step := range []struct {
+		client string
+		want   int
+	}{
+		{"a", http.StatusOK},
+		{"a", http.StatusOK},
+		// a's own bucket is empty; the global one still has a token.
+		{"a", http.StatusTooManyRequests},
+		{"b", http.StatusOK},
+		// Now the global bucket is empty, though b has a token of its own.
+		{"b", http.StatusServiceUnavailable},
+		{"c", http.StatusServiceUnavailable},
+	} {
+		rec := request(step.client)
+		if rec.Code != step.want {
+			t.Fatalf("request %d from %s: status %d, want %d", i, step.client, rec.Code, step.want)
+		}
+		if rec.Code != http.StatusOK && rec.Header().Get("Retry-After") != "3600" {
+			t.Fatalf("request %d from %s: Retry-After %q, want 3600", i, step.client, rec.Header().Get("Retry-After"))
+		}
+	}
+
+	// The 429 didn't spend a global token, and the 503s refunded the
+	// clients' own tokens.
+	if got := global.AvailableTokens(); got != 0 {
+		t.Fatalf("global bucket: %d tokens, want 0", got)
+	}
+	for client, want := range map[string]int64{"a": 0, "b": 1, "c": 2} {
+		if got := m.GetOrCreate(client).AvailableTokens(); got != want {
+			t.Fatalf("bucket %s: %d tokens, want %d", client, got, want)
+		}
+	}
+}
+
+func TestRefundOnPanic(t *testing.T) {
+	for _, refund := range []bool{false, true} {
+		t.Run(fmt.Sprintf("refund=%v", refund), func(t *testing.T) {
+			tb := NewTokenBucket(1, 2, time.Hour)
+			defer tb.Stop()
+			var opts []MiddlewareOption
+			if refund {
+				opts = append(opts, WithRefundOnPanic(true))
+			}
+			h := Middleware(tb, opts...)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				panic("handler failed")
+			}))
+
+			func() {
+				defer func() {
+					if p := recover(); p != "handler failed" {
+						t.Fatalf("recovered %v, want the handler's panic", p)
+					}
+				}()
+				serve(h)
+			}()
+
+			want := int64(1)
+			if refund {
+				want = 2
+			}
+			if got := tb.AvailableTokens(); got != want {
+				t.Fatalf("after the panic: %d tokens, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestChargeByDurationDebitsSlowHandlersMore(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	handlerTaking := func(d time.Duration) http.Handler {
+		return http.HandlerFunc(func(http.ResponseWriter, *http.Request) { clock.Advance(d) })
+	}
+	charged := func(d time.Duration) int64 {
+		tb := NewTokenBucket(1, 100, time.Hour, WithClock(clock), withoutRefillLog())
+		defer tb.Stop()
+		if rec := serve(Middleware(tb, WithChargeByDuration(0.1))(handlerTaking(d))); rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+		return 100 - tb.AvailableTokens()
+	}
+
+	if fast, slow := charged(10*time.Millisecond), charged(500*time.Millisecond); fast != 2 || slow != 51 {
+		t.Fatalf("charged %d for 10ms and %d for 500ms, want 1+1 and 1+50", fast, slow)
+	}
+
+	// A handler costing more than the balance puts the bucket in debt,
+	// bounded by capacity, and later requests are denied.
+	tb := NewTokenBucket(1, 100, time.Hour, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	h := Middleware(tb, WithChargeByDuration(0.1))(handlerTaking(5 * time.Second))
+	serve(h)
+	if got := tb.AvailableTokens(); got != -100 {
+		t.Fatalf("balance = %d after a 5s handler, want the debt capped at -100", got)
+	}
+	if rec := serve(h); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request while in debt: status %d, want 429", rec.Code)
+	}
+}
+
+func TestHeaderSampleRateSamplesAllowedAndKeepsDenials(t *testing.T) {
+	tb := NewTokenBucket(1, 1000, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+	h := Middleware(tb, WithHeaderSampleRate(0.5), WithHeaderRand(rand.New(rand.NewPCG(1, 2))))(statusHandler(http.StatusOK))
+	captureLog(t)
+
+	var sampled int
+	for i := 0; i < 1000; i++ {
+		rec := serve(h)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, rec.Code)
+		}
+		if rec.Header().Get("X-RateLimit-Limit") != "" {
+			sampled++
+			if got := rec.Header().Get("X-RateLimit-Remaining"); got != fmt.Sprint(999-i) {
+				t.Fatalf("request %d: X-RateLimit-Remaining = %q, want %d", i, got, 999-i)
+			}
+		}
+	}
+	if sampled < 450 || sampled > 550 {
+		t.Fatalf("%d of 1000 allowed responses carried the headers, want about half", sampled)
+	}
+
+	for i := 0; i < 20; i++ {
+		rec := serve(h)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status %d, want 429", rec.Code)
+		}
+		if rec.Header().Get("X-RateLimit-Limit") != "1000" || rec.Header().Get("X-RateLimit-Remaining") != "0" {
+			t.Fatalf("denial %d headers = %v, want the limit and nothing remaining", i, rec.Header())
+		}
+	}
+
+	if rec := serve(Middleware(tb)(statusHandler(http.StatusOK))); rec.Header().Get("X-RateLimit-Limit") != "" {
+		t.Fatal("headers sent without WithHeaderSampleRate")
+	}
+}
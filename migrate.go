@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// MigrateInterval changes the bucket's interval, scaling the rate with it
+// so the average rate is unchanged: a bucket refilling 2 per second
+// migrated to a minute refills 120 per minute. The new rate is
+// rate·newInterval/interval, and must come out a whole number; if not, or
+// if it would overflow, nothing changes and an error is returned. Capacity
+// and balance are kept, and the part of a token earned so far carries
+// over as Reconfigure describes. A fixed quota (rate 0) only has its
+// interval changed.
+func (tb *TokenBucket) MigrateInterval(newInterval time.Duration) error {
+	if newInterval <= 0 {
+		return fmt.Errorf("interval must be positive, got %v", newInterval)
+	}
+	cfg := tb.Config()
+	hi, lo := bits.Mul64(uint64(max(cfg.Rate, 0)), uint64(newInterval))
+	if hi >= uint64(cfg.Interval) {
+		return fmt.Errorf("rate %d per %v overflows at an interval of %v", cfg.Rate, cfg.Interval, newInterval)
+	}
+	rate, rem := bits.Div64(hi, lo, uint64(cfg.Interval))
+	if rate > math.MaxInt64 {
+		return fmt.Errorf("rate %d per %v overflows at an interval of %v", cfg.Rate, cfg.Interval, newInterval)
+	}
+	if rem != 0 {
+		return fmt.Errorf("rate %d per %v is not a whole number of tokens per %v", cfg.Rate, cfg.Interval, newInterval)
+	}
+	cfg.Rate = int64(rate)
+	cfg.Interval = newInterval
+	return tb.Reconfigure(cfg)
+}
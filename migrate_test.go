@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrateIntervalKeepsTheRealizedRate(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(2, 1000, time.Second, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	tb.AllowN(1000)
+
+	// Drained every 100ms for two minutes, migrating to per-minute
+	// accounting part-way through a token; at 2 per second, t ms in
+	// 2t/1000 tokens should have come out throughout.
+	var total int64
+	for ms := 100; ms <= 120_000; ms += 100 {
+		clock.Advance(100 * time.Millisecond)
+		n := tb.AvailableTokens()
+		tb.AllowN(n)
+		total += n
+		if want := int64(2 * ms / 1000); total != want {
+			t.Fatalf("after %dms: %d tokens released, want %d", ms, total, want)
+		}
+		if ms == 1300 {
+			if err := tb.MigrateInterval(time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if got := tb.Config(); got.Rate != 120 || got.Interval != time.Minute || got.Capacity != 1000 {
+				t.Fatalf("config after migrating = %+v, want 120 per minute with capacity kept", got)
+			}
+		}
+	}
+}
+
+func TestMigrateIntervalRejectsFractionalRates(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Second, withoutRefillLog())
+	defer tb.Stop()
+
+	if err := tb.MigrateInterval(1500 * time.Millisecond); err == nil {
+		t.Fatal("migrating 1 per second to 1.5 per 1.5s succeeded")
+	}
+	if err := tb.MigrateInterval(0); err == nil {
+		t.Fatal("migrating to a zero interval succeeded")
+	}
+	if got := tb.Config(); got.Rate != 1 || got.Interval != time.Second {
+		t.Fatalf("config after failed migrations = %+v, want it unchanged", got)
+	}
+}
+
+func TestReconfigureIntervalOnlyCarriesPartialToken(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 10, 2*time.Second, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	tb.AllowN(10)
+
+	// Half a token earned when the interval doubles: the other half takes
+	// half of the new 4s interval.
+	clock.Advance(time.Second)
+	if err := tb.Reconfigure(Config{Rate: 1, Capacity: 10, Interval: 4 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(1999 * time.Millisecond)
+	if got := tb.AvailableTokens(); got != 0 {
+		t.Fatalf("%d tokens before the carried half token completes", got)
+	}
+	clock.Advance(time.Millisecond)
+	if got := tb.AvailableTokens(); got != 1 {
+		t.Fatalf("%d tokens 2s after the change, want the carried token", got)
+	}
+}
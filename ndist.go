@@ -0,0 +1,50 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"math/bits"
+)
+
+// WithNDistribution records how many tokens each decision asked for, to show
+// whether capacity is sized for the typical request or the expensive tail.
+// Amounts are counted in power-of-two buckets and read with NDistribution.
+// It is off by default.
+func WithNDistribution() Option {
+	return func(tb *TokenBucket) {
+		tb.nDist = make(map[int64]int64)
+	}
+}
+
+// NDistribution returns the number of decisions, allowed or denied, by the
+// amount they were charged: the key is the smallest power of two at least
+// that amount, so key 8 counts amounts 5 to 8, and key 0 counts zero-token
+// calls. Amounts are after any minimum charge. A wait counts once, when it
+// is served; one that gives up first is not counted. It returns nil unless
+// WithNDistribution was given.
+func (tb *TokenBucket) NDistribution() map[int64]int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return maps.Clone(tb.nDist)
+}
+
+// recordNLocked adds a decision for n tokens to the distribution.
+func (tb *TokenBucket) recordNLocked(n int64) {
+	if tb.nDist == nil || n < 0 {
+		return
+	}
+	tb.nDist[nBucket(n)]++
+}
+
+// nBucket is the smallest power of two at least n, or 0 for 0.
+func nBucket(n int64) int64 {
+	if n == 0 {
+		return 0
+	}
+	shift := bits.Len64(uint64(n - 1))
+	if shift >= 63 {
+		return math.MaxInt64
+	}
+	return 1 << shift
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNDistributionCountsRequestsByPowerOfTwo(t *testing.T) {
+	tb := NewTokenBucket(1, 100, time.Hour, WithNDistribution(), withoutRefillLog())
+	defer tb.Stop()
+
+	for _, n := range []int64{1, 1, 1, 2, 3, 4, 5, 8, 50, 0} {
+		tb.AllowN(n)
+	}
+	tb.Allow()
+	tb.AllowN(64) // denied, but counted all the same
+
+	want := map[int64]int64{0: 1, 1: 4, 2: 1, 4: 2, 8: 2, 64: 2}
+	if got := tb.NDistribution(); !maps.Equal(got, want) {
+		t.Fatalf("NDistribution = %v, want %v", got, want)
+	}
+}
+
+func TestNDistributionOffByDefault(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+
+	tb.AllowN(3)
+	if got := tb.NDistribution(); got != nil {
+		t.Fatalf("NDistribution = %v without WithNDistribution, want nil", got)
+	}
+}
+
+func TestNBucket(t *testing.T) {
+	for n, want := range map[int64]int64{0: 0, 1: 1, 2: 2, 3: 4, 1024: 1024, 1025: 2048, math.MaxInt64: math.MaxInt64} {
+		if got := nBucket(n); got != want {
+			t.Errorf("nBucket(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+// WithNoBurst makes the bucket a pure rate limiter with no burst credit: it
+// starts empty, and its capacity is cut to one interval's worth of tokens
+// (rate) so it cannot accumulate more than that while idle. With rate 1 the
+// first request succeeds exactly one interval after construction; with a
+// higher rate tokens arrive every interval/rate and at most rate can be
+// taken at once. The capacity cap is applied at construction only; a later
+// Reconfigure sets it as given.
+//
+// A fixed quota (rate 0) with no burst would deny every request forever, so
+// WithNoBurst panics if the rate is not positive.
+func WithNoBurst() Option {
+	return func(tb *TokenBucket) {
+		if tb.rate <= 0 {
+			panic("WithNoBurst: rate must be positive")
+		}
+		tb.capacity = max(min(tb.capacity, tb.rate), 1)
+		tb.tokens = 0
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNoBurstFirstAllowAfterOneInterval(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 10, time.Second, WithClock(clock), WithNoBurst())
+	defer tb.Stop()
+
+	if tb.Allow() {
+		t.Fatal("allowed straight after construction")
+	}
+	clock.Advance(time.Second - time.Nanosecond)
+	if tb.Allow() {
+		t.Fatal("allowed before a full interval")
+	}
+	clock.Advance(time.Nanosecond)
+	if !tb.Allow() {
+		t.Fatal("denied after one interval")
+	}
+	if tb.Allow() {
+		t.Fatal("allowed twice in one interval")
+	}
+
+	// Idling doesn't build up a burst.
+	clock.Advance(time.Minute)
+	if got := tb.AvailableTokens(); got != 1 {
+		t.Fatalf("after idling: %d tokens, want 1", got)
+	}
+}
+
+func TestNoBurstCapsCapacityAtRate(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(4, 100, time.Second, WithClock(clock), WithNoBurst())
+	defer tb.Stop()
+
+	clock.Advance(time.Hour)
+	if got := tb.Config().Capacity; got != 4 {
+		t.Fatalf("capacity %d, want 4", got)
+	}
+	if !tb.AllowN(4) || tb.Allow() {
+		t.Fatal("want exactly one interval's worth available after idling")
+	}
+}
+
+func TestNoBurstRejectsFixedQuota(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic for a rate of zero")
+		}
+	}()
+	tb := NewTokenBucket(0, 10, time.Second, WithNoBurst())
+	tb.Stop()
+}
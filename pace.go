@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+)
+
+// Pace forwards items from in to the returned channel no faster than tb
+// allows, waiting for one token per item. The output is closed once in is
+// closed and drained, or as soon as ctx is done or tb is stopped; either way
+// the pacing goroutine exits, even if nobody is reading the output.
+func Pace[T any](ctx context.Context, tb *TokenBucket, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+
+		for {
+			var item T
+			var ok bool
+			select {
+			case item, ok = <-in:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+
+			if err := tb.WaitNContext(ctx, 1); err != nil {
+				return
+			}
+
+			select {
+			case out <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
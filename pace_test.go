@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestPaceForwardsAtBucketRate(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(2, 2, time.Second, WithClock(clock))
+	defer tb.Stop()
+	tb.AllowN(2)
+
+	in := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		in <- i
+	}
+	close(in)
+	out := Pace(context.Background(), tb, in)
+
+	// Two items a second: ten take five seconds.
+	next := 0
+	for sec := 1; sec <= 5; sec++ {
+		waitForQueue(t, tb, 1)
+		clock.Advance(time.Second)
+		for i := 0; i < 2; i++ {
+			if got := <-out; got != next {
+				t.Fatalf("item %d forwarded as %d", next, got)
+			}
+			next++
+		}
+		if sec < 5 {
+			waitForQueue(t, tb, 1)
+			select {
+			case v := <-out:
+				t.Fatalf("item %d forwarded before the next refill", v)
+			default:
+			}
+		}
+	}
+	if _, ok := <-out; ok {
+		t.Fatal("output still open after the input was drained")
+	}
+}
+
+func TestPaceStopsOnCancel(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour)
+	defer tb.Stop()
+	tb.Allow()
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int, 1)
+	in <- 1
+	out := Pace(ctx, tb, in)
+	waitForQueue(t, tb, 1)
+	cancel()
+
+	if _, ok := <-out; ok {
+		t.Fatal("item forwarded without a token")
+	}
+	waitFor(t, func() bool { return runtime.NumGoroutine() <= before })
+}
+
+func TestPaceStopsOnCancelWithNoReader(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour)
+	defer tb.Stop()
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int, 1)
+	in <- 1
+	Pace(ctx, tb, in)
+	// The item has its token and is waiting to be read.
+	waitFor(t, func() bool { return tb.AvailableTokens() == 0 })
+	cancel()
+
+	waitFor(t, func() bool { return runtime.NumGoroutine() <= before })
+}
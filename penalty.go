@@ -0,0 +1,37 @@
+package main
+
+// WithDenyPenalty charges every denied Allow call n tokens anyway, so a
+// client that keeps retrying while throttled pushes its own recovery further
+// away. The penalty can take the balance below zero, as can the settlement
+// of WithChargeByDuration, but never below -capacity, which bounds how long
+// a client can lock itself out for.
+func WithDenyPenalty(n int64) Option {
+	return func(tb *TokenBucket) {
+		tb.denyPenalty = n
+	}
+}
+
+// debit takes n tokens whatever the balance, down to -capacity at most,
+// for a cost settled after the fact. Nothing is taken while limiting is
+// bypassed.
+func (tb *TokenBucket) debit(n int64) {
+	if n <= 0 {
+		return
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	if tb.bypassLocked() {
+		return
+	}
+	taken := min(n, tb.tokens+tb.capacity)
+	if taken <= 0 {
+		return
+	}
+	tb.tokens -= taken
+	tb.consumed += taken
+	tb.lowWater = min(tb.lowWater, tb.tokens)
+	tb.publishLocked()
+	tb.curveEmptiedLocked()
+}
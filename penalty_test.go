@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDenyPenaltyLengthensCooldown(t *testing.T) {
+	// recovery hammers an empty bucket with five denied calls, then counts
+	// the seconds until a token is available again.
+	recovery := func(opts ...Option) int {
+		clock := NewManualClock(time.Unix(0, 0))
+		tb := NewTokenBucket(1, 5, time.Second, append(opts, WithClock(clock))...)
+		defer tb.Stop()
+		tb.AllowN(5)
+		for i := 0; i < 5; i++ {
+			tb.Allow()
+		}
+
+		secs := 0
+		for tb.AvailableTokens() < 1 {
+			clock.Advance(time.Second)
+			secs++
+		}
+		return secs
+	}
+
+	if got := recovery(); got != 1 {
+		t.Fatalf("without a penalty: recovered after %ds, want 1s", got)
+	}
+	if got := recovery(WithDenyPenalty(1)); got != 6 {
+		t.Fatalf("with a penalty of 1: recovered after %ds, want 6s", got)
+	}
+	// The debt is capped at -capacity.
+	if got := recovery(WithDenyPenalty(3)); got != 6 {
+		t.Fatalf("with a penalty of 3: recovered after %ds, want 6s", got)
+	}
+}
+
+func TestDenyPenaltyDebt(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewManualClock(start)
+	tb := NewTokenBucket(1, 5, time.Second, WithClock(clock), WithDenyPenalty(2))
+	defer tb.Stop()
+	tb.AllowN(5)
+	tb.Allow()
+
+	if remaining, full := tb.Probe(); remaining != -2 || full {
+		t.Fatalf("Probe() = %d, %v, want -2, false", remaining, full)
+	}
+	var cooldown *CooldownError
+	if err := tb.Take(1); !errors.As(err, &cooldown) || !cooldown.Until.Equal(start.Add(3*time.Second)) {
+		t.Fatalf("Take(1) = %v, want a CooldownError until 3s in", err)
+	}
+	for i := 0; i < 10; i++ {
+		tb.Allow()
+	}
+	if remaining, _ := tb.Probe(); remaining != -5 {
+		t.Fatalf("Probe() = %d after many denials, want -5 (-capacity)", remaining)
+	}
+	if !tb.AllowN(0) {
+		t.Fatal("AllowN(0) denied while in debt")
+	}
+}
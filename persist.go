@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Store holds a manager's saved state between runs. Load returns an empty
+// state, not an error, when nothing has been saved yet.
+type Store interface {
+	Load() (ManagerState, error)
+	Save(ManagerState) error
+}
+
+// FileStore is a Store kept as JSON in a single file. Each Save replaces
+// the file whole, so a crash mid-save leaves the previous state intact.
+type FileStore struct {
+	Path string
+}
+
+func (s FileStore) Load() (ManagerState, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ManagerState{}, nil
+	}
+	if err != nil {
+		return ManagerState{}, err
+	}
+	var state ManagerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return ManagerState{}, err
+	}
+	return state, nil
+}
+
+func (s FileStore) Save(state ManagerState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// PersistentManager is a LimiterManager whose per-key balances survive a
+// restart. It loads whatever store holds when it is built, and writes the
+// manager's state back every period as well as on Flush and StopAll. Idle
+// eviction and the bucket cap are the manager's own, set with the usual
+// ManagerOptions.
+//
+// Persistence is best-effort. A restart restores each key as of the last
+// successful save, so requests allowed since then are forgotten and a key
+// may get that part of its burst back; a key evicted before the save starts
+// afresh. A failed periodic save is logged and retried at the next period.
+// Two processes running on one store overwrite each other's state rather
+// than sharing a budget.
+type PersistentManager struct {
+	*LimiterManager
+	store Store
+
+	ticker   ticker
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPersistentManager builds a manager seeded from store and saving back
+// to it every period. It fails if the stored state can't be loaded, rather
+// than starting empty and overwriting it at the first save.
+func NewPersistentManager(config ConfigFunc, store Store, period time.Duration, opts ...ManagerOption) (*PersistentManager, error) {
+	if period <= 0 {
+		return nil, errors.New("persist period must be positive")
+	}
+	state, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PersistentManager{
+		LimiterManager: NewLimiterManager(config, opts...),
+		store:          store,
+		stop:           make(chan struct{}),
+	}
+	p.Import(state)
+	p.ticker = newTicker(p.clock, period, p.persist)
+	go p.persistLoop()
+
+	return p, nil
+}
+
+// Flush saves the manager's current state now.
+func (p *PersistentManager) Flush() error {
+	return p.store.Save(p.Export())
+}
+
+func (p *PersistentManager) persist() {
+	if err := p.Flush(); err != nil {
+		log.Printf("Saving limiter state failed: %v\n", err)
+	}
+}
+
+func (p *PersistentManager) persistLoop() {
+	defer p.ticker.Stop()
+
+	for {
+		select {
+		case <-p.ticker.C():
+			p.persist()
+
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// StopAll stops the periodic saves, saves a final time and then stops every
+// bucket. A failed final save is logged.
+func (p *PersistentManager) StopAll() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		p.persist()
+	})
+	p.LimiterManager.StopAll()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is an in-memory Store that counts its saves.
+type memStore struct {
+	mu      sync.Mutex
+	state   ManagerState
+	saves   int
+	loadErr error
+	saveErr error
+}
+
+func (s *memStore) Load() (ManagerState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.loadErr
+}
+
+func (s *memStore) Save(state ManagerState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saveErr != nil {
+		return s.saveErr
+	}
+	s.state = state
+	s.saves++
+	return nil
+}
+
+func (s *memStore) saved() (ManagerState, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.saves
+}
+
+func TestPersistentManagerLifecycle(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	store := &memStore{}
+	config := func(string) Config { return Config{Rate: 1, Capacity: 10, Interval: time.Hour} }
+	opts := []ManagerOption{WithManagerClock(clock), WithIdleTTL(10 * time.Minute)}
+
+	p, err := NewPersistentManager(config, store, time.Minute, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.GetOrCreate("a").AllowN(4)
+	p.GetOrCreate("b").AllowN(7)
+
+	clock.Advance(time.Minute)
+	state, saves := store.saved()
+	if saves != 1 || state.Buckets["a"].Tokens != 6 || state.Buckets["b"].Tokens != 3 {
+		t.Fatalf("after one period: %d saves of %+v, want one with a=6, b=3", saves, state.Buckets)
+	}
+
+	// b goes idle and is evicted; a is kept alive.
+	for i := 0; i < 4; i++ {
+		clock.Advance(5 * time.Minute)
+		p.GetOrCreate("a")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := store.saved(); len(state.Buckets) != 1 || state.Buckets["a"].Tokens != 6 {
+		t.Fatalf("after b was evicted: saved %+v, want a=6 only", state.Buckets)
+	}
+
+	p.GetOrCreate("a").AllowN(1)
+	_, before := store.saved()
+	p.StopAll()
+	if state, saves := store.saved(); saves != before+1 || state.Buckets["a"].Tokens != 5 {
+		t.Fatalf("StopAll: %d saves, a=%d; want a final save with a=5", saves-before, state.Buckets["a"].Tokens)
+	}
+
+	// A restart picks up where the last save left off.
+	p, err = NewPersistentManager(config, store, time.Minute, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.StopAll()
+	if got := p.GetOrCreate("a").AvailableTokens(); got != 5 {
+		t.Fatalf("restored a: %d tokens, want 5", got)
+	}
+	if got := p.GetOrCreate("b").AvailableTokens(); got != 10 {
+		t.Fatalf("evicted b: %d tokens, want a fresh 10", got)
+	}
+}
+
+func TestNewPersistentManagerFailsOnUnreadableStore(t *testing.T) {
+	store := &memStore{loadErr: errors.New("disk on fire")}
+	config := func(string) Config { return Config{Rate: 1, Capacity: 10, Interval: time.Hour} }
+	if p, err := NewPersistentManager(config, store, time.Minute); err == nil {
+		p.StopAll()
+		t.Fatal("built a manager over a store that can't be loaded")
+	}
+	if _, saves := store.saved(); saves != 0 {
+		t.Fatal("the unreadable state was overwritten")
+	}
+}
+
+func TestPersistentManagerLogsFailedSaves(t *testing.T) {
+	logs := captureLog(t)
+	clock := NewManualClock(time.Unix(0, 0))
+	store := &memStore{saveErr: errors.New("read-only")}
+	config := func(string) Config { return Config{Rate: 1, Capacity: 10, Interval: time.Hour} }
+	p, err := NewPersistentManager(config, store, time.Minute, WithManagerClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.StopAll()
+
+	clock.Advance(time.Minute)
+	if !strings.Contains(logs.String(), "Saving limiter state failed: read-only") {
+		t.Fatalf("failed save not logged: %q", logs.String())
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store := FileStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	if state, err := store.Load(); err != nil || len(state.Buckets) != 0 {
+		t.Fatalf("before any save: %+v, %v; want an empty state", state, err)
+	}
+
+	want := ManagerState{
+		SavedAt: time.Unix(1_700_000_000, 0).UTC(),
+		Buckets: map[string]BucketState{"a": {Config: Config{Rate: 1, Capacity: 10, Interval: time.Hour}, Tokens: 3, LastRefill: time.Unix(1_699_999_000, 0).UTC()}},
+	}
+	if err := store.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.SavedAt.Equal(want.SavedAt) || !reflect.DeepEqual(got.Buckets, want.Buckets) {
+		t.Fatalf("loaded %+v, want %+v", got, want)
+	}
+}
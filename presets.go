@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Preset defaults: a client's bucket is dropped after presetIdleTTL without
+// requests, and at most presetMaxBuckets are held, least recently seen
+// evicted first, so a flood of distinct clients can't exhaust memory.
+const (
+	presetIdleTTL    = 10 * time.Minute
+	presetMaxBuckets = 100_000
+)
+
+// PerIP is ready-made middleware giving each client IP its own bucket of
+// burst tokens refilled at rps a second. Buckets live in a LimiterManager
+// that evicts a client's bucket 10 minutes after its last request and
+// holds at most 100,000, evicting the least recently seen first; an
+// evicted client starts again with a full bucket. Denials get 429 with
+// Retry-After. The manager lives as long as the process.
+func PerIP(rps, burst int64) func(http.Handler) http.Handler {
+	return presetManager(rps, burst).Middleware(FromIP)
+}
+
+// PerAPIKey is PerIP keyed by the value of the named header, such as
+// "X-API-Key", with the same buckets and eviction. Requests without the
+// header are limited by client IP instead, in buckets of their own that
+// no header value can collide with.
+func PerAPIKey(header string, rps, burst int64) func(http.Handler) http.Handler {
+	return presetManager(rps, burst).Middleware(FirstKey(FromHeader(header)))
+}
+
+// Global is ready-made middleware sharing one bucket of burst tokens,
+// refilled at rps a second, between every request it wraps. Denials get
+// 429 with Retry-After. The bucket lives as long as the process.
+func Global(rps, burst int64) func(http.Handler) http.Handler {
+	return Middleware(NewTokenBucket(rps, burst, time.Second, WithName("global"), withoutRefillLog()))
+}
+
+func presetManager(rps, burst int64) *LimiterManager {
+	cfg := Config{Rate: rps, Capacity: burst, Interval: time.Second}
+	return NewLimiterManager(func(string) Config { return cfg },
+		WithIdleTTL(presetIdleTTL), WithMaxBuckets(presetMaxBuckets))
+}
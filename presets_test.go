@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func presetRequest(h http.Handler, ip, apiKey string) int {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = ip + ":1234"
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestPerIPIsolatesClients(t *testing.T) {
+	h := PerIP(1, 2)(statusHandler(http.StatusOK))
+	for i, want := range []int{200, 200, 429} {
+		if got := presetRequest(h, "192.0.2.1", ""); got != want {
+			t.Fatalf("first IP, request %d: status %d, want %d", i+1, got, want)
+		}
+	}
+	if got := presetRequest(h, "192.0.2.2", ""); got != http.StatusOK {
+		t.Fatalf("second IP: status %d, want 200 from its own bucket", got)
+	}
+}
+
+func TestPerAPIKeyIsolatesKeysAndFallsBackToIP(t *testing.T) {
+	h := PerAPIKey("X-API-Key", 1, 1)(statusHandler(http.StatusOK))
+	if got := presetRequest(h, "192.0.2.1", "alpha"); got != http.StatusOK {
+		t.Fatalf("key alpha: status %d", got)
+	}
+	if got := presetRequest(h, "192.0.2.2", "alpha"); got != http.StatusTooManyRequests {
+		t.Fatalf("key alpha from another IP: status %d, want 429 from the same bucket", got)
+	}
+	if got := presetRequest(h, "192.0.2.1", "beta"); got != http.StatusOK {
+		t.Fatalf("key beta: status %d, want 200 from its own bucket", got)
+	}
+	if got := presetRequest(h, "192.0.2.1", ""); got != http.StatusOK {
+		t.Fatalf("no key: status %d, want 200 from the IP's bucket", got)
+	}
+	if got := presetRequest(h, "192.0.2.1", ""); got != http.StatusTooManyRequests {
+		t.Fatalf("no key again: status %d, want 429", got)
+	}
+}
+
+func TestGlobalSharesOneBucket(t *testing.T) {
+	h := Global(1, 2)(statusHandler(http.StatusOK))
+	for i, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		want := http.StatusOK
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		if got := presetRequest(h, ip, ""); got != want {
+			t.Fatalf("request %d from %s: status %d, want %d", i+1, ip, got, want)
+		}
+	}
+}
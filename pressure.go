@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// PressureProbe serves a health check for load balancers that shed traffic
+// from unhealthy instances: it answers 503 while the bucket has been empty
+// continuously for at least emptyFor, and 200 otherwise. A single refill
+// that is taken straight away still counts as a break in the emptiness, so
+// only a bucket that demand has kept at zero for the whole stretch fails
+// the probe. The probe consumes nothing.
+func (tb *TokenBucket) PressureProbe(emptyFor time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tb.emptyFor() >= emptyFor {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "Service Unavailable.")
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+}
+
+// emptyFor is how long the bucket has been continuously empty, or -1 if it
+// holds a token now.
+func (tb *TokenBucket) emptyFor() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	if tb.emptySince.IsZero() {
+		return -1
+	}
+	return tb.clock.Now().Sub(tb.emptySince)
+}
+
+// trackEmptyLocked notes when the bucket ran empty, telling any herd
+// detector watching it, and forgets it once the bucket holds a token again.
+func (tb *TokenBucket) trackEmptyLocked() {
+	switch {
+	case tb.tokens >= 1:
+		tb.emptySince = time.Time{}
+	case tb.emptySince.IsZero():
+		tb.emptySince = tb.clock.Now()
+		if tb.onEmpty != nil {
+			tb.onEmpty()
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPressureProbe(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 2, 10*time.Second, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	probe := tb.PressureProbe(5 * time.Second)
+	status := func() int { return serve(probe).Code }
+
+	if got := status(); got != http.StatusOK {
+		t.Fatalf("full bucket: status %d, want 200", got)
+	}
+	tb.AllowN(2)
+	clock.Advance(4 * time.Second)
+	if got := status(); got != http.StatusOK {
+		t.Fatalf("empty for 4s of 5s: status %d, want 200", got)
+	}
+	clock.Advance(2 * time.Second)
+	if got := status(); got != http.StatusServiceUnavailable {
+		t.Fatalf("empty for 6s of 5s: status %d, want 503", got)
+	}
+	if got := tb.AvailableTokens(); got != 0 {
+		t.Fatalf("the probe consumed tokens: %d left", got)
+	}
+
+	clock.Advance(4 * time.Second)
+	if got := status(); got != http.StatusOK {
+		t.Fatalf("after a refill: status %d, want 200", got)
+	}
+
+	// Taking the refilled token at once starts a new stretch of
+	// emptiness rather than continuing the old one.
+	tb.Allow()
+	if got := status(); got != http.StatusOK {
+		t.Fatalf("empty again just now: status %d, want 200", got)
+	}
+	clock.Advance(5 * time.Second)
+	if got := status(); got != http.StatusServiceUnavailable {
+		t.Fatalf("empty again for 5s: status %d, want 503", got)
+	}
+}
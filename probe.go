@@ -0,0 +1,20 @@
+package main
+
+// Probe reports the balance without taking the bucket's lock, for cheap
+// speculative checks such as "is this key close to its limit?". It reads a
+// copy published after every change to the balance, so the answer is
+// advisory: it may trail a concurrent Allow or refill by a moment, but is
+// always a balance the bucket really had, within [-capacity, capacity]; it
+// is only negative while WithDenyPenalty has the bucket in debt. Allow and
+// AllowN remain the only authoritative check.
+func (tb *TokenBucket) Probe() (remaining int64, full bool) {
+	remaining = tb.probeTokens.Load()
+	return remaining, remaining >= tb.probeCapacity.Load()
+}
+
+func (tb *TokenBucket) publishLocked() {
+	tb.probeTokens.Store(tb.tokens)
+	tb.probeCapacity.Store(tb.capacity)
+	tb.trackEmptyLocked()
+	tb.publishSnapshotLocked()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProbeStaysWithinCapacity(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(5, 10, time.Millisecond, WithClock(clock))
+	defer tb.Stop()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					tb.AllowN(3)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			clock.Advance(time.Millisecond)
+		}
+		close(stop)
+	}()
+
+	for {
+		select {
+		case <-stop:
+			wg.Wait()
+			return
+		default:
+		}
+		remaining, full := tb.Probe()
+		if remaining < 0 || remaining > 10 {
+			t.Fatalf("Probe() = %d, outside [0, 10]", remaining)
+		}
+		if full != (remaining == 10) {
+			t.Fatalf("Probe() = %d, %v", remaining, full)
+		}
+	}
+}
+
+func BenchmarkProbe(b *testing.B) {
+	tb := NewTokenBucket(1000, 1000, time.Second)
+	defer tb.Stop()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tb.Probe()
+		}
+	})
+}
+
+func BenchmarkAvailableTokens(b *testing.B) {
+	tb := NewTokenBucket(1000, 1000, time.Second)
+	defer tb.Stop()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tb.AvailableTokens()
+		}
+	})
+}
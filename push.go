@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// pushClient bounds a push so a job exiting behind an unreachable gateway
+// is held up for seconds, not indefinitely.
+var pushClient = &http.Client{Timeout: 10 * time.Second}
+
+// PushStats sends tb's metrics, in the same form MetricsHandler serves, to
+// the Prometheus Pushgateway at gatewayURL under jobName, replacing what was
+// last pushed for the job. It is meant for batch jobs that exit before they
+// could be scraped. A network failure or a response other than 2xx is
+// returned as an error.
+func PushStats(gatewayURL, jobName string, tb *TokenBucket) error {
+	var body bytes.Buffer
+	WriteMetrics(&body, tb)
+
+	target := strings.TrimSuffix(gatewayURL, "/") + "/metrics/job/" + url.PathEscape(jobName)
+	req, err := http.NewRequest(http.MethodPut, target, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushing stats for job %q: %s", jobName, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushStats(t *testing.T) {
+	var method, path, body string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.EscapedPath(), string(b)
+	}))
+	defer gateway.Close()
+
+	tb := NewTokenBucket(1, 2, time.Hour, WithName("batch"), withoutRefillLog())
+	defer tb.Stop()
+	tb.AllowN(2)
+	tb.Allow()
+
+	if err := PushStats(gateway.URL+"/", "nightly import", tb); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/nightly%20import" {
+		t.Fatalf("pushed with %s %s, want PUT /metrics/job/nightly%%20import", method, path)
+	}
+	for _, line := range []string{
+		`tokenbucket_allowed_total{bucket="batch"} 1`,
+		`tokenbucket_denied_total{bucket="batch",reason="empty"} 1`,
+		`tokenbucket_tokens{bucket="batch"} 0`,
+		`tokenbucket_capacity{bucket="batch"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("pushed payload lacks %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestPushStatsReturnsFailures(t *testing.T) {
+	tb := NewTokenBucket(1, 2, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad", http.StatusBadRequest)
+	}))
+	if err := PushStats(gateway.URL, "job", tb); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("push rejected by the gateway: error %v, want the 400 status", err)
+	}
+	gateway.Close()
+	if err := PushStats(gateway.URL, "job", tb); err == nil {
+		t.Fatal("push to a closed gateway returned no error")
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"time"
+)
+
+// NewQuotaLimiter builds a fixed quota, such as 1000 requests a day: the
+// bucket holds quota tokens, does not refill, and is topped back up to
+// quota at every multiple of period counted from the zero Time, so a
+// period of 24*time.Hour resets at midnight UTC and time.Hour on the hour.
+// Boundaries are read from the bucket's clock, so WithClock moves them too.
+// For boundaries that are not a fixed period apart, such as the first of
+// each month, build the bucket with rate 0 and call ScheduleReset for each
+// one.
+func NewQuotaLimiter(quota int64, period time.Duration, opts ...Option) *TokenBucket {
+	tb := NewTokenBucket(0, quota, period, opts...)
+	tb.ScheduleResetEvery(tb.clock.Now().Truncate(period).Add(period), period)
+	return tb
+}
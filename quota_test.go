@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaLimiterResetsAtTheBoundaryOnly(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 22, 30, 0, 0, time.UTC))
+	tb := NewQuotaLimiter(3, 24*time.Hour, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+
+	if !tb.AllowN(3) || tb.Allow() {
+		t.Fatal("the quota of 3 was not exactly 3")
+	}
+	clock.Advance(time.Hour + 29*time.Minute)
+	if got := tb.AvailableTokens(); got != 0 {
+		t.Fatalf("at 23:59 tokens = %d, want no refill before midnight", got)
+	}
+	clock.Advance(time.Minute)
+	if got := tb.AvailableTokens(); got != 3 {
+		t.Fatalf("at midnight tokens = %d, want the full quota 3", got)
+	}
+
+	tb.AllowN(2)
+	clock.Advance(23*time.Hour + 59*time.Minute)
+	if got := tb.AvailableTokens(); got != 1 {
+		t.Fatalf("late on the second day tokens = %d, want 1", got)
+	}
+	clock.Advance(time.Minute)
+	if got := tb.AvailableTokens(); got != 3 {
+		t.Fatalf("at the next midnight tokens = %d, want 3", got)
+	}
+	if got := tb.TimeUntilFull(); got != 0 {
+		t.Fatalf("TimeUntilFull = %v on a full quota", got)
+	}
+	tb.Allow()
+	if got := tb.TimeUntilFull(); got != 24*time.Hour {
+		t.Fatalf("TimeUntilFull = %v, want the 24h until the next reset", got)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Recommend suggests a Config that would have rejected roughly
+// targetRejectRate (0 to 1) of the requests arriving at samples. Capacity is
+// sized to the busiest single interval so typical bursts are absorbed, then
+// the rate is searched for; only if even the lowest rate rejects too little
+// is the capacity cut back instead.
+//
+// It is a heuristic, not an optimum: each candidate is scored against a
+// continuous model of the bucket, and only one of the many configs reaching
+// the target is returned. Replay the result on fresh traffic before relying
+// on it.
+func Recommend(samples []time.Time, targetRejectRate float64) Config {
+	if len(samples) == 0 {
+		return Config{Rate: 1, Capacity: 1, Interval: time.Second}
+	}
+	times := append([]time.Time(nil), samples...)
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	interval := recommendInterval(times)
+	capacity := peakPerInterval(times, interval)
+	rate := searchInt64(1, capacity, func(rate int64) bool {
+		return simulateRejectRate(times, rate, capacity, interval) <= targetRejectRate
+	})
+	if rate == 1 {
+		capacity = searchInt64(1, capacity, func(capacity int64) bool {
+			return simulateRejectRate(times, 1, capacity, interval) <= targetRejectRate
+		})
+	}
+
+	return Config{Rate: rate, Capacity: capacity, Interval: interval}
+}
+
+// recommendInterval picks the shortest interval, from a second up to an
+// hour, over which the mean traffic is at least ten requests, so the rate
+// can be tuned in reasonably fine steps.
+func recommendInterval(times []time.Time) time.Duration {
+	span := times[len(times)-1].Sub(times[0])
+	interval := time.Second
+	for interval < time.Hour && span > 0 && float64(len(times))*float64(interval)/float64(span) < 10 {
+		interval *= 10
+	}
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	return interval
+}
+
+// peakPerInterval is the most requests that arrived within any window of
+// length interval.
+func peakPerInterval(times []time.Time, interval time.Duration) int64 {
+	var peak int64
+	start := 0
+	for end := range times {
+		for times[end].Sub(times[start]) >= interval {
+			start++
+		}
+		if n := int64(end - start + 1); n > peak {
+			peak = n
+		}
+	}
+	return peak
+}
+
+// searchInt64 returns the smallest value in [lo, hi] for which ok holds,
+// assuming ok is monotonic, or hi if none does.
+func searchInt64(lo, hi int64, ok func(int64) bool) int64 {
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if ok(mid) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo
+}
+
+// simulateRejectRate replays times against a bucket that starts full and
+// earns rate tokens per interval continuously.
+func simulateRejectRate(times []time.Time, rate, capacity int64, interval time.Duration) float64 {
+	tokens := float64(capacity)
+	rejected := 0
+	prev := times[0]
+	for _, t := range times {
+		tokens = math.Min(float64(capacity), tokens+float64(rate)*float64(t.Sub(prev))/float64(interval))
+		prev = t
+		if tokens >= 1 {
+			tokens--
+		} else {
+			rejected++
+		}
+	}
+	return float64(rejected) / float64(len(times))
+}
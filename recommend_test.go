@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// burstyArrivals is ten minutes of roughly five requests a second, with a
+// burst of 60 more inside one second every half minute.
+func burstyArrivals(start time.Time) []time.Time {
+	rng := rand.New(rand.NewSource(1))
+	var times []time.Time
+	for i := 0; i < 10*60*5; i++ {
+		times = append(times, start.Add(time.Duration(rng.Int63n(int64(10*time.Minute)))))
+	}
+	for burst := time.Duration(0); burst < 10*time.Minute; burst += 30 * time.Second {
+		for i := 0; i < 60; i++ {
+			times = append(times, start.Add(burst+time.Duration(rng.Int63n(int64(time.Second)))))
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times
+}
+
+// replayRejectRate runs times through a bucket built from cfg.
+func replayRejectRate(t *testing.T, cfg Config, times []time.Time) float64 {
+	t.Helper()
+	clock := NewManualClock(times[0])
+	tb, err := NewTokenBucketFromConfig(cfg, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tb.Stop()
+
+	rejected := 0
+	for _, at := range times {
+		clock.Advance(at.Sub(clock.Now()))
+		if !tb.Allow() {
+			rejected++
+		}
+	}
+	return float64(rejected) / float64(len(times))
+}
+
+func TestRecommendLandsNearTargetRejectRate(t *testing.T) {
+	times := burstyArrivals(time.Unix(0, 0))
+
+	for _, target := range []float64{0.01, 0.05, 0.2} {
+		cfg := Recommend(times, target)
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Recommend(%v) = %+v: %v", target, cfg, err)
+		}
+		got := replayRejectRate(t, cfg, times)
+		if math.Abs(got-target) > 0.01 {
+			t.Errorf("Recommend(%v) = %+v rejects %.3f when replayed", target, cfg, got)
+		}
+	}
+}
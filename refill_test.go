@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOnRefillCompleteSignalsEachRefill(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(3, 10, time.Second, WithClock(clock))
+	defer tb.Stop()
+	tb.AllowN(10)
+
+	refills := make(chan int64, 2)
+	tb.OnRefillComplete(func(tokens int64) { refills <- tokens })
+	clock.Advance(2 * time.Second)
+
+	got := []int64{<-refills, <-refills}
+	slices.Sort(got)
+	if !slices.Equal(got, []int64{3, 6}) {
+		t.Fatalf("refills reported %v, want 3 and 6", got)
+	}
+	if got := tb.AvailableTokens(); got != 6 {
+		t.Fatalf("after two refills: %d tokens, want 6", got)
+	}
+}
+
+func TestOnRefillCompleteWithRealTicker(t *testing.T) {
+	tb := NewTokenBucket(1, 10, 10*time.Millisecond)
+	defer tb.Stop()
+	tb.AllowN(10)
+
+	// Each call blocks until the test ends, which must not hold up the
+	// refills that follow.
+	refills := make(chan int64)
+	block := make(chan struct{})
+	defer close(block)
+	tb.OnRefillComplete(func(tokens int64) {
+		select {
+		case refills <- tokens:
+		case <-block:
+		}
+		<-block
+	})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-refills:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("refill %d never reported", i+1)
+		}
+	}
+
+	// Removing the callback is safe with refills still running.
+	tb.OnRefillComplete(nil)
+	tb.tick()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+var registry struct {
+	mu      sync.RWMutex
+	buckets map[string]*TokenBucket
+}
+
+// Register makes tb available process-wide under name, so subsystems can
+// share a limiter by name instead of passing it around, as sql.Register
+// does for drivers. It fails if name is already taken or tb is nil. The
+// registry only holds references: whoever created tb still owns it and
+// stops it, and should Unregister it first so no one looks up a stopped
+// bucket.
+func Register(name string, tb *TokenBucket) error {
+	if tb == nil {
+		return fmt.Errorf("register %q: nil bucket", name)
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, dup := registry.buckets[name]; dup {
+		return fmt.Errorf("register %q: a bucket is already registered under that name", name)
+	}
+	if registry.buckets == nil {
+		registry.buckets = make(map[string]*TokenBucket)
+	}
+	registry.buckets[name] = tb
+	return nil
+}
+
+// Unregister removes name from the registry, if it is there. The bucket is
+// not stopped.
+func Unregister(name string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	delete(registry.buckets, name)
+}
+
+// Lookup returns the bucket registered under name.
+func Lookup(name string) (*TokenBucket, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	tb, ok := registry.buckets[name]
+	return tb, ok
+}
+
+// MustLookup is Lookup for buckets the program cannot run without, such as
+// ones registered at start-up: it panics if name is not registered.
+func MustLookup(name string) *TokenBucket {
+	tb, ok := Lookup(name)
+	if !ok {
+		panic(fmt.Sprintf("no token bucket registered as %q", name))
+	}
+	return tb
+}
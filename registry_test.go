@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+	if err := Register("test-registry", tb); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	defer Unregister("test-registry")
+
+	if got, ok := Lookup("test-registry"); !ok || got != tb {
+		t.Fatalf("Lookup = %p, %v, want the registered bucket", got, ok)
+	}
+	if got := MustLookup("test-registry"); got != tb {
+		t.Fatal("MustLookup returned another bucket")
+	}
+
+	other := NewTokenBucket(1, 5, time.Hour, withoutRefillLog())
+	defer other.Stop()
+	err := Register("test-registry", other)
+	if err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Fatalf("duplicate Register = %v, want an already-registered error", err)
+	}
+	if got, _ := Lookup("test-registry"); got != tb {
+		t.Fatal("a failed Register replaced the bucket")
+	}
+	if err := Register("test-nil", nil); err == nil {
+		t.Fatal("Register accepted a nil bucket")
+	}
+
+	Unregister("test-registry")
+	if _, ok := Lookup("test-registry"); ok {
+		t.Fatal("Lookup found an unregistered name")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustLookup of an unknown name did not panic")
+		}
+	}()
+	MustLookup("test-registry")
+}
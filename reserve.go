@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Reservation is a claim on tokens that have not arrived yet, held in the
+// same queue as WaitN callers without blocking a goroutine, so that an
+// interactive client can see how long its turn is and whether to keep it.
+// The tokens are taken for it as soon as they reach the front of the queue.
+type Reservation struct {
+	tb        *TokenBucket
+	w         *waiter
+	cancelled bool
+}
+
+// ReserveN queues a reservation for n tokens behind every waiter already
+// queued at priority 0 or higher. It fails as WaitN would, without
+// reserving anything, if n is negative, more than capacity, or the bucket
+// is stopped or draining.
+func (tb *TokenBucket) ReserveN(n int64) (*Reservation, error) {
+	if n < 0 {
+		return nil, ErrNegativeTokens
+	}
+	w := newWaiter(n)
+	if tb.unset() {
+		w.resolveLocked(nil)
+		return &Reservation{w: w}, nil
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.draining {
+		return nil, ErrDraining
+	}
+	tb.accrueLocked()
+	w.n = tb.costLocked(n)
+	if !tb.bypassLocked() && w.n > tb.capacity {
+		return nil, &ExceedsCapacityError{N: w.n, Capacity: tb.capacity}
+	}
+	if tb.stopped {
+		return nil, ErrStopped
+	}
+	w.enqueued = tb.clock.Now()
+	tb.enqueueLocked(w)
+	if len(tb.waiters) > tb.waitStats.MaxWaiting {
+		tb.waitStats.MaxWaiting = len(tb.waiters)
+	}
+	tb.serveWaitersLocked()
+	return &Reservation{tb: tb, w: w}, nil
+}
+
+// Delay is how long until the reserved tokens are expected to be taken,
+// given what is queued ahead of the reservation now: zero once they have
+// been, and InfDuration if they never will be, because the reservation was
+// cancelled, the bucket was stopped under it, or it has no refill.
+func (r *Reservation) Delay() time.Duration {
+	if r.tb == nil {
+		return 0
+	}
+	r.tb.mu.Lock()
+	defer r.tb.mu.Unlock()
+
+	switch {
+	case r.cancelled || r.w.done && r.w.err != nil:
+		return InfDuration
+	case r.w.done:
+		return 0
+	}
+	r.tb.accrueLocked()
+	return r.tb.timeUntilTakeLocked(r.aheadLocked() + r.w.n)
+}
+
+// QueuePosition is how many tokens are queued ahead of the reservation,
+// reserved or waited for but not yet available, or zero once it has been
+// served or cancelled.
+func (r *Reservation) QueuePosition() int64 {
+	if r.tb == nil {
+		return 0
+	}
+	r.tb.mu.Lock()
+	defer r.tb.mu.Unlock()
+
+	if r.cancelled || r.w.done {
+		return 0
+	}
+	return r.aheadLocked()
+}
+
+func (r *Reservation) aheadLocked() int64 {
+	var ahead int64
+	for _, w := range r.tb.waiters {
+		if w == r.w {
+			break
+		}
+		ahead += w.n
+	}
+	return ahead
+}
+
+// Cancel gives up the reservation: still queued, it leaves the queue and
+// everything behind it moves up; already served, its tokens are refunded.
+// Cancelling more than once does nothing.
+func (r *Reservation) Cancel() {
+	if r.tb == nil {
+		return
+	}
+	r.tb.mu.Lock()
+	defer r.tb.mu.Unlock()
+
+	if r.cancelled {
+		return
+	}
+	r.cancelled = true
+	if !r.w.done {
+		r.tb.removeWaiterLocked(r.w)
+		return
+	}
+	if r.w.err == nil && r.w.charged > 0 {
+		r.tb.creditLocked(r.w.charged)
+	}
+}
+
+// wait blocks until the reservation is served or ctx is done, in which case
+// it leaves the queue.
+func (r *Reservation) wait(ctx context.Context) error {
+	if r.tb == nil {
+		return nil
+	}
+	return r.tb.await(ctx, r.w)
+}
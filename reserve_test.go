@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReservationPositionAndCancel(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 2, time.Second, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	tb.AllowN(2)
+
+	first, err := tb.ReserveN(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tb.ReserveN(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, pos := first.Delay(), first.QueuePosition(); d != time.Second || pos != 0 {
+		t.Fatalf("first: Delay %v, QueuePosition %d; want 1s, 0", d, pos)
+	}
+	if d, pos := second.Delay(), second.QueuePosition(); d != 3*time.Second || pos != 1 {
+		t.Fatalf("second: Delay %v, QueuePosition %d; want 3s, 1", d, pos)
+	}
+
+	first.Cancel()
+	first.Cancel()
+	if d := first.Delay(); d != InfDuration {
+		t.Fatalf("cancelled: Delay %v, want InfDuration", d)
+	}
+	if d, pos := second.Delay(), second.QueuePosition(); d != 2*time.Second || pos != 0 {
+		t.Fatalf("second after cancel: Delay %v, QueuePosition %d; want 2s, 0", d, pos)
+	}
+
+	clock.Advance(2 * time.Second)
+	if d := second.Delay(); d != 0 {
+		t.Fatalf("second once due: Delay %v, want 0", d)
+	}
+	if got := tb.AvailableTokens(); got != 0 {
+		t.Fatalf("tokens after serving the reservation: %d, want 0", got)
+	}
+	second.Cancel()
+	if got := tb.AvailableTokens(); got != 2 {
+		t.Fatalf("tokens after cancelling a served reservation: %d, want 2", got)
+	}
+}
+
+func TestReserveNServesAtOnceWhenTokensAreThere(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+
+	r, err := tb.ReserveN(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, pos := r.Delay(), r.QueuePosition(); d != 0 || pos != 0 {
+		t.Fatalf("Delay %v, QueuePosition %d; want 0, 0", d, pos)
+	}
+	if got := tb.AvailableTokens(); got != 1 {
+		t.Fatalf("tokens = %d, want 1", got)
+	}
+}
+
+func TestReserveNErrors(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour, withoutRefillLog())
+	if _, err := tb.ReserveN(-1); !errors.Is(err, ErrNegativeTokens) {
+		t.Fatalf("ReserveN(-1) error = %v, want ErrNegativeTokens", err)
+	}
+	if _, err := tb.ReserveN(4); !errors.Is(err, ErrTokensExceedCapacity) {
+		t.Fatalf("ReserveN(4) error = %v, want ErrTokensExceedCapacity", err)
+	}
+
+	tb.AllowN(3)
+	r, err := tb.ReserveN(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb.Stop()
+	if d := r.Delay(); d != InfDuration {
+		t.Fatalf("reservation on a stopped bucket: Delay %v, want InfDuration", d)
+	}
+	r.Cancel()
+	if _, err := tb.ReserveN(1); !errors.Is(err, ErrStopped) {
+		t.Fatalf("ReserveN after Stop error = %v, want ErrStopped", err)
+	}
+
+	var unset *TokenBucket
+	r, err = unset.ReserveN(5)
+	if err != nil || r.Delay() != 0 {
+		t.Fatalf("nil bucket: %v, Delay %v; want a served reservation", err, r.Delay())
+	}
+	r.Cancel()
+}
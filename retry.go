@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync"
+)
+
+// RetryBudget caps retries to a fraction of successful traffic so that an
+// outage can't set off a retry storm. Each retry spends a token from the
+// bucket. Each RecordSuccess earns back ratio of a token (0.1 means one retry
+// per ten successes), on top of the bucket's own refill, which acts as a
+// floor so callers can still retry occasionally when nothing is succeeding.
+// Each RecordFailure cancels out one success's worth of credit that has not
+// yet added up to a whole token, so a mix of successes and failures earns
+// retries in proportion to the net number of successes.
+type RetryBudget struct {
+	tb    *TokenBucket
+	ratio float64
+
+	mu     sync.Mutex
+	credit float64
+}
+
+func NewRetryBudget(tb *TokenBucket, ratio float64) *RetryBudget {
+	return &RetryBudget{tb: tb, ratio: ratio}
+}
+
+// CanRetry reports whether a retry may be attempted, spending a token if so.
+func (b *RetryBudget) CanRetry() bool {
+	return b.tb.Allow()
+}
+
+func (b *RetryBudget) RecordSuccess() {
+	b.mu.Lock()
+	b.credit += b.ratio
+	whole := int64(b.credit)
+	b.credit -= float64(whole)
+	b.mu.Unlock()
+
+	if whole > 0 {
+		b.tb.Refund(whole)
+	}
+}
+
+func (b *RetryBudget) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.credit -= b.ratio; b.credit < 0 {
+		b.credit = 0
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBudgetExhaustedByFailures(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour)
+	defer tb.Stop()
+	b := NewRetryBudget(tb, 0.1)
+
+	retries := 0
+	for i := 0; i < 100; i++ {
+		b.RecordFailure()
+		if b.CanRetry() {
+			retries++
+		}
+	}
+	if retries != 3 {
+		t.Fatalf("%d retries over 100 failures, want 3", retries)
+	}
+}
+
+func TestRetryBudgetEarnsRetriesFromSuccesses(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour)
+	defer tb.Stop()
+	b := NewRetryBudget(tb, 0.25)
+	tb.AllowN(3)
+
+	for i := 0; i < 3; i++ {
+		b.RecordSuccess()
+	}
+	if b.CanRetry() {
+		t.Fatal("retry allowed after 3 successes at one retry per 4")
+	}
+	b.RecordSuccess()
+	if !b.CanRetry() {
+		t.Fatal("retry denied after 4 successes at one retry per 4")
+	}
+
+	// A failure cancels a success's worth of credit.
+	for i := 0; i < 3; i++ {
+		b.RecordSuccess()
+	}
+	b.RecordFailure()
+	b.RecordSuccess()
+	if b.CanRetry() {
+		t.Fatal("retry allowed with 3 net successes")
+	}
+}
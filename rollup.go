@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// MinuteStat counts the decisions made during the minute starting at Start.
+type MinuteStat struct {
+	Start   time.Time
+	Allowed int64
+	Denied  int64
+}
+
+// RollupCollector keeps per-minute allowed and denied counts for the last
+// few minutes, cheap enough to back a sparkline without a metrics system.
+// Attach it with WithRollup; one collector may be shared by several buckets
+// to count them together.
+type RollupCollector struct {
+	mu      sync.Mutex
+	clock   Clock
+	minutes []MinuteStat
+}
+
+// NewRollupCollector keeps the given number of minutes, placed by clock, or
+// by the real time if clock is nil.
+func NewRollupCollector(clock Clock, minutes int) *RollupCollector {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &RollupCollector{clock: clock, minutes: make([]MinuteStat, max(minutes, 1))}
+}
+
+func WithRollup(c *RollupCollector) Option {
+	return func(tb *TokenBucket) {
+		tb.rollup = c
+	}
+}
+
+func (c *RollupCollector) record(allowed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := c.minuteLocked(c.clock.Now().Truncate(time.Minute))
+	if allowed {
+		m.Allowed++
+	} else {
+		m.Denied++
+	}
+}
+
+// minuteLocked returns the slot for the minute starting at start, clearing
+// whatever older minute it last held.
+func (c *RollupCollector) minuteLocked(start time.Time) *MinuteStat {
+	m := &c.minutes[c.slot(start)]
+	if !m.Start.Equal(start) {
+		*m = MinuteStat{Start: start}
+	}
+	return m
+}
+
+func (c *RollupCollector) slot(start time.Time) int {
+	n := int64(len(c.minutes))
+	return int((start.Unix()/60%n + n) % n)
+}
+
+// Window returns one entry per minute, oldest first, ending with the
+// current minute. Minutes without any decisions have zero counts.
+func (c *RollupCollector) Window() []MinuteStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now().Truncate(time.Minute)
+	out := make([]MinuteStat, len(c.minutes))
+	for i := range out {
+		start := now.Add(-time.Duration(len(out)-1-i) * time.Minute)
+		out[i] = MinuteStat{Start: start}
+		if m := c.minutes[c.slot(start)]; m.Start.Equal(start) {
+			out[i] = m
+		}
+	}
+	return out
+}
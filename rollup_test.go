@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRollupCollectorWindow(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	c := NewRollupCollector(clock, 4)
+	tb := NewTokenBucket(1, 3, time.Hour, WithClock(clock), WithRollup(c))
+	defer tb.Stop()
+
+	// Minute one: two allowed. Minute two: one allowed and two denied.
+	tb.Allow()
+	tb.Allow()
+	clock.Advance(time.Minute + 30*time.Second)
+	tb.Allow()
+	tb.Allow()
+	tb.Allow()
+
+	want := []MinuteStat{
+		{Start: start.Add(-2 * time.Minute)},
+		{Start: start.Add(-time.Minute)},
+		{Start: start, Allowed: 2},
+		{Start: start.Add(time.Minute), Allowed: 1, Denied: 2},
+	}
+	if got := c.Window(); !slices.Equal(got, want) {
+		t.Fatalf("Window() = %+v, want %+v", got, want)
+	}
+
+	// Minutes that fall out of the window are forgotten.
+	clock.Advance(3 * time.Minute)
+	tb.Allow()
+	want = []MinuteStat{
+		{Start: start.Add(time.Minute), Allowed: 1, Denied: 2},
+		{Start: start.Add(2 * time.Minute)},
+		{Start: start.Add(3 * time.Minute)},
+		{Start: start.Add(4 * time.Minute), Denied: 1},
+	}
+	if got := c.Window(); !slices.Equal(got, want) {
+		t.Fatalf("later Window() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+)
+
+// RouteLimiter is middleware limiting each route in routes by its own
+// bucket, declared all at once: the keys are ServeMux patterns such as
+// "POST /upload" or "GET /items/{id}", matched against each request as a
+// ServeMux would, and every pattern gets a bucket built from its Config.
+// Requests matching no pattern share one bucket built from fallback; a
+// fallback with Disabled set leaves them unlimited. The buckets live in a
+// LimiterManager for as long as the process. Like ServeMux.Handle,
+// RouteLimiter panics on an invalid pattern or two that conflict.
+func RouteLimiter(routes map[string]Config, fallback Config) func(http.Handler) http.Handler {
+	routes = maps.Clone(routes)
+	mux := http.NewServeMux()
+	for pattern := range routes {
+		mux.Handle(pattern, http.NotFoundHandler())
+	}
+	m := NewLimiterManager(func(pattern string) Config {
+		if cfg, ok := routes[pattern]; ok {
+			return cfg
+		}
+		return fallback
+	})
+	return m.Middleware(func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouteLimiterLimitsEachRouteIndependently(t *testing.T) {
+	h := RouteLimiter(map[string]Config{
+		"POST /upload":    {Rate: 1, Capacity: 2, Interval: time.Hour},
+		"GET /items/{id}": {Rate: 1, Capacity: 1, Interval: time.Hour},
+	}, Config{Rate: 1, Capacity: 1, Interval: time.Hour, Disabled: true})(statusHandler(http.StatusOK))
+	captureLog(t)
+	request := func(method, path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if got := request(http.MethodPost, "/upload"); got != http.StatusOK {
+			t.Fatalf("upload %d: status %d", i, got)
+		}
+	}
+	if got := request(http.MethodPost, "/upload"); got != http.StatusTooManyRequests {
+		t.Fatalf("upload past its capacity: status %d, want 429", got)
+	}
+	for i := 0; i < 5; i++ {
+		if got := request(http.MethodGet, "/upload"); got != http.StatusOK {
+			t.Fatalf("GET of the unlimited fallback: status %d", got)
+		}
+	}
+
+	if got := request(http.MethodGet, "/items/1"); got != http.StatusOK {
+		t.Fatalf("first item: status %d", got)
+	}
+	if got := request(http.MethodGet, "/items/2"); got != http.StatusTooManyRequests {
+		t.Fatalf("another item on the same pattern: status %d, want 429", got)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+)
+
+// ScheduleReset tops the bucket up to capacity at the given instant, e.g.
+// just before a known traffic spike, so the first burst is fully absorbed.
+// It replaces any reset already scheduled. The reset only adds tokens: the
+// refill schedule carries on unchanged around it.
+func (tb *TokenBucket) ScheduleReset(at time.Time) {
+	tb.ScheduleResetEvery(at, 0)
+}
+
+// ScheduleResetEvery is ScheduleReset repeating every period from first, a
+// daily reset at noon being ScheduleResetEvery(nextNoon, 24*time.Hour). A
+// period of zero resets once.
+func (tb *TokenBucket) ScheduleResetEvery(first time.Time, every time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	tb.resetAt = first
+	tb.resetEvery = every
+	tb.resetIfDueLocked()
+}
+
+// CancelReset drops any scheduled reset.
+func (tb *TokenBucket) CancelReset() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.resetAt = time.Time{}
+	tb.resetEvery = 0
+}
+
+// resetIfDueLocked applies a scheduled reset whose time has come. Resets are
+// applied lazily, whenever the bucket is next used, and a recurring
+// schedule that has fallen behind skips the instants already missed.
+func (tb *TokenBucket) resetIfDueLocked() {
+	if tb.resetAt.IsZero() {
+		return
+	}
+	now := tb.clock.Now()
+	if now.Before(tb.resetAt) {
+		return
+	}
+
+	if tb.resetEvery > 0 {
+		skipped := now.Sub(tb.resetAt) / tb.resetEvery
+		tb.resetAt = tb.resetAt.Add((skipped + 1) * tb.resetEvery)
+	} else {
+		tb.resetAt = time.Time{}
+	}
+	if tb.tokens < tb.capacity {
+		tb.creditLocked(tb.capacity - tb.tokens)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleResetFillsAtInstant(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewManualClock(start)
+	tb := NewTokenBucket(1, 100, time.Minute, WithClock(clock))
+	defer tb.Stop()
+	tb.AllowN(100)
+	tb.ScheduleReset(start.Add(30 * time.Minute))
+
+	clock.Advance(29*time.Minute + 59*time.Second)
+	if got := tb.AvailableTokens(); got != 29 {
+		t.Fatalf("just before the reset: %d tokens, want 29", got)
+	}
+	clock.Advance(time.Second)
+	if got := tb.AvailableTokens(); got != 100 {
+		t.Fatalf("at the reset: %d tokens, want 100", got)
+	}
+
+	// Refills carry on as before, and the reset doesn't happen again.
+	tb.AllowN(100)
+	clock.Advance(2 * time.Hour)
+	tb.AllowN(tb.AvailableTokens())
+	clock.Advance(time.Minute)
+	if got := tb.AvailableTokens(); got != 1 {
+		t.Fatalf("a refill after the reset: %d tokens, want 1", got)
+	}
+}
+
+func TestScheduleResetEveryRepeats(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewManualClock(start)
+	tb := NewTokenBucket(1, 100, time.Hour, WithClock(clock))
+	defer tb.Stop()
+	tb.ScheduleResetEvery(start.Add(24*time.Hour), 24*time.Hour)
+
+	for day := 1; day <= 3; day++ {
+		tb.AllowN(tb.AvailableTokens())
+		clock.Advance(23 * time.Hour)
+		if got := tb.AvailableTokens(); got == 100 {
+			t.Fatalf("day %d: full before the reset", day)
+		}
+		clock.Advance(time.Hour)
+		if got := tb.AvailableTokens(); got != 100 {
+			t.Fatalf("day %d: %d tokens at the reset, want 100", day, got)
+		}
+	}
+
+	tb.CancelReset()
+	tb.AllowN(100)
+	clock.Advance(24 * time.Hour)
+	if got := tb.AvailableTokens(); got != 24 {
+		t.Fatalf("after CancelReset: %d tokens, want 24 from refills alone", got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TimeWindow is a daily span of time of day, from Start up to but not
+// including End, both measured from midnight in the clock's location. A
+// window whose End is before its Start wraps past midnight, so
+// {22 * time.Hour, 6 * time.Hour} covers the night.
+type TimeWindow struct {
+	Start, End time.Duration
+}
+
+// ScheduledBucket pairs a window with the bucket that limits during it.
+type ScheduledBucket struct {
+	Window TimeWindow
+	Bucket *TokenBucket
+}
+
+// ScheduledLimiter routes each call to the bucket whose window covers the
+// current time of day, e.g. to allow more traffic off-hours. Times not
+// covered by any window go to the fallback bucket.
+type ScheduledLimiter struct {
+	clock    Clock
+	windows  []ScheduledBucket
+	fallback *TokenBucket
+}
+
+// NewScheduledLimiter checks that no two windows overlap and, if the
+// windows leave any time of day uncovered, that a fallback is given. A nil
+// clock means the real time.
+func NewScheduledLimiter(clock Clock, fallback *TokenBucket, windows ...ScheduledBucket) (*ScheduledLimiter, error) {
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	type span struct{ start, end time.Duration }
+	var spans []span
+	for i, w := range windows {
+		s, e := w.Window.Start, w.Window.End
+		if s < 0 || s >= 24*time.Hour || e < 0 || e > 24*time.Hour || s == e {
+			return nil, fmt.Errorf("window %d: invalid span %s-%s", i, s, e)
+		}
+		if w.Bucket == nil {
+			return nil, fmt.Errorf("window %d: no bucket", i)
+		}
+		if e < s {
+			spans = append(spans, span{s, 24 * time.Hour}, span{0, e})
+		} else {
+			spans = append(spans, span{s, e})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var covered time.Duration
+	gaps := false
+	for _, sp := range spans {
+		if sp.start < covered {
+			return nil, errors.New("windows overlap")
+		}
+		if sp.start > covered {
+			gaps = true
+		}
+		covered = sp.end
+	}
+	if covered < 24*time.Hour {
+		gaps = true
+	}
+	if gaps && fallback == nil {
+		return nil, errors.New("windows leave gaps and no fallback bucket is set")
+	}
+
+	return &ScheduledLimiter{clock: clock, windows: windows, fallback: fallback}, nil
+}
+
+// Active returns the bucket in effect now.
+func (l *ScheduledLimiter) Active() *TokenBucket {
+	now := l.clock.Now()
+	h, m, s := now.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(now.Nanosecond())
+
+	for _, w := range l.windows {
+		if w.Window.covers(tod) {
+			return w.Bucket
+		}
+	}
+	return l.fallback
+}
+
+func (w TimeWindow) covers(tod time.Duration) bool {
+	if w.End < w.Start {
+		return tod >= w.Start || tod < w.End
+	}
+	return tod >= w.Start && tod < w.End
+}
+
+func (l *ScheduledLimiter) Allow() bool {
+	return l.Active().Allow()
+}
+
+func (l *ScheduledLimiter) AllowN(n int64) bool {
+	return l.Active().AllowN(n)
+}
+
+// WaitNContext waits on the bucket active when it is called, even if the
+// window ends while it waits.
+func (l *ScheduledLimiter) WaitNContext(ctx context.Context, n int64) error {
+	return l.Active().WaitNContext(ctx, n)
+}
+
+func (l *ScheduledLimiter) Clock() Clock {
+	return l.clock
+}
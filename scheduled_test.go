@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduledLimiterSwitchesAtWindowBoundary(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 8, 59, 59, 0, time.UTC))
+	day := NewTokenBucket(1, 1, time.Hour, WithClock(clock))
+	defer day.Stop()
+	night := NewTokenBucket(100, 100, time.Hour, WithClock(clock))
+	defer night.Stop()
+
+	l, err := NewScheduledLimiter(clock, nil,
+		ScheduledBucket{Window: TimeWindow{9 * time.Hour, 17 * time.Hour}, Bucket: day},
+		ScheduledBucket{Window: TimeWindow{17 * time.Hour, 9 * time.Hour}, Bucket: night},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		advance time.Duration
+		want    *TokenBucket
+		name    string
+	}{
+		{0, night, "08:59:59"},
+		{time.Second, day, "09:00:00"},
+		{8*time.Hour - time.Nanosecond, day, "16:59:59.999999999"},
+		{time.Nanosecond, night, "17:00:00"},
+		{7 * time.Hour, night, "00:00:00, past midnight"},
+	}
+	for _, s := range steps {
+		clock.Advance(s.advance)
+		if got := l.Active(); got != s.want {
+			t.Fatalf("%s: wrong bucket active", s.name)
+		}
+	}
+
+	// Calls land on the active bucket: the day bucket allows one request,
+	// the night one a hundred.
+	clock.Advance(9 * time.Hour)
+	if !l.Allow() || l.Allow() {
+		t.Fatal("day: want exactly one request allowed")
+	}
+	clock.Advance(8 * time.Hour)
+	if !l.AllowN(100) {
+		t.Fatal("night: want the full night budget")
+	}
+}
+
+func TestScheduledLimiterFallbackCoversGaps(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	peak := NewTokenBucket(1, 1, time.Hour, WithClock(clock))
+	defer peak.Stop()
+	fallback := NewTokenBucket(10, 10, time.Hour, WithClock(clock))
+	defer fallback.Stop()
+
+	l, err := NewScheduledLimiter(clock, fallback,
+		ScheduledBucket{Window: TimeWindow{12 * time.Hour, 14 * time.Hour}, Bucket: peak})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Active() != peak {
+		t.Fatal("12:00: want the peak bucket")
+	}
+	clock.Advance(2 * time.Hour)
+	if l.Active() != fallback {
+		t.Fatal("14:00: want the fallback bucket")
+	}
+}
+
+func TestNewScheduledLimiterValidatesWindows(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour)
+	defer tb.Stop()
+	at := func(start, end time.Duration) ScheduledBucket {
+		return ScheduledBucket{Window: TimeWindow{start * time.Hour, end * time.Hour}, Bucket: tb}
+	}
+
+	tests := []struct {
+		name     string
+		fallback *TokenBucket
+		windows  []ScheduledBucket
+		wantErr  bool
+	}{
+		{"whole day", nil, []ScheduledBucket{at(0, 24)}, false},
+		{"split day", nil, []ScheduledBucket{at(6, 18), at(18, 6)}, false},
+		{"gap with fallback", tb, []ScheduledBucket{at(6, 18)}, false},
+		{"gap without fallback", nil, []ScheduledBucket{at(6, 18)}, true},
+		{"overlap", tb, []ScheduledBucket{at(6, 18), at(17, 20)}, true},
+		{"overlap past midnight", tb, []ScheduledBucket{at(22, 6), at(5, 8)}, true},
+		{"empty window", tb, []ScheduledBucket{at(6, 6)}, true},
+		{"out of range", tb, []ScheduledBucket{at(6, 25)}, true},
+		{"no bucket", tb, []ScheduledBucket{{Window: TimeWindow{0, time.Hour}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewScheduledLimiter(nil, tt.fallback, tt.windows...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// SessionLimiter charges per session rather than per request: a token from
+// the bucket buys a pass lasting window, and while the pass is active every
+// Allow succeeds without consuming anything. Only once it has expired does
+// the next Allow take another token, starting a new pass from that moment,
+// so a caller pays at most one token per window however busy it is, and
+// nothing while idle. Expiry is measured on the bucket's clock.
+type SessionLimiter struct {
+	tb     *TokenBucket
+	window time.Duration
+
+	mu      sync.Mutex
+	expires time.Time
+}
+
+func NewSessionLimiter(tb *TokenBucket, window time.Duration) *SessionLimiter {
+	return &SessionLimiter{tb: tb, window: window}
+}
+
+// Allow reports whether a request may go ahead. A pass covers the half-open
+// span [start, start+window): at exactly start+window it has expired, and
+// that request is the one that must buy a new pass. If the bucket denies
+// the token, no pass is started and later calls keep trying.
+func (s *SessionLimiter) Allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.tb.clock.Now()
+	if now.Before(s.expires) {
+		return true
+	}
+	if !s.tb.Allow() {
+		return false
+	}
+	s.expires = now.Add(s.window)
+	return true
+}
+
+// PassExpires is when the current pass runs out, or the zero Time if none
+// has been bought.
+func (s *SessionLimiter) PassExpires() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.expires
+}
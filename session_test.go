@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionLimiterChargesOncePerWindow(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 2, time.Hour, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	s := NewSessionLimiter(tb, 5*time.Second)
+
+	for i := 0; i < 100; i++ {
+		if !s.Allow() {
+			t.Fatalf("request %d denied during the pass", i)
+		}
+	}
+	if got := tb.AvailableTokens(); got != 1 {
+		t.Fatalf("tokens = %d after a pass of requests, want 1 spent", got)
+	}
+	if got, want := s.PassExpires(), time.Unix(5, 0); !got.Equal(want) {
+		t.Fatalf("PassExpires = %v, want %v", got, want)
+	}
+
+	clock.Advance(5*time.Second - time.Nanosecond)
+	if !s.Allow() || tb.AvailableTokens() != 1 {
+		t.Fatal("the last instant of the pass was charged")
+	}
+	clock.Advance(time.Nanosecond)
+	if !s.Allow() || tb.AvailableTokens() != 0 {
+		t.Fatal("a request at expiry did not buy a new pass")
+	}
+
+	clock.Advance(5 * time.Second)
+	if s.Allow() {
+		t.Fatal("allowed with the pass expired and the bucket empty")
+	}
+	if got, want := s.PassExpires(), time.Unix(10, 0); !got.Equal(want) {
+		t.Fatalf("PassExpires = %v after a denial, want the old %v", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// SharedBudgetLimiter divides one bucket's rate between named consumers by
+// weight, with work-conserving max-min fairness. Time is cut into the
+// bucket's intervals, counted from its creation. A consumer is active if it
+// has asked for a token in the current interval or the one before, and each
+// interval an active consumer is entitled to rate·weight/W tokens, where W
+// is the total weight of the active consumers. A consumer within its
+// entitlement is admitted whenever the bucket has a token. One that is over
+// it may still take tokens, but only those not needed to cover what the
+// other active consumers are yet to use of theirs, so it borrows spare
+// capacity without ever eating into someone else's share. Consumers that
+// are idle reserve nothing, which lets a lone consumer use the whole rate.
+//
+// Unlike FairLimiter, which compares decaying usage once the pool runs low,
+// the shares here are exact per interval.
+type SharedBudgetLimiter struct {
+	tb *TokenBucket
+
+	mu        sync.Mutex
+	window    int64
+	consumers map[string]*budgetConsumer
+}
+
+type budgetConsumer struct {
+	weight   float64
+	used     int64 // tokens taken in the current window
+	lastSeen int64 // window of the last request
+}
+
+func NewSharedBudgetLimiter(tb *TokenBucket) *SharedBudgetLimiter {
+	return &SharedBudgetLimiter{tb: tb, consumers: make(map[string]*budgetConsumer)}
+}
+
+// AllowFor takes a token from the shared bucket on behalf of name. Weights
+// are relative; a weight of 2 entitles a consumer to twice the share of one
+// with weight 1. Non-positive, NaN and infinite weights count as 1. The
+// latest weight given for a name is the one that applies.
+func (s *SharedBudgetLimiter) AllowFor(name string, weight float64) bool {
+	if !(weight > 0) || math.IsInf(weight, 1) {
+		weight = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg := s.tb.Config()
+	s.advanceLocked(cfg)
+
+	c := s.consumers[name]
+	if c == nil {
+		c = &budgetConsumer{}
+		s.consumers[name] = c
+	}
+	c.weight = weight
+	c.lastSeen = s.window
+
+	total := 0.0
+	for _, o := range s.consumers {
+		total += o.weight
+	}
+	share := func(o *budgetConsumer) float64 {
+		return float64(cfg.Rate) * o.weight / total
+	}
+
+	if float64(c.used+1) > share(c) {
+		reserve := 0.0
+		for other, o := range s.consumers {
+			if other != name {
+				reserve += max(share(o)-float64(o.used), 0)
+			}
+		}
+		if float64(s.tb.AvailableTokens()-1) < reserve {
+			return false
+		}
+	}
+
+	if !s.tb.Allow() {
+		return false
+	}
+	c.used++
+	return true
+}
+
+// advanceLocked moves to the current window, starting everyone's usage
+// afresh and forgetting consumers that have gone idle.
+func (s *SharedBudgetLimiter) advanceLocked(cfg Config) {
+	if cfg.Interval <= 0 {
+		return
+	}
+	window := int64(s.tb.clock.Now().Sub(s.tb.createdAt) / cfg.Interval)
+	if window == s.window {
+		return
+	}
+	s.window = window
+	for name, c := range s.consumers {
+		if c.lastSeen < window-1 {
+			delete(s.consumers, name)
+			continue
+		}
+		c.used = 0
+	}
+}
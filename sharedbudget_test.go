@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSharedBudgetLimiterSplitsEquallyUnderContention(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(10, 10, 100*time.Millisecond, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	s := NewSharedBudgetLimiter(tb)
+
+	// Both consumers ask for far more than the pool refills; the greedy one
+	// asks three times as often.
+	var greedy, modest int
+	for i := 0; i < 200; i++ {
+		clock.Advance(100 * time.Millisecond)
+		for j := 0; j < 30; j++ {
+			if s.AllowFor("greedy", 1) {
+				greedy++
+			}
+			if j%3 == 0 && s.AllowFor("modest", 1) {
+				modest++
+			}
+		}
+	}
+
+	total := greedy + modest
+	if share := float64(modest) / float64(total); share < 0.45 || share > 0.55 {
+		t.Fatalf("modest consumer got %d of %d tokens (%.0f%%), want close to half", modest, total, 100*share)
+	}
+}
+
+func TestSharedBudgetLimiterLendsIdleShares(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(10, 10, 100*time.Millisecond, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	s := NewSharedBudgetLimiter(tb)
+
+	s.AllowFor("idle", 1)
+	clock.Advance(300 * time.Millisecond)
+
+	var got int
+	for i := 0; i < 50; i++ {
+		clock.Advance(100 * time.Millisecond)
+		for j := 0; j < 20; j++ {
+			if s.AllowFor("busy", 1) {
+				got++
+			}
+		}
+	}
+	if got != 500 {
+		t.Fatalf("the only active consumer got %d tokens, want all 500 refilled", got)
+	}
+}
+
+func TestSharedBudgetLimiterHonoursWeights(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(9, 9, 100*time.Millisecond, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	s := NewSharedBudgetLimiter(tb)
+
+	var heavy, light int
+	for i := 0; i < 100; i++ {
+		clock.Advance(100 * time.Millisecond)
+		for j := 0; j < 20; j++ {
+			if s.AllowFor("light", 1) {
+				light++
+			}
+			if s.AllowFor("heavy", 2) {
+				heavy++
+			}
+		}
+	}
+	if ratio := float64(heavy) / float64(light); ratio < 1.8 || ratio > 2.2 {
+		t.Fatalf("heavy got %d and light %d, a ratio of %.2f; want about 2", heavy, light, ratio)
+	}
+}
+
+func TestSharedBudgetLimiterTreatsBadWeightsAsOne(t *testing.T) {
+	for _, weight := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		clock := NewManualClock(time.Unix(0, 0))
+		tb := NewTokenBucket(10, 10, 100*time.Millisecond, WithClock(clock), withoutRefillLog())
+		s := NewSharedBudgetLimiter(tb)
+
+		// As in the contention test, but the greedy consumer's weight
+		// would poison every share if it were used as given.
+		var greedy, modest int
+		for i := 0; i < 200; i++ {
+			clock.Advance(100 * time.Millisecond)
+			for j := 0; j < 30; j++ {
+				if s.AllowFor("greedy", weight) {
+					greedy++
+				}
+				if j%3 == 0 && s.AllowFor("modest", 1) {
+					modest++
+				}
+			}
+		}
+		tb.Stop()
+
+		total := greedy + modest
+		if share := float64(modest) / float64(total); share < 0.45 || share > 0.55 {
+			t.Errorf("weight %v: modest consumer got %d of %d tokens (%.0f%%), want close to half", weight, modest, total, 100*share)
+		}
+	}
+}
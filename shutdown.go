@@ -0,0 +1,16 @@
+package main
+
+import "net/http"
+
+// AttachToServer stops each of stoppers when srv shuts down, so limiter
+// teardown comes with a graceful Shutdown instead of defers that are easy to
+// forget. The calls run from srv's RegisterOnShutdown hook, in order, in a
+// goroutine of their own, and Shutdown does not wait for them. A
+// LimiterManager can be attached directly; its Stop is StopAll.
+func AttachToServer(srv *http.Server, stoppers ...interface{ Stop() }) {
+	srv.RegisterOnShutdown(func() {
+		for _, s := range stoppers {
+			s.Stop()
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAttachToServerStopsOnShutdown(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour, withoutRefillLog())
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 1, Capacity: 5, Interval: time.Hour} })
+	perKey := m.GetOrCreate("k")
+
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	AttachToServer(srv.Config, tb, m)
+	srv.Start()
+	defer srv.Close()
+
+	if err := srv.Config.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	for _, b := range []*TokenBucket{tb, perKey} {
+		waitFor(t, func() bool {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			return b.stopped
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// StatsSnapshot is the part of Stats that Snapshot can read without the
+// bucket's lock.
+type StatsSnapshot struct {
+	Tokens        int64
+	Capacity      int64
+	TotalConsumed int64
+	Allowed       int64
+	Denied        int64
+	LowWaterMark  int64
+}
+
+// Snapshot is Stats for dashboards that poll hard: it never takes the
+// bucket's lock, so polling cannot hold up Allow. The counters are
+// published by every change to the balance and every decision, and read
+// with a sequence lock, so all the fields come from the same instant, just
+// possibly one a moment behind a concurrent Allow. Unlike Stats it does not
+// bring refills up to date, so Tokens can trail time by up to an interval
+// on a bucket nobody is using. Stats remains the authoritative view.
+//
+// Publishing only starts with the first call, which takes the lock once to
+// switch it on, so buckets nobody polls this way pay nothing for it.
+func (tb *TokenBucket) Snapshot() StatsSnapshot {
+	if !tb.snapshot.on.Load() {
+		tb.mu.Lock()
+		tb.snapshot.on.Store(true)
+		tb.publishSnapshotLocked()
+		tb.mu.Unlock()
+	}
+	return tb.snapshot.load()
+}
+
+// snapshotCell is a sequence lock over a StatsSnapshot: the writer, always
+// holding the bucket's lock, makes seq odd while it stores the fields, and
+// a reader retries until it has read them all within one even seq.
+type snapshotCell struct {
+	on                                                    atomic.Bool
+	seq                                                   atomic.Uint64
+	tokens, capacity, consumed, allowed, denied, lowWater atomic.Int64
+}
+
+func (c *snapshotCell) store(s StatsSnapshot) {
+	c.seq.Add(1)
+	c.tokens.Store(s.Tokens)
+	c.capacity.Store(s.Capacity)
+	c.consumed.Store(s.TotalConsumed)
+	c.allowed.Store(s.Allowed)
+	c.denied.Store(s.Denied)
+	c.lowWater.Store(s.LowWaterMark)
+	c.seq.Add(1)
+}
+
+func (c *snapshotCell) load() StatsSnapshot {
+	for {
+		seq := c.seq.Load()
+		if seq&1 != 0 {
+			runtime.Gosched()
+			continue
+		}
+		s := StatsSnapshot{
+			Tokens:        c.tokens.Load(),
+			Capacity:      c.capacity.Load(),
+			TotalConsumed: c.consumed.Load(),
+			Allowed:       c.allowed.Load(),
+			Denied:        c.denied.Load(),
+			LowWaterMark:  c.lowWater.Load(),
+		}
+		if c.seq.Load() == seq {
+			return s
+		}
+	}
+}
+
+// publishSnapshotLocked makes the current counters what Snapshot sees.
+func (tb *TokenBucket) publishSnapshotLocked() {
+	if !tb.snapshot.on.Load() {
+		return
+	}
+	tb.snapshot.store(StatsSnapshot{
+		Tokens:        tb.tokens,
+		Capacity:      tb.capacity,
+		TotalConsumed: tb.consumed,
+		Allowed:       tb.allowed,
+		Denied:        tb.denied,
+		LowWaterMark:  tb.lowWater,
+	})
+}
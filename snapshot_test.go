@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotIsNeverTorn(t *testing.T) {
+	const capacity = 1 << 20
+	tb := NewTokenBucket(1, capacity, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+
+	var wg sync.WaitGroup
+	var stop atomic.Bool
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; !stop.Load(); j++ {
+				tb.AllowN(int64(1 + j%3))
+			}
+		}()
+	}
+
+	// Without refills every token is either in the bucket or consumed, at
+	// every instant a snapshot can come from.
+	var last StatsSnapshot
+	for i := 0; i < 100_000; i++ {
+		s := tb.Snapshot()
+		if s.Tokens+s.TotalConsumed != capacity || s.Capacity != capacity || s.LowWaterMark > s.Tokens {
+			stop.Store(true)
+			wg.Wait()
+			t.Fatalf("torn snapshot %+v", s)
+		}
+		if s.TotalConsumed < last.TotalConsumed || s.Allowed < last.Allowed || s.Denied < last.Denied {
+			stop.Store(true)
+			wg.Wait()
+			t.Fatalf("snapshot %+v went back from %+v", s, last)
+		}
+		last = s
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	stats, snap := tb.Stats(), tb.Snapshot()
+	if snap.Tokens != stats.Tokens || snap.TotalConsumed != stats.TotalConsumed || snap.Allowed != stats.Allowed ||
+		snap.Denied != stats.Denied || snap.LowWaterMark != stats.LowWaterMark {
+		t.Fatalf("quiescent Snapshot %+v disagrees with Stats %+v", snap, stats)
+	}
+}
+
+// BenchmarkAllowWhilePolling measures Allow throughput with another
+// goroutine polling the bucket's counters as fast as it can, through Stats,
+// which takes the lock, and Snapshot, which doesn't.
+func BenchmarkAllowWhilePolling(b *testing.B) {
+	for _, poll := range []struct {
+		name string
+		fn   func(*TokenBucket)
+	}{
+		{"none", nil},
+		{"Stats", func(tb *TokenBucket) { tb.Stats() }},
+		{"Snapshot", func(tb *TokenBucket) { tb.Snapshot() }},
+	} {
+		b.Run(fmt.Sprintf("poll=%s", poll.name), func(b *testing.B) {
+			tb := NewTokenBucket(1<<40, 1<<40, time.Hour, withoutRefillLog())
+			defer tb.Stop()
+			var stop atomic.Bool
+			var wg sync.WaitGroup
+			if poll.fn != nil {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for !stop.Load() {
+						poll.fn(tb)
+					}
+				}()
+			}
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					tb.Allow()
+				}
+			})
+			b.StopTimer()
+			stop.Store(true)
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// WithSoftStart ramps the refill rate up linearly from zero to the
+// configured rate over warmup, starting when the bucket is created, so a
+// backend that has just come up isn't hit with full traffic at once. The
+// bucket starts empty rather than full for the same reason.
+func WithSoftStart(warmup time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.softStart = warmup
+		tb.tokens = 0
+	}
+}
+
+// softStartScaleLocked is the fraction of the configured rate in effect
+// now.
+func (tb *TokenBucket) softStartScaleLocked() float64 {
+	if tb.softStart <= 0 {
+		return 1
+	}
+	warmed := tb.clock.Now().Sub(tb.createdAt)
+	if warmed >= tb.softStart {
+		return 1
+	}
+	return max(float64(warmed)/float64(tb.softStart), 0)
+}
+
+// periodRefillLocked is how many tokens the refill period now ending earns
+// in all. While warming up or scaled down that is a fraction of rate, and
+// the part of a token left over is carried to the next period so slow
+// ramps still make progress.
+func (tb *TokenBucket) periodRefillLocked() int64 {
+	scale := tb.rateScaleLocked()
+	if scale >= 1 {
+		tb.softCarry = 0
+		return tb.rate
+	}
+	total := scale*float64(tb.rate) + tb.softCarry
+	whole := math.Floor(total)
+	tb.softCarry = total - whole
+	return int64(whole)
+}
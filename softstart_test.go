@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSoftStartRampsRate(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(100, 1000, time.Second, WithClock(clock), WithSoftStart(10*time.Second))
+	defer tb.Stop()
+
+	// released drains the bucket every 100ms for a second and returns how
+	// many tokens came out.
+	released := func() int64 {
+		var total int64
+		for i := 0; i < 10; i++ {
+			clock.Advance(100 * time.Millisecond)
+			n := tb.AvailableTokens()
+			tb.AllowN(n)
+			total += n
+		}
+		return total
+	}
+
+	if n := tb.AvailableTokens(); n != 0 {
+		t.Fatalf("%d tokens at creation, want 0", n)
+	}
+	clock.Advance(4500 * time.Millisecond)
+	tb.AllowN(tb.AvailableTokens())
+	if got := released(); got < 45 || got > 55 {
+		t.Fatalf("%d tokens released around the middle of the warm-up, want about 50", got)
+	}
+
+	clock.Advance(5 * time.Second)
+	tb.AllowN(tb.AvailableTokens())
+	if got := released(); got != 100 {
+		t.Fatalf("%d tokens released after the warm-up, want 100", got)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+)
+
+// WithMinSpacing holds allowed requests at least d apart, on top of the
+// token budget: even with tokens available, Allow denies and Wait blocks
+// until d has passed since the last request that consumed any.
+func WithMinSpacing(d time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.minSpacing = d
+	}
+}
+
+func (tb *TokenBucket) spacedLocked() bool {
+	return tb.spacingWaitLocked() == 0
+}
+
+// spacingWaitLocked is how long until the spacing allows another request.
+func (tb *TokenBucket) spacingWaitLocked() time.Duration {
+	if tb.minSpacing <= 0 || tb.lastAllowed.IsZero() {
+		return 0
+	}
+	return max(tb.lastAllowed.Add(tb.minSpacing).Sub(tb.clock.Now()), 0)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMinSpacingDeniesRapidRequests(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewManualClock(start)
+	tb := NewTokenBucket(1, 10, time.Hour, WithClock(clock), WithMinSpacing(100*time.Millisecond))
+	defer tb.Stop()
+
+	if !tb.Allow() {
+		t.Fatal("first request denied")
+	}
+	clock.Advance(99 * time.Millisecond)
+	if tb.Allow() {
+		t.Fatal("second request allowed inside the spacing with 9 tokens left")
+	}
+	var spacing *SpacingError
+	if err := tb.Take(1); !errors.As(err, &spacing) || !spacing.NextAllowed.Equal(start.Add(100*time.Millisecond)) {
+		t.Fatalf("Take(1) = %v, want a SpacingError for 100ms in", err)
+	}
+	if got := tb.AvailableTokens(); got != 9 {
+		t.Fatalf("%d tokens left, want 9: spaced-out denials must not consume", got)
+	}
+
+	clock.Advance(time.Millisecond)
+	if !tb.Allow() {
+		t.Fatal("request denied once the spacing was up")
+	}
+	// A zero-cost request is not spaced and doesn't restart the spacing.
+	clock.Advance(50 * time.Millisecond)
+	if !tb.AllowN(0) {
+		t.Fatal("AllowN(0) denied inside the spacing")
+	}
+	clock.Advance(50 * time.Millisecond)
+	if !tb.Allow() {
+		t.Fatal("AllowN(0) restarted the spacing")
+	}
+}
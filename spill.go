@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQueueFull is matched, with errors.Is, by the error a WaitN call gets
+// when WithSpillPolicy turned it away.
+var ErrQueueFull = errors.New("token bucket wait queue full")
+
+// WithSpillPolicy bounds the wait queue at maxWaiters. Once that many
+// callers are blocked in WaitN and the calls built on it, a newcomer does
+// not join them: it spills to a single non-blocking attempt, as Take, and
+// returns straight away, nil if it got its tokens and otherwise an error
+// matching ErrQueueFull that also wraps Take's reason for the denial. The
+// callers already queued keep their place and are served as before, so
+// overload costs newcomers a rejection rather than memory and latency.
+// Non-positive maxWaiters leaves the queue unbounded.
+func WithSpillPolicy(maxWaiters int) Option {
+	return func(tb *TokenBucket) {
+		tb.spillAt = maxWaiters
+	}
+}
+
+// spillLocked is the non-blocking attempt of a wait the queue is too full
+// for, reporting the tokens it took.
+func (tb *TokenBucket) spillLocked(n int64) (int64, error) {
+	err := tb.denyReasonLocked(n)
+	before := tb.consumed
+	if tb.allowLocked(n, 0) {
+		return tb.consumed - before, nil
+	}
+	return 0, fmt.Errorf("%w: %w", ErrQueueFull, err)
+}
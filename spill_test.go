@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSpillPolicyRejectsNewcomersToAFullQueue(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 5, time.Second, WithClock(clock), WithSpillPolicy(2), withoutRefillLog())
+	defer tb.Stop()
+	tb.AllowN(5)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- tb.WaitNContext(context.Background(), 2) }()
+	}
+	waitFor(t, func() bool { return queued(tb) == 2 })
+
+	err := tb.WaitN(1)
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("WaitN on a full queue = %v, want ErrQueueFull", err)
+	}
+	var empty *EmptyError
+	if !errors.As(err, &empty) || empty.Needed != 1 {
+		t.Fatalf("WaitN on a full queue = %v, want it to wrap an *EmptyError", err)
+	}
+	if n := queued(tb); n != 2 {
+		t.Fatalf("%d waiters queued, want the newcomer left out", n)
+	}
+
+	// A token arriving that the 2-token head can't use yet goes to a
+	// newcomer at once.
+	clock.Advance(time.Second)
+	if err := tb.WaitN(1); err != nil {
+		t.Fatalf("WaitN on a full queue with a token free = %v, want it served", err)
+	}
+	if n := queued(tb); n != 2 {
+		t.Fatalf("%d waiters queued after the spill", n)
+	}
+
+	// The queued callers are still served in turn.
+	for i := 0; i < 4; i++ {
+		clock.Advance(time.Second)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("queued waiter: %v", err)
+		}
+	}
+}
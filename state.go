@@ -0,0 +1,133 @@
+package main
+
+import (
+	"time"
+)
+
+type BucketState struct {
+	Config     Config    `json:"config"`
+	Tokens     int64     `json:"tokens"`
+	LastRefill time.Time `json:"last_refill"`
+	// Credited is how many of Tokens were released since LastRefill, ahead
+	// of the next refill, which only adds the rest.
+	Credited int64     `json:"credited,omitempty"`
+	LastSeen time.Time `json:"last_seen,omitempty"`
+}
+
+type ManagerState struct {
+	SavedAt time.Time              `json:"saved_at"`
+	Buckets map[string]BucketState `json:"buckets"`
+}
+
+func (tb *TokenBucket) State() BucketState {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	return BucketState{
+		Config:     tb.configLocked(),
+		Tokens:     tb.tokens,
+		LastRefill: tb.lastRefill,
+		Credited:   tb.credited,
+	}
+}
+
+// RestoreTokenBucket starts a bucket from a saved state, crediting every
+// refill that would have happened between LastRefill and now. Tokens already
+// released ahead of the first of those refills are not credited twice.
+func RestoreTokenBucket(s BucketState, opts ...Option) (*TokenBucket, error) {
+	if err := s.Config.Validate(); err != nil {
+		return nil, err
+	}
+
+	tb := newTokenBucketFromConfig(s.Config, opts...)
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tokens := s.Tokens
+	if tokens > tb.capacity {
+		tokens = tb.capacity
+	}
+	credited := min(max(s.Credited, 0), tb.rate)
+	lastRefill := s.LastRefill
+	if elapsed := tb.clock.Now().Sub(lastRefill); elapsed >= tb.interval && tb.rate > 0 {
+		ticks := int64(elapsed / tb.interval)
+		needed := (tb.capacity - tokens + credited + tb.rate - 1) / tb.rate
+		if ticks >= needed {
+			tokens = tb.capacity
+		} else {
+			tokens += ticks*tb.rate - credited
+		}
+		credited = 0
+		lastRefill = lastRefill.Add(time.Duration(ticks) * tb.interval)
+	}
+	tb.tokens = tokens
+	tb.lastRefill = lastRefill
+	tb.credited = credited
+	tb.publishLocked()
+
+	return tb, nil
+}
+
+func (m *LimiterManager) Export() ManagerState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := ManagerState{
+		SavedAt: m.clock.Now(),
+		Buckets: make(map[string]BucketState, len(m.buckets)),
+	}
+	for key, e := range m.buckets {
+		s := e.tb.State()
+		s.LastSeen = e.lastSeen
+		state.Buckets[key] = s
+	}
+
+	return state
+}
+
+// Import restores buckets from a state produced by Export, replacing any
+// bucket already held for the same key. Keys go through the manager's key
+// normalizer, as GetOrCreate's do, so a state exported before one was set
+// lands on the keys it will be looked up by; when several keys normalize
+// to one, the most recently seen wins. Trusted keys are skipped, since they
+// never get a bucket of their own. Keys that were idle for longer than the
+// manager's idle TTL are dropped, as are entries with an invalid config.
+// Past the WithMaxBuckets cap, only the most recently seen keys are kept. A
+// stopped manager imports nothing.
+func (m *LimiterManager) Import(state ManagerState) {
+	now := m.clock.Now()
+
+	latest := make(map[string]BucketState, len(state.Buckets))
+	for key, s := range state.Buckets {
+		key = m.normalizeKey(key)
+		if prev, ok := latest[key]; !ok || s.LastSeen.After(prev.LastSeen) {
+			latest[key] = s
+		}
+	}
+	trusted := m.trustedKeys.Load()
+	for key, s := range latest {
+		if trusted != nil {
+			if _, ok := (*trusted)[key]; ok {
+				continue
+			}
+		}
+		if m.idleTTL > 0 && now.Sub(s.LastSeen) > m.idleTTL {
+			continue
+		}
+		tb, err := RestoreTokenBucket(s, WithClock(m.clock))
+		if err != nil {
+			continue
+		}
+
+		m.mu.Lock()
+		if m.stopped {
+			m.mu.Unlock()
+			tb.Stop()
+			return
+		}
+		m.addLocked(key, &managedBucket{tb: tb, lastSeen: s.LastSeen})
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestManagerExportImportAccruesDowntime(t *testing.T) {
+	config := func(string) Config { return Config{Rate: 1, Capacity: 10, Interval: time.Second} }
+	clock := NewManualClock(time.Unix(1000, 0))
+	m := NewLimiterManager(config, WithManagerClock(clock))
+	m.GetOrCreate("idle").AllowN(8)
+	clock.Advance(time.Hour)
+	m.GetOrCreate("active").AllowN(10)
+
+	data, err := json.Marshal(m.Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.StopAll()
+
+	// Down for a little over three refills, which takes "idle" past the
+	// restored manager's idle TTL.
+	clock.Advance(3*time.Second + 100*time.Millisecond)
+
+	var state ManagerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewLimiterManager(config, WithManagerClock(clock), WithIdleTTL(time.Hour))
+	defer restored.StopAll()
+	restored.Import(state)
+
+	var keys []string
+	restored.ForEach(func(key string, _ *TokenBucket) { keys = append(keys, key) })
+	if len(keys) != 1 || keys[0] != "active" {
+		t.Fatalf("restored keys = %v, want only [active]", keys)
+	}
+	if got := restored.GetOrCreate("active").AvailableTokens(); got != 3 {
+		t.Fatalf("restored tokens = %d, want 3 refilled during the downtime", got)
+	}
+}
+
+func TestImportNormalizesKeysAndSkipsTrusted(t *testing.T) {
+	config := func(string) Config { return Config{Rate: 1, Capacity: 10, Interval: time.Hour} }
+	clock := NewManualClock(time.Unix(1000, 0))
+	old := NewLimiterManager(config, WithManagerClock(clock))
+	old.GetOrCreate("Alice").AllowN(2)
+	clock.Advance(time.Second)
+	old.GetOrCreate("ALICE").AllowN(6)
+	old.GetOrCreate("bob").AllowN(5)
+	state := old.Export()
+	old.StopAll()
+
+	// Restored into a manager that has since gained a normalizer and
+	// trusts bob.
+	m := NewLimiterManager(config, WithManagerClock(clock), WithKeyNormalizer(LowercaseKey))
+	defer m.StopAll()
+	m.Trust("bob")
+	m.Import(state)
+
+	var keys []string
+	m.ForEach(func(key string, _ *TokenBucket) { keys = append(keys, key) })
+	if len(keys) != 1 || keys[0] != "alice" {
+		t.Fatalf("restored keys = %v, want only [alice]", keys)
+	}
+	if got := m.GetOrCreate("Alice").AvailableTokens(); got != 4 {
+		t.Fatalf("alice has %d tokens, want 4 from the most recently seen variant", got)
+	}
+	if !m.GetOrCreate("bob").AllowN(10) {
+		t.Fatal("trusted key bob was limited by an imported bucket")
+	}
+}
+
+func TestRestoreDoesNotRecreditReleasedTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		downtime  time.Duration
+		wantAfter int64
+	}{
+		{"before the next refill", 500 * time.Millisecond, 9},
+		{"across the next refill", time.Second, 10},
+		{"across two refills", 11 * time.Second, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Unix(0, 0))
+			tb := NewTokenBucket(10, 30, 10*time.Second, WithClock(clock))
+			tb.AllowN(30)
+			// Nine tokens released since the last refill, ahead of it.
+			clock.Advance(9 * time.Second)
+			if got := tb.AvailableTokens(); got != 9 {
+				t.Fatalf("before export: %d tokens, want 9", got)
+			}
+			state := tb.State()
+			tb.Stop()
+
+			clock.Advance(tt.downtime)
+			restored, err := RestoreTokenBucket(state, WithClock(clock))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer restored.Stop()
+			if got := restored.AvailableTokens(); got != tt.wantAfter {
+				t.Fatalf("restored %v later: %d tokens, want %d", tt.downtime, got, tt.wantAfter)
+			}
+		})
+	}
+}
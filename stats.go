@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// InfDuration is returned by the TimeUntil methods when the requested level
+// can never be reached, e.g. on a fixed quota that will not refill.
+const InfDuration = time.Duration(math.MaxInt64)
+
+type Stats struct {
+	Name          string
+	Tokens        int64
+	Capacity      int64
+	Rate          int64
+	Interval      time.Duration
+	LastRefill    time.Time
+	TimeUntilFull time.Duration
+	TotalConsumed int64
+	// Allowed and Denied count decisions, including queued waiters served.
+	Allowed      int64
+	Denied       int64
+	DeniedBy     DenyCounts // Denied broken down by reason
+	LowWaterMark int64
+	LastAllowed  time.Time // zero if nothing has been allowed yet
+	LastDenied   time.Time // zero if nothing has been denied yet
+	Waits        WaitStats
+
+	// SustainableRate is Rate per Interval in tokens per second.
+	SustainableRate float64
+}
+
+func (tb *TokenBucket) Stats() Stats {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	return Stats{
+		Name:          tb.name,
+		Tokens:        tb.tokens,
+		Capacity:      tb.capacity,
+		Rate:          tb.rate,
+		Interval:      tb.interval,
+		LastRefill:    tb.lastRefill,
+		TimeUntilFull: tb.timeUntilLocked(tb.capacity),
+		TotalConsumed: tb.consumed,
+		Allowed:       tb.allowed,
+		Denied:        tb.denied,
+		DeniedBy:      tb.deniedBy,
+		LowWaterMark:  tb.lowWater,
+		LastAllowed:   tb.lastServed,
+		LastDenied:    tb.lastDenied,
+		Waits:         tb.waitStatsLocked(),
+
+		SustainableRate: tb.sustainableRateLocked(),
+	}
+}
+
+// SustainableRate is the refill rate in tokens per second: what the bucket
+// can keep up indefinitely, as opposed to the capacity it can burst to.
+// It is zero for a fixed quota.
+func (tb *TokenBucket) SustainableRate() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.sustainableRateLocked()
+}
+
+func (tb *TokenBucket) sustainableRateLocked() float64 {
+	if tb.rate <= 0 || tb.interval <= 0 {
+		return 0
+	}
+	return float64(tb.rate) / tb.interval.Seconds()
+}
+
+// LastAllowed is when a request was last allowed, by Allow, AllowN or any
+// other call that admits one, including a served waiter; it is the zero
+// Time if none has been. Denials leave it alone.
+func (tb *TokenBucket) LastAllowed() time.Time {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.lastServed
+}
+
+// LastDenied is when a request was last denied, or the zero Time if none
+// has been.
+func (tb *TokenBucket) LastDenied() time.Time {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.lastDenied
+}
+
+// LowWaterMark is the fewest tokens the bucket has held right after a
+// consume since it was created or ResetWaterMarks was last called. Near
+// zero, callers are close to being throttled; near capacity, the capacity
+// has plenty of headroom.
+func (tb *TokenBucket) LowWaterMark() int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.lowWater
+}
+
+// ResetWaterMarks starts a new observation window from the current balance.
+func (tb *TokenBucket) ResetWaterMarks() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	tb.lowWater = tb.tokens
+	tb.publishSnapshotLocked()
+}
+
+// TimeUntilAvailable reports how long until AllowN(n) could succeed,
+// assuming nothing else consumes tokens in the meantime.
+func (tb *TokenBucket) TimeUntilAvailable(n int64) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	n = tb.costLocked(n)
+	if n > tb.capacity && !tb.bypassLocked() {
+		return InfDuration
+	}
+	return tb.timeUntilTakeLocked(n)
+}
+
+// timeUntilTakeLocked is timeUntilLocked for consuming n tokens, which the
+// minimum spacing can hold up further.
+func (tb *TokenBucket) timeUntilTakeLocked(n int64) time.Duration {
+	d := tb.timeUntilLocked(n)
+	if n > 0 && !tb.bypassLocked() {
+		d = max(d, tb.spacingWaitLocked())
+	}
+	return d
+}
+
+// TimeUntilFull reports how long until the bucket is back at capacity, or
+// zero if it already is. Add it to the current time to get the moment the
+// bucket will be full.
+func (tb *TokenBucket) TimeUntilFull() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	return tb.timeUntilLocked(tb.capacity)
+}
+
+// timeUntilLocked reports how long until n tokens will be in the bucket,
+// from refills or a scheduled reset, whichever comes first. The caller must
+// have accrued first.
+func (tb *TokenBucket) timeUntilLocked(n int64) time.Duration {
+	if n-tb.tokens <= 0 || tb.bypassLocked() {
+		return 0
+	}
+	d := tb.refillTimeUntilLocked(n)
+	if !tb.resetAt.IsZero() && n <= tb.capacity {
+		d = min(d, max(tb.resetAt.Sub(tb.clock.Now()), 0))
+	}
+	return d
+}
+
+// refillTimeUntilLocked inverts the accrual model: a refill lands every
+// interval after lastRefill, and between refills the rounding mode releases
+// its tokens early as they are earned.
+func (tb *TokenBucket) refillTimeUntilLocked(n int64) time.Duration {
+	deficit := n - tb.tokens
+	if tb.curve != nil {
+		return tb.curveTimeUntilLocked(deficit)
+	}
+	if tb.rate <= 0 {
+		return InfDuration
+	}
+
+	// m is the m-th token counted from lastRefill; it is earned during the
+	// refill period starting after k full intervals, as the r-th of rate.
+	m := tb.credited + deficit
+	k := (m - 1) / tb.rate
+	r := m - k*tb.rate
+	offset := tb.rounding.earnedAt(r, tb.rate, tb.interval) - tb.phaseOffset()
+	if offset > tb.interval {
+		// Not earned before the refill, which delivers it anyway.
+		offset = tb.interval
+	}
+	at := tb.lastRefill.Add(time.Duration(k)*tb.interval + offset)
+	if d := at.Sub(tb.clock.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// MarshalJSON renders durations as strings such as "2s", which operators
+// can read at a glance, rather than nanosecond counts. A duration that will
+// never elapse is rendered as "inf".
+func (s Stats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name          string     `json:"name"`
+		Tokens        int64      `json:"tokens"`
+		Capacity      int64      `json:"capacity"`
+		Rate          int64      `json:"rate"`
+		Interval      string     `json:"interval"`
+		Sustainable   float64    `json:"sustainable_rate"`
+		LastRefill    time.Time  `json:"last_refill"`
+		TimeUntilFull string     `json:"time_until_full"`
+		TotalConsumed int64      `json:"total_consumed"`
+		Allowed       int64      `json:"allowed"`
+		Denied        int64      `json:"denied"`
+		DeniedBy      DenyCounts `json:"denied_by"`
+		LowWaterMark  int64      `json:"low_water_mark"`
+		LastAllowed   time.Time  `json:"last_allowed,omitzero"`
+		LastDenied    time.Time  `json:"last_denied,omitzero"`
+		Waits         WaitStats  `json:"waits"`
+	}{
+		Name:          s.Name,
+		Tokens:        s.Tokens,
+		Capacity:      s.Capacity,
+		Rate:          s.Rate,
+		Interval:      durationString(s.Interval),
+		Sustainable:   s.SustainableRate,
+		LastRefill:    s.LastRefill,
+		TimeUntilFull: durationString(s.TimeUntilFull),
+		TotalConsumed: s.TotalConsumed,
+		Allowed:       s.Allowed,
+		Denied:        s.Denied,
+		DeniedBy:      s.DeniedBy,
+		LowWaterMark:  s.LowWaterMark,
+		LastAllowed:   s.LastAllowed,
+		LastDenied:    s.LastDenied,
+		Waits:         s.Waits,
+	})
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("Stats{name=%s, tokens=%d/%d, rate=%d/%s, allowed=%d, denied=%d %s, waiting=%d}",
+		s.Name, s.Tokens, s.Capacity, s.Rate, s.Interval, s.Allowed, s.Denied, s.DeniedBy, s.Waits.Waiting)
+}
+
+func durationString(d time.Duration) string {
+	if d == InfDuration {
+		return "inf"
+	}
+	return d.String()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeUntilFull(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(2, 10, 10*time.Second, WithClock(clock))
+	defer tb.Stop()
+
+	if d := tb.TimeUntilFull(); d != 0 {
+		t.Fatalf("full bucket: TimeUntilFull() = %v, want 0", d)
+	}
+
+	// Four tokens short at one token every 5s.
+	tb.AllowN(4)
+	if d := tb.TimeUntilFull(); d != 20*time.Second {
+		t.Fatalf("TimeUntilFull() = %v, want 20s", d)
+	}
+	clock.Advance(3 * time.Second)
+	if d := tb.TimeUntilFull(); d != 17*time.Second {
+		t.Fatalf("after 3s: TimeUntilFull() = %v, want 17s", d)
+	}
+	if d := tb.Stats().TimeUntilFull; d != 17*time.Second {
+		t.Fatalf("Stats().TimeUntilFull = %v, want 17s", d)
+	}
+	clock.Advance(17 * time.Second)
+	if d := tb.TimeUntilFull(); d != 0 {
+		t.Fatalf("refilled: TimeUntilFull() = %v, want 0", d)
+	}
+}
+
+func TestTimeUntilFullFixedQuota(t *testing.T) {
+	tb := NewTokenBucket(0, 10, time.Second)
+	defer tb.Stop()
+	tb.Allow()
+	if d := tb.TimeUntilFull(); d != InfDuration {
+		t.Fatalf("fixed quota: TimeUntilFull() = %v, want InfDuration", d)
+	}
+}
+
+func TestTotalConsumedCountsGrantedTokens(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+
+	steps := []struct {
+		n       int64
+		allowed bool
+		total   int64
+	}{
+		{3, true, 3},
+		{5, true, 8},
+		{4, false, 8},
+		{2, true, 10},
+		{1, false, 10},
+	}
+	for _, s := range steps {
+		if got := tb.AllowN(s.n); got != s.allowed {
+			t.Fatalf("AllowN(%d) = %v, want %v", s.n, got, s.allowed)
+		}
+		if got := tb.TotalConsumed(); got != s.total {
+			t.Fatalf("after AllowN(%d): TotalConsumed() = %d, want %d", s.n, got, s.total)
+		}
+	}
+
+	if err := tb.Reconfigure(Config{Rate: 5, Capacity: 20, Interval: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if got := tb.Stats().TotalConsumed; got != 10 {
+		t.Fatalf("after Reconfigure: Stats().TotalConsumed = %d, want 10", got)
+	}
+}
+
+func TestLowWaterMark(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+
+	if got := tb.LowWaterMark(); got != 10 {
+		t.Fatalf("fresh bucket: LowWaterMark() = %d, want 10", got)
+	}
+	tb.AllowN(5)
+	tb.AllowN(3)
+	tb.Refund(6)
+	if got := tb.LowWaterMark(); got != 2 {
+		t.Fatalf("after draining to 2: LowWaterMark() = %d, want 2", got)
+	}
+	if got := tb.Stats().LowWaterMark; got != 2 {
+		t.Fatalf("Stats().LowWaterMark = %d, want 2", got)
+	}
+
+	tb.ResetWaterMarks()
+	if got := tb.LowWaterMark(); got != 8 {
+		t.Fatalf("after reset: LowWaterMark() = %d, want the current 8", got)
+	}
+	tb.AllowN(1)
+	if got := tb.LowWaterMark(); got != 7 {
+		t.Fatalf("LowWaterMark() = %d, want 7", got)
+	}
+}
+
+func TestStatsJSON(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	tb := NewTokenBucket(1, 10, 2*time.Second, WithClock(clock), WithName("api"))
+	defer tb.Stop()
+	tb.AllowN(4)
+
+	data, err := json.Marshal(tb.Stats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"name":             "api",
+		"tokens":           6.0,
+		"capacity":         10.0,
+		"rate":             1.0,
+		"interval":         "2s",
+		"sustainable_rate": 0.5,
+		"last_refill":      "2024-01-02T03:04:05Z",
+		"time_until_full":  "8s",
+		"total_consumed":   4.0,
+		"allowed":          1.0,
+		"denied":           0.0,
+		"denied_by": map[string]any{
+			"empty": 0.0, "over_capacity": 0.0, "cooldown": 0.0,
+			"spacing": 0.0, "draining": 0.0, "stopped": 0.0,
+			"floor": 0.0, "watermark": 0.0, "early_drop": 0.0, "policy": 0.0,
+		},
+		"low_water_mark": 6.0,
+		"last_allowed":   "2024-01-02T03:04:05Z",
+		"waits": map[string]any{
+			"waiting":     0.0,
+			"max_waiting": 0.0,
+			"completed":   0.0,
+			"total_wait":  "0s",
+			"max_wait":    "0s",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Stats JSON = %s\nwant %v", data, want)
+	}
+}
+
+func TestStatsDeniedByJSON(t *testing.T) {
+	tb := NewTokenBucket(0, 1, time.Second)
+	defer tb.Stop()
+	tb.Allow()
+	tb.Allow()
+	tb.Allow()
+	tb.AllowN(5)
+
+	data, err := json.Marshal(tb.Stats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		DeniedBy map[string]int64 `json:"denied_by"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.DeniedBy["empty"] != 2 || got.DeniedBy["over_capacity"] != 1 || got.DeniedBy["spacing"] != 0 {
+		t.Fatalf("denied_by = %v, want empty 2, over_capacity 1, spacing 0", got.DeniedBy)
+	}
+}
+
+func TestStatsStringDeniedBy(t *testing.T) {
+	tb := NewTokenBucket(0, 1, time.Second, WithName("api"))
+	defer tb.Stop()
+	tb.Allow()
+	tb.Allow()
+	tb.AllowN(5)
+
+	got := tb.Stats().String()
+	if !strings.Contains(got, "denied=2 {empty=1 over_capacity=1}") {
+		t.Fatalf("String() = %q, want the denials broken down by reason", got)
+	}
+}
+
+func TestStatsJSONInfiniteDuration(t *testing.T) {
+	tb := NewTokenBucket(0, 10, time.Second)
+	defer tb.Stop()
+	tb.Allow()
+
+	data, err := json.Marshal(tb.Stats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"time_until_full":"inf"`) {
+		t.Fatalf("Stats JSON = %s, want time_until_full inf", data)
+	}
+}
+
+func TestLastAllowedOnlyMovesOnSuccess(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewManualClock(start)
+	tb := NewTokenBucket(1, 2, time.Hour, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+
+	if !tb.LastAllowed().IsZero() || !tb.LastDenied().IsZero() {
+		t.Fatalf("fresh bucket: LastAllowed %v, LastDenied %v; want both zero", tb.LastAllowed(), tb.LastDenied())
+	}
+	clock.Advance(time.Second)
+	tb.Allow()
+	clock.Advance(time.Second)
+	tb.AllowN(1)
+	allowedAt := start.Add(2 * time.Second)
+
+	clock.Advance(time.Second)
+	tb.Allow()
+	tb.AllowN(5)
+	deniedAt := start.Add(3 * time.Second)
+	if got := tb.LastAllowed(); !got.Equal(allowedAt) {
+		t.Fatalf("LastAllowed = %v after denials, want the last success at %v", got, allowedAt)
+	}
+	if got := tb.LastDenied(); !got.Equal(deniedAt) {
+		t.Fatalf("LastDenied = %v, want %v", got, deniedAt)
+	}
+	if s := tb.Stats(); !s.LastAllowed.Equal(allowedAt) || !s.LastDenied.Equal(deniedAt) {
+		t.Fatalf("Stats LastAllowed %v, LastDenied %v; want %v, %v", s.LastAllowed, s.LastDenied, allowedAt, deniedAt)
+	}
+}
+
+func TestSustainableRate(t *testing.T) {
+	for _, tc := range []struct {
+		rate     int64
+		interval time.Duration
+		want     float64
+	}{
+		{1, 2 * time.Second, 0.5},
+		{30, time.Minute, 0.5},
+		{100, time.Second, 100},
+		{3, 1500 * time.Millisecond, 2},
+		{0, time.Second, 0},
+	} {
+		tb := NewTokenBucket(tc.rate, 10, tc.interval, withoutRefillLog())
+		if got := tb.SustainableRate(); got != tc.want {
+			t.Errorf("%d per %v: SustainableRate() = %v, want %v", tc.rate, tc.interval, got, tc.want)
+		}
+		if got := tb.Stats().SustainableRate; got != tc.want {
+			t.Errorf("%d per %v: Stats().SustainableRate = %v, want %v", tc.rate, tc.interval, got, tc.want)
+		}
+		tb.Stop()
+	}
+
+	tb := NewTokenBucket(1, 10, time.Second, withoutRefillLog())
+	defer tb.Stop()
+	tb.Reconfigure(Config{Rate: 5, Capacity: 10, Interval: 250 * time.Millisecond})
+	if got := tb.SustainableRate(); got != 20 {
+		t.Fatalf("after Reconfigure to 5 per 250ms: SustainableRate() = %v, want 20", got)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+// Suspend turns limiting off until Resume, e.g. for a maintenance window:
+// every Allow succeeds without consuming anything and blocked waiters are
+// released. Refills carry on as usual in the meantime, so after Resume the
+// bucket holds whatever it would have accrued, up to capacity. Unlike
+// Config.Disabled, this survives Reconfigure.
+func (tb *TokenBucket) Suspend() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	tb.suspended = true
+	tb.serveWaitersLocked()
+	tb.notifyWatchersLocked()
+}
+
+// Resume ends a Suspend, enforcing limits again from the current balance.
+func (tb *TokenBucket) Resume() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.suspended = false
+}
+
+// bypassLocked reports whether limiting is off, by config or Suspend.
+func (tb *TokenBucket) bypassLocked() bool {
+	return tb.disabled || tb.suspended
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSuspendAllowsAllAndResumeRestoresLimits(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 5, time.Second, WithClock(clock))
+	defer tb.Stop()
+	tb.AllowN(4)
+
+	done := make(chan error, 1)
+	go func() { done <- tb.WaitN(3) }()
+	waitForQueue(t, tb, 1)
+
+	tb.Suspend()
+	if err := <-done; err != nil {
+		t.Fatalf("blocked WaitN(3) = %v after Suspend, want nil", err)
+	}
+	for i := 0; i < 100; i++ {
+		if !tb.Allow() {
+			t.Fatalf("request %d denied while suspended", i)
+		}
+	}
+	if err := tb.Reconfigure(Config{Rate: 1, Capacity: 5, Interval: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if !tb.AllowN(5) {
+		t.Fatal("denied while suspended after Reconfigure")
+	}
+	// Refills carry on while suspended.
+	clock.Advance(2 * time.Second)
+
+	tb.Resume()
+	if got := tb.AvailableTokens(); got != 3 {
+		t.Fatalf("after Resume: %d tokens, want 3", got)
+	}
+	tb.AllowN(3)
+	if tb.Allow() {
+		t.Fatal("allowed from an empty bucket after Resume")
+	}
+}
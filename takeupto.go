@@ -0,0 +1,36 @@
+package main
+
+// TakeUpTo is the work-maximizing counterpart of AllowN for batches: rather
+// than all or nothing, it takes as many tokens as the bucket holds, up to
+// requested, and returns how many, possibly none. The caller then does
+// exactly granted items' worth of work. Deciding and taking happen under
+// one lock, so concurrent callers never grant more than the bucket held
+// between them. A grant is a single decision, counted as allowed, and
+// subject to the same minimum charge, spacing and early drop as AllowN of
+// that many; when it is turned down, or nothing is available, the call is
+// counted as a denial of requested and grants 0. While limiting is bypassed
+// it grants all of requested. A negative request grants nothing.
+func (tb *TokenBucket) TakeUpTo(requested int64) (granted int64) {
+	if requested <= 0 {
+		return 0
+	}
+	if tb.unset() {
+		return requested
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.accrueLocked()
+	granted = requested
+	if !tb.bypassLocked() && !tb.failOpenLocked() {
+		granted = min(requested, max(tb.tokens, 0))
+	}
+	if granted == 0 {
+		tb.denyLocked(tb.costLocked(requested), denyInferred)
+		return 0
+	}
+	if !tb.allowLocked(tb.costLocked(granted), 0) {
+		return 0
+	}
+	return granted
+}
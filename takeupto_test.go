@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTakeUpToGrantsWhatIsAvailable(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+	tb.AllowN(3)
+
+	if got := tb.TakeUpTo(5); got != 5 {
+		t.Fatalf("TakeUpTo(5) with 7 tokens = %d, want 5", got)
+	}
+	if got := tb.TakeUpTo(5); got != 2 {
+		t.Fatalf("TakeUpTo(5) with 2 tokens = %d, want 2", got)
+	}
+	if got := tb.TakeUpTo(5); got != 0 {
+		t.Fatalf("TakeUpTo(5) with none left = %d, want 0", got)
+	}
+	if got := tb.TakeUpTo(-1); got != 0 {
+		t.Fatalf("TakeUpTo(-1) = %d, want 0", got)
+	}
+	if s := tb.Stats(); s.Allowed != 3 || s.Denied != 1 || s.TotalConsumed != 10 {
+		t.Fatalf("allowed %d, denied %d, consumed %d; want 3, 1, 10", s.Allowed, s.Denied, s.TotalConsumed)
+	}
+}
+
+func TestTakeUpToConcurrentGrantsNeverExceedTheBalance(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		tb := NewTokenBucket(1, 7, time.Hour, withoutRefillLog())
+		grants := make(chan int64, 2)
+		var wg sync.WaitGroup
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				grants <- tb.TakeUpTo(5)
+			}()
+		}
+		wg.Wait()
+		tb.Stop()
+		a, b := <-grants, <-grants
+		if a+b != 7 || max(a, b) != 5 {
+			t.Fatalf("concurrent TakeUpTo(5) on 7 tokens granted %d and %d, want 5 and 2", a, b)
+		}
+	}
+}
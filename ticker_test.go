@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeTicker struct {
+	c       chan time.Time
+	mu      sync.Mutex
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+}
+
+func (t *fakeTicker) isStopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stopped
+}
+
+func TestWithTickerDeliversExactlyTheTicksSent(t *testing.T) {
+	var mu sync.Mutex
+	var tickers []*fakeTicker
+	var intervals []time.Duration
+	newFake := func(d time.Duration) Ticker {
+		mu.Lock()
+		defer mu.Unlock()
+		ft := &fakeTicker{c: make(chan time.Time)}
+		tickers = append(tickers, ft)
+		intervals = append(intervals, d)
+		return ft
+	}
+	current := func() *fakeTicker {
+		mu.Lock()
+		defer mu.Unlock()
+		return tickers[len(tickers)-1]
+	}
+
+	tb := NewTokenBucket(2, 10, time.Hour, WithTicker(newFake), withoutRefillLog())
+	tb.AllowN(10)
+	for i := 0; i < 3; i++ {
+		current().c <- time.Now()
+	}
+	waitFor(t, func() bool { return tb.AvailableTokens() == 6 })
+	time.Sleep(10 * time.Millisecond)
+	if got := tb.AvailableTokens(); got != 6 {
+		t.Fatalf("tokens = %d after three ticks, want 6", got)
+	}
+
+	first := current()
+	tb.Reconfigure(Config{Rate: 2, Capacity: 10, Interval: 2 * time.Hour})
+	if !first.isStopped() || current() == first {
+		t.Fatal("changing the interval did not replace the ticker")
+	}
+	current().c <- time.Now()
+	waitFor(t, func() bool { return tb.AvailableTokens() == 8 })
+
+	tb.Stop()
+	waitFor(t, current().isStopped)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(intervals) != 2 || intervals[0] != time.Hour || intervals[1] != 2*time.Hour {
+		t.Fatalf("tickers made for intervals %v, want [1h 2h]", intervals)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+)
+
+// TraceEvent describes one decision for WithTracing. Key is the bucket's
+// name, and Remaining its balance right after the decision.
+type TraceEvent struct {
+	Key       string
+	N         int64
+	Allowed   bool
+	Remaining int64
+}
+
+// WithTracing reports every AllowNCtx decision, and every request the
+// middleware decides on, to annotate along with the caller's context, so
+// the decision can be recorded on the current span. The package has no
+// tracing dependency of its own; with OpenTelemetry, for instance:
+//
+//	WithTracing(func(ctx context.Context, ev TraceEvent) {
+//		trace.SpanFromContext(ctx).SetAttributes(
+//			attribute.Bool("ratelimit.allowed", ev.Allowed),
+//			attribute.Int64("ratelimit.remaining", ev.Remaining),
+//			attribute.String("ratelimit.key", ev.Key),
+//		)
+//	})
+//
+// annotate runs after the bucket's lock is released. Without this option
+// AllowNCtx costs the same as AllowN.
+func WithTracing(annotate func(ctx context.Context, ev TraceEvent)) Option {
+	return func(tb *TokenBucket) {
+		tb.tracer = annotate
+	}
+}
+
+// AllowNCtx is AllowN for callers that have a context to trace the
+// decision against; see WithTracing. The context does not affect the
+// decision.
+func (tb *TokenBucket) AllowNCtx(ctx context.Context, n int64) bool {
+	if tb.unset() {
+		return n >= 0
+	}
+	if tb.tracer == nil {
+		return tb.AllowN(n)
+	}
+
+	tb.mu.Lock()
+	tb.accrueLocked()
+	ok := tb.allowLocked(tb.costLocked(n), 0)
+	remaining := tb.tokens
+	tb.mu.Unlock()
+
+	tb.trace(ctx, n, ok, remaining)
+	return ok
+}
+
+func (tb *TokenBucket) trace(ctx context.Context, n int64, ok bool, remaining int64) {
+	if tb.tracer != nil {
+		tb.tracer(ctx, TraceEvent{Key: tb.name, N: n, Allowed: ok, Remaining: remaining})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordedSpan stands in for a tracing span, collecting the attributes the
+// annotate function sets on it.
+type recordedSpan struct {
+	attrs []map[string]any
+}
+
+type spanKey struct{}
+
+func spanAnnotator(ctx context.Context, ev TraceEvent) {
+	span, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	if span == nil {
+		return
+	}
+	span.attrs = append(span.attrs, map[string]any{
+		"ratelimit.allowed":   ev.Allowed,
+		"ratelimit.remaining": ev.Remaining,
+		"ratelimit.key":       ev.Key,
+	})
+}
+
+func TestAllowNCtxAnnotatesSpan(t *testing.T) {
+	tb := NewTokenBucket(1, 3, time.Hour, WithName("search"), WithTracing(spanAnnotator))
+	defer tb.Stop()
+	span := &recordedSpan{}
+	ctx := context.WithValue(context.Background(), spanKey{}, span)
+
+	tb.AllowNCtx(ctx, 2)
+	tb.AllowNCtx(ctx, 2)
+	tb.AllowNCtx(ctx, 1)
+
+	want := []struct {
+		allowed   bool
+		remaining int64
+	}{{true, 1}, {false, 1}, {true, 0}}
+	if len(span.attrs) != len(want) {
+		t.Fatalf("%d decisions recorded, want %d", len(span.attrs), len(want))
+	}
+	for i, w := range want {
+		got := span.attrs[i]
+		if got["ratelimit.allowed"] != w.allowed || got["ratelimit.remaining"] != w.remaining || got["ratelimit.key"] != "search" {
+			t.Fatalf("decision %d recorded %v, want allowed=%v remaining=%d key=search", i, got, w.allowed, w.remaining)
+		}
+	}
+}
+
+func TestMiddlewareAnnotatesRequestSpan(t *testing.T) {
+	captureLog(t)
+	tb := NewTokenBucket(1, 1, time.Hour, WithName("api"), WithTracing(spanAnnotator))
+	defer tb.Stop()
+	h := Middleware(tb)(statusHandler(http.StatusOK))
+
+	span := &recordedSpan{}
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		h.ServeHTTP(httptest.NewRecorder(), req.WithContext(context.WithValue(req.Context(), spanKey{}, span)))
+	}
+	if len(span.attrs) != 2 || span.attrs[0]["ratelimit.allowed"] != true || span.attrs[1]["ratelimit.allowed"] != false {
+		t.Fatalf("recorded %v, want one allowed and one denied decision", span.attrs)
+	}
+}
+
+func TestAllowNCtxWithoutTracingDoesNotAllocate(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+	ctx := context.WithValue(context.Background(), spanKey{}, &recordedSpan{})
+
+	if allocs := testing.AllocsPerRun(1000, func() { tb.AllowNCtx(ctx, 1) }); allocs != 0 {
+		t.Fatalf("AllowNCtx allocates %v times per call", allocs)
+	}
+}
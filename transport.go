@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrWaitTooLong is returned, wrapped, by a Transport whose request would
+// have had to wait longer than WithMaxWait allows for a token. Match it
+// with errors.Is.
+var ErrWaitTooLong = errors.New("token not available within the maximum wait")
+
+type transportConfig struct {
+	maxWait time.Duration
+}
+
+type TransportOption func(*transportConfig)
+
+// WithMaxWait makes the Transport fail a request at once with
+// ErrWaitTooLong when its token is not expected within maxWait, counting
+// the requests queued ahead of it, rather than hold it for the whole wait;
+// the caller's retry or backoff can take it from there. Zero, the default,
+// waits as long as it takes.
+func WithMaxWait(maxWait time.Duration) TransportOption {
+	return func(c *transportConfig) {
+		c.maxWait = maxWait
+	}
+}
+
+// NewTransport throttles outbound requests: each one waits for a token from
+// tb, in turn with the other waiters, before it is sent through next, or
+// http.DefaultTransport if next is nil. A request whose context ends while
+// it waits fails with the context's error and is not sent.
+func NewTransport(tb *TokenBucket, next http.RoundTripper, opts ...TransportOption) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	t := &transport{tb: tb, next: next}
+	for _, opt := range opts {
+		opt(&t.cfg)
+	}
+	return t
+}
+
+type transport struct {
+	tb   *TokenBucket
+	next http.RoundTripper
+	cfg  transportConfig
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, err := t.tb.ReserveN(1)
+	if err != nil {
+		return nil, err
+	}
+	if t.cfg.maxWait > 0 {
+		if d := r.Delay(); d > t.cfg.maxWait {
+			r.Cancel()
+			return nil, fmt.Errorf("%w: %s %s needs %s, more than %s", ErrWaitTooLong, req.Method, req.URL, durationString(d), t.cfg.maxWait)
+		}
+	}
+	if err := r.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func okTransport(sent *int) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		*sent++
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})
+}
+
+func TestTransportMaxWaitFailsFast(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Minute, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	tb.Allow()
+
+	var sent int
+	client := &http.Client{Transport: NewTransport(tb, okTransport(&sent), WithMaxWait(10*time.Millisecond))}
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Get("http://example.test/")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrWaitTooLong) {
+			t.Fatalf("error = %v, want ErrWaitTooLong", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RoundTrip blocked instead of failing fast")
+	}
+	if sent != 0 {
+		t.Fatalf("%d requests sent, want none", sent)
+	}
+	if n := queued(tb); n != 0 {
+		t.Fatalf("%d waiters left queued after failing fast", n)
+	}
+}
+
+func TestTransportWaitsWithinMaxWait(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Minute, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	tb.Allow()
+
+	for _, maxWait := range []time.Duration{time.Hour, 0} {
+		var sent int
+		rt := NewTransport(tb, okTransport(&sent), WithMaxWait(maxWait))
+		req, _ := http.NewRequest(http.MethodGet, "http://example.test/", nil)
+		done := make(chan error, 1)
+		go func() {
+			resp, err := rt.RoundTrip(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			done <- err
+		}()
+		waitFor(t, func() bool { return queued(tb) == 1 })
+		clock.Advance(time.Minute)
+		if err := <-done; err != nil || sent != 1 {
+			t.Fatalf("maxWait %v: error %v, %d sent; want the request sent once its token came", maxWait, err, sent)
+		}
+	}
+}
+
+func TestTransportGivesUpWhenTheContextEnds(t *testing.T) {
+	tb := NewTokenBucket(1, 1, time.Hour, withoutRefillLog())
+	defer tb.Stop()
+	tb.Allow()
+
+	var sent int
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.test/", nil)
+	go func() {
+		for queued(tb) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if _, err := NewTransport(tb, okTransport(&sent)).RoundTrip(req); !errors.Is(err, context.Canceled) || sent != 0 {
+		t.Fatalf("error %v, %d sent; want context.Canceled and nothing sent", err, sent)
+	}
+	if n := queued(tb); n != 0 {
+		t.Fatalf("%d waiters left queued", n)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+)
+
+// UsageSnapshot is the activity of one reporting period.
+type UsageSnapshot struct {
+	Since    time.Time // end of the previous period, or the bucket's creation
+	Until    time.Time
+	Consumed int64
+	Allowed  int64
+	Denied   int64
+}
+
+// SnapshotAndResetUsage returns the tokens consumed and decisions made since
+// the previous call, and starts a new period, all under one lock so that
+// nothing is lost or counted twice between consecutive periods. The
+// lifetime counters behind TotalConsumed and Stats are not affected.
+func (tb *TokenBucket) SnapshotAndResetUsage() UsageSnapshot {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := UsageSnapshot{Since: tb.usageMark.Until, Until: tb.clock.Now(), Consumed: tb.consumed, Allowed: tb.allowed, Denied: tb.denied}
+	if now.Since.IsZero() {
+		now.Since = tb.createdAt
+	}
+	mark := tb.usageMark
+	tb.usageMark = now
+
+	now.Consumed -= mark.Consumed
+	now.Allowed -= mark.Allowed
+	now.Denied -= mark.Denied
+	return now
+}
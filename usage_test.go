@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotAndResetUsageLosesNothingUnderLoad(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 30000, time.Hour, WithClock(clock))
+	defer tb.Stop()
+
+	const workers, calls = 8, 5000
+	var allowed, denied, consumed atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				if tb.AllowN(2) {
+					allowed.Add(1)
+					consumed.Add(2)
+				} else {
+					denied.Add(1)
+				}
+			}
+		}()
+	}
+
+	var periods []UsageSnapshot
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		clock.Advance(time.Millisecond)
+		periods = append(periods, tb.SnapshotAndResetUsage())
+	}
+	periods = append(periods, tb.SnapshotAndResetUsage())
+
+	var sum UsageSnapshot
+	for i, p := range periods {
+		if i > 0 && !p.Since.Equal(periods[i-1].Until) {
+			t.Fatalf("period %d starts at %v, the previous one ended at %v", i, p.Since, periods[i-1].Until)
+		}
+		sum.Consumed += p.Consumed
+		sum.Allowed += p.Allowed
+		sum.Denied += p.Denied
+	}
+	if sum.Consumed != consumed.Load() || sum.Allowed != allowed.Load() || sum.Denied != denied.Load() {
+		t.Fatalf("periods add up to consumed %d, allowed %d, denied %d; want %d, %d, %d",
+			sum.Consumed, sum.Allowed, sum.Denied, consumed.Load(), allowed.Load(), denied.Load())
+	}
+	if denied.Load() == 0 {
+		t.Fatal("the bucket never ran dry; the test exercised no denials")
+	}
+	if last := periods[len(periods)-1]; last.Consumed != 0 || last.Allowed != 0 || last.Denied != 0 {
+		t.Fatalf("a snapshot with no activity since the last one: %+v", last)
+	}
+	if got := tb.TotalConsumed(); got != consumed.Load() {
+		t.Fatalf("TotalConsumed = %d after resets, want the lifetime %d", got, consumed.Load())
+	}
+}
+
+func TestSnapshotAndResetUsageFirstPeriodStartsAtCreation(t *testing.T) {
+	start := time.Unix(100, 0)
+	clock := NewManualClock(start)
+	tb := NewTokenBucket(1, 5, time.Hour, WithClock(clock))
+	defer tb.Stop()
+
+	tb.AllowN(3)
+	tb.AllowN(3)
+	clock.Advance(time.Minute)
+	got := tb.SnapshotAndResetUsage()
+	want := UsageSnapshot{Since: start, Until: start.Add(time.Minute), Consumed: 3, Allowed: 1, Denied: 1}
+	if got != want {
+		t.Fatalf("first snapshot = %+v, want %+v", got, want)
+	}
+}
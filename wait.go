@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+var (
+	ErrTokensExceedCapacity = errors.New("requested tokens exceed bucket capacity")
+	ErrStopped              = errors.New("token bucket stopped")
+	ErrNegativeTokens       = errors.New("token count must not be negative")
+	ErrDraining             = errors.New("token bucket draining")
+)
+
+type waiter struct {
+	n        int64
+	charged  int64 // tokens actually taken when served
+	ready    chan struct{}
+	done     bool
+	err      error
+	prio     int
+	level    bool // waiting for a level, not to consume
+	enqueued time.Time
+}
+
+// WaitStats describes the queue of goroutines blocked in WaitN. Waits that
+// were served without queueing are not counted.
+type WaitStats struct {
+	Waiting    int           // blocked right now
+	MaxWaiting int           // deepest the queue has been
+	Completed  int64         // queued waits that have finished, however they ended
+	TotalWait  time.Duration // summed over Completed
+	MaxWait    time.Duration
+}
+
+func (s WaitStats) MeanWait() time.Duration {
+	if s.Completed == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Completed)
+}
+
+func newWaiter(n int64) *waiter {
+	return &waiter{n: n, ready: make(chan struct{})}
+}
+
+func (w *waiter) resolveLocked(err error) {
+	w.done = true
+	w.err = err
+	close(w.ready)
+}
+
+func (tb *TokenBucket) Wait() error {
+	return tb.WaitNContext(context.Background(), 1)
+}
+
+func (tb *TokenBucket) WaitContext(ctx context.Context) error {
+	return tb.WaitNContext(ctx, 1)
+}
+
+func (tb *TokenBucket) WaitN(n int64) error {
+	return tb.WaitNContext(context.Background(), n)
+}
+
+// WaitNContext blocks until n tokens can be consumed or ctx is done. Waiters
+// are served in arrival order as refills arrive; non-blocking Allow calls
+// are not queued and may take tokens ahead of them. A wait that ctx ends
+// just as its tokens arrive either takes them and returns nil or leaves
+// them and returns ctx's error, never both. A request for more than
+// the bucket's capacity can never succeed and fails with an
+// *ExceedsCapacityError, which matches ErrTokensExceedCapacity.
+func (tb *TokenBucket) WaitNContext(ctx context.Context, n int64) error {
+	return tb.WaitNPriority(ctx, n, 0)
+}
+
+// WaitNPriority is WaitNContext with a priority: queued waiters are served
+// highest prio first, and in arrival order within a priority. WaitNContext
+// waits at priority 0.
+func (tb *TokenBucket) WaitNPriority(ctx context.Context, n int64, prio int) error {
+	_, err := tb.waitN(ctx, n, prio)
+	return err
+}
+
+// waitN is WaitNPriority, also reporting how many tokens were taken: the
+// cost of n, or none while limiting is bypassed.
+func (tb *TokenBucket) waitN(ctx context.Context, n int64, prio int) (int64, error) {
+	if n < 0 {
+		return 0, ErrNegativeTokens
+	}
+	if tb.unset() {
+		return 0, nil
+	}
+
+	tb.mu.Lock()
+	if tb.draining {
+		tb.mu.Unlock()
+		return 0, ErrDraining
+	}
+	tb.accrueLocked()
+	n = tb.costLocked(n)
+	if !tb.bypassLocked() && n > tb.capacity {
+		err := &ExceedsCapacityError{N: n, Capacity: tb.capacity}
+		tb.mu.Unlock()
+		return 0, err
+	}
+	if len(tb.waiters) == 0 && (tb.bypassLocked() || n == 0 || tb.tokens >= n && tb.spacedLocked()) {
+		charged, ok := tb.takeChargedLocked(n)
+		tb.mu.Unlock()
+		if !ok {
+			return 0, ErrStopped
+		}
+		return charged, nil
+	}
+	if tb.stopped {
+		tb.mu.Unlock()
+		return 0, ErrStopped
+	}
+	if err := ctx.Err(); err != nil {
+		tb.mu.Unlock()
+		return 0, err
+	}
+	if tb.spillAt > 0 && len(tb.waiters) >= tb.spillAt {
+		charged, err := tb.spillLocked(n)
+		tb.mu.Unlock()
+		return charged, err
+	}
+	w := newWaiter(n)
+	w.prio = prio
+	w.enqueued = tb.clock.Now()
+	tb.enqueueLocked(w)
+	if len(tb.waiters) > tb.waitStats.MaxWaiting {
+		tb.waitStats.MaxWaiting = len(tb.waiters)
+	}
+	// It may have gone ahead of a head that was blocking it.
+	tb.serveWaitersLocked()
+	tb.mu.Unlock()
+
+	if err := tb.await(ctx, w); err != nil {
+		return 0, err
+	}
+	return w.charged, nil
+}
+
+// WaitNChunked is WaitNContext for totals that may exceed the bucket's
+// capacity. Rather than failing with ErrTokensExceedCapacity, it takes n in
+// capacity-sized chunks, each one waited for in turn, so a large transfer
+// is paced at the refill rate instead of rejected. If ctx is done or the
+// bucket stops partway, the tokens already charged for earlier chunks are
+// refunded and the error returned. A bucket with no capacity has no chunk
+// size and fails with an *ExceedsCapacityError.
+func (tb *TokenBucket) WaitNChunked(ctx context.Context, n int64) error {
+	if n < 0 {
+		return ErrNegativeTokens
+	}
+	if tb.unset() {
+		return nil
+	}
+	var taken, charged int64
+	for taken < n {
+		capacity := tb.Config().Capacity
+		if capacity <= 0 {
+			tb.Refund(charged)
+			return &ExceedsCapacityError{N: n - taken, Capacity: capacity}
+		}
+		chunk := min(n-taken, capacity)
+		c, err := tb.waitN(ctx, chunk, 0)
+		if err != nil {
+			tb.Refund(charged)
+			return err
+		}
+		taken += chunk
+		charged += c
+	}
+	return nil
+}
+
+// WaitForTokens blocks until at least target tokens are in the bucket, or ctx
+// is done, without consuming any. It lets a caller hold off until enough
+// budget has built up for a bulk AllowN. A target above capacity can never
+// be reached and fails with ErrTokensExceedCapacity.
+func (tb *TokenBucket) WaitForTokens(ctx context.Context, target int64) error {
+	if tb.unset() {
+		return nil
+	}
+	tb.mu.Lock()
+	if tb.draining {
+		tb.mu.Unlock()
+		return ErrDraining
+	}
+	tb.accrueLocked()
+	if !tb.bypassLocked() && target > tb.capacity {
+		err := &ExceedsCapacityError{N: target, Capacity: tb.capacity}
+		tb.mu.Unlock()
+		return err
+	}
+	if tb.bypassLocked() || tb.tokens >= target {
+		tb.mu.Unlock()
+		return nil
+	}
+	if tb.stopped {
+		tb.mu.Unlock()
+		return ErrStopped
+	}
+	if err := ctx.Err(); err != nil {
+		tb.mu.Unlock()
+		return err
+	}
+	w := newWaiter(target)
+	w.level = true
+	tb.watchers = append(tb.watchers, w)
+	tb.mu.Unlock()
+
+	return tb.await(ctx, w)
+}
+
+// await parks a queued waiter until it is resolved or ctx is done. Besides
+// being woken by refills, it sleeps until its tokens are due so that tokens
+// released between refills are picked up promptly.
+func (tb *TokenBucket) await(ctx context.Context, w *waiter) error {
+	for {
+		tb.mu.Lock()
+		tb.accrueLocked()
+		// Nothing else wakes the queue when only the spacing held it up.
+		tb.serveWaitersLocked()
+		if w.done {
+			tb.mu.Unlock()
+			return w.err
+		}
+		d := tb.timeUntilTakeLocked(w.n)
+		if w.level {
+			d = tb.timeUntilLocked(w.n)
+		}
+		var due chan struct{}
+		stop := func() bool { return false }
+		if d > 0 && d != InfDuration {
+			due = make(chan struct{})
+			// Served from the timer as well, so that on a ManualClock the
+			// tokens are handed out within the Advance that releases them.
+			stop = runAt(tb.clock, tb.clock.Now().Add(d), func() {
+				tb.mu.Lock()
+				tb.accrueLocked()
+				tb.serveWaitersLocked()
+				tb.mu.Unlock()
+				close(due)
+			})
+		}
+		tb.mu.Unlock()
+
+		select {
+		case <-w.ready:
+			stop()
+			return w.err
+		case <-due:
+		case <-ctx.Done():
+			stop()
+			tb.mu.Lock()
+			defer tb.mu.Unlock()
+
+			if w.done {
+				return w.err
+			}
+			tb.removeWaiterLocked(w)
+			return ctx.Err()
+		}
+	}
+}
+
+// enqueueLocked queues w behind every waiter of the same or higher
+// priority.
+func (tb *TokenBucket) enqueueLocked(w *waiter) {
+	i := len(tb.waiters)
+	for i > 0 && tb.waiters[i-1].prio < w.prio {
+		i--
+	}
+	tb.waiters = slices.Insert(tb.waiters, i, w)
+}
+
+// serveWaitersLocked hands tokens to queued waiters, in queue order, for as
+// long as the waiter at the head of the queue can be satisfied.
+func (tb *TokenBucket) serveWaitersLocked() {
+	for len(tb.waiters) > 0 {
+		w := tb.waiters[0]
+		if !tb.bypassLocked() && (tb.tokens < w.n || !tb.spacedLocked()) {
+			break
+		}
+		w.charged, _ = tb.takeChargedLocked(w.n)
+		w.resolveLocked(nil)
+		tb.waitDoneLocked(w)
+		tb.waiters[0] = nil
+		tb.waiters = tb.waiters[1:]
+	}
+	tb.signalDrainedLocked()
+}
+
+// takeChargedLocked is takeLocked(n, 0), also reporting how many tokens it
+// actually took, which is none when limiting is bypassed or failing open.
+func (tb *TokenBucket) takeChargedLocked(n int64) (charged int64, ok bool) {
+	before := tb.consumed
+	ok = tb.takeLocked(n, 0)
+	return tb.consumed - before, ok
+}
+
+// notifyWatchersLocked releases WaitForTokens callers whose level has been
+// reached.
+func (tb *TokenBucket) notifyWatchersLocked() {
+	kept := tb.watchers[:0]
+	for _, w := range tb.watchers {
+		if tb.bypassLocked() || tb.tokens >= w.n {
+			w.resolveLocked(nil)
+		} else {
+			kept = append(kept, w)
+		}
+	}
+	for i := len(kept); i < len(tb.watchers); i++ {
+		tb.watchers[i] = nil
+	}
+	tb.watchers = kept
+	tb.signalDrainedLocked()
+}
+
+func (tb *TokenBucket) failWaitersLocked(err error) {
+	for _, w := range tb.waiters {
+		w.resolveLocked(err)
+		tb.waitDoneLocked(w)
+	}
+	for _, w := range tb.watchers {
+		w.resolveLocked(err)
+	}
+	tb.waiters = nil
+	tb.watchers = nil
+	tb.signalDrainedLocked()
+}
+
+// failOversizedWaitersLocked fails the waiters that can never be satisfied
+// now that capacity has shrunk below what they asked for.
+func (tb *TokenBucket) failOversizedWaitersLocked() {
+	for _, q := range []*[]*waiter{&tb.waiters, &tb.watchers} {
+		kept := (*q)[:0]
+		for _, w := range *q {
+			if w.n <= tb.capacity {
+				kept = append(kept, w)
+				continue
+			}
+			w.resolveLocked(&ExceedsCapacityError{N: w.n, Capacity: tb.capacity})
+			if !w.level {
+				tb.waitDoneLocked(w)
+			}
+		}
+		clear((*q)[len(kept):])
+		*q = kept
+	}
+}
+
+func (tb *TokenBucket) removeWaiterLocked(w *waiter) {
+	tb.watchers = removeWaiter(tb.watchers, w)
+	n := len(tb.waiters)
+	if tb.waiters = removeWaiter(tb.waiters, w); len(tb.waiters) < n {
+		tb.waitDoneLocked(w)
+	}
+	// The head may have been the only thing blocking cheaper waiters behind it.
+	tb.serveWaitersLocked()
+}
+
+func removeWaiter(q []*waiter, w *waiter) []*waiter {
+	for i, x := range q {
+		if x == w {
+			return append(q[:i], q[i+1:]...)
+		}
+	}
+	return q
+}
+
+func (tb *TokenBucket) waitDoneLocked(w *waiter) {
+	d := tb.clock.Now().Sub(w.enqueued)
+	tb.waitStats.Completed++
+	tb.waitStats.TotalWait += d
+	if d > tb.waitStats.MaxWait {
+		tb.waitStats.MaxWait = d
+	}
+}
+
+func (tb *TokenBucket) waitStatsLocked() WaitStats {
+	s := tb.waitStats
+	s.Waiting = len(tb.waiters)
+	return s
+}
+
+func (s WaitStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Waiting    int    `json:"waiting"`
+		MaxWaiting int    `json:"max_waiting"`
+		Completed  int64  `json:"completed"`
+		TotalWait  string `json:"total_wait"`
+		MaxWait    string `json:"max_wait"`
+	}{s.Waiting, s.MaxWaiting, s.Completed, durationString(s.TotalWait), durationString(s.MaxWait)})
+}
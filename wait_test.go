@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForWatchers waits until n callers are blocked in WaitForTokens on tb.
+func waitForWatchers(t *testing.T, tb *TokenBucket, n int) {
+	t.Helper()
+	waitFor(t, func() bool {
+		tb.mu.Lock()
+		defer tb.mu.Unlock()
+
+		return len(tb.watchers) == n
+	})
+}
+
+func TestWaitForTokensWakesAtTarget(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 5, time.Second, WithClock(clock))
+	defer tb.Stop()
+	tb.AllowN(5)
+
+	done := make(chan error, 1)
+	go func() { done <- tb.WaitForTokens(context.Background(), 3) }()
+	waitForWatchers(t, tb, 1)
+
+	clock.Advance(2 * time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("WaitForTokens returned %v with 2 of 3 tokens", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("WaitForTokens = %v, want nil", err)
+	}
+	if got := tb.AvailableTokens(); got != 3 {
+		t.Fatalf("%d tokens after the wait, want 3 left unconsumed", got)
+	}
+}
+
+func TestWaitForTokensRejectsTargetAboveCapacity(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour)
+	defer tb.Stop()
+
+	if err := tb.WaitForTokens(context.Background(), 6); !errors.Is(err, ErrTokensExceedCapacity) {
+		t.Fatalf("WaitForTokens(6) = %v, want ErrTokensExceedCapacity", err)
+	}
+}
+
+func TestWaitForTokensCancelled(t *testing.T) {
+	tb := NewTokenBucket(1, 5, time.Hour)
+	defer tb.Stop()
+	tb.AllowN(5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- tb.WaitForTokens(ctx, 1) }()
+	waitForWatchers(t, tb, 1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("WaitForTokens = %v, want context.Canceled", err)
+	}
+	waitForWatchers(t, tb, 0)
+}
+
+func TestWaitStatsTrackQueue(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Second, WithClock(clock))
+	defer tb.Stop()
+	tb.Allow()
+
+	done := make(chan error, 3)
+	for i := 1; i <= 3; i++ {
+		go func() { done <- tb.Wait() }()
+		waitForQueue(t, tb, i)
+	}
+	if s := tb.Stats().Waits; s.Waiting != 3 || s.MaxWaiting != 3 {
+		t.Fatalf("Waits = %+v, want 3 waiting and a max of 3", s)
+	}
+
+	for i := 2; i >= 0; i-- {
+		clock.Advance(time.Second)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if got := tb.Stats().Waits.Waiting; got != i {
+			t.Fatalf("Waiting = %d, want %d", got, i)
+		}
+	}
+
+	want := WaitStats{MaxWaiting: 3, Completed: 3, TotalWait: 6 * time.Second, MaxWait: 3 * time.Second}
+	if got := tb.Stats().Waits; got != want {
+		t.Fatalf("Waits = %+v, want %+v", got, want)
+	}
+	if got := want.MeanWait(); got != 2*time.Second {
+		t.Fatalf("MeanWait() = %v, want 2s", got)
+	}
+}
+
+func TestWaitNPriorityServesHighFirst(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Second, WithClock(clock))
+	defer tb.Stop()
+	tb.Allow()
+
+	served := make(chan string, 5)
+	cancelled, cancel := context.WithCancel(context.Background())
+	waiters := []struct {
+		name string
+		ctx  context.Context
+		prio int
+	}{
+		{"low1", context.Background(), 0},
+		{"low2", cancelled, 0},
+		{"low3", context.Background(), 0},
+		{"high1", context.Background(), 1},
+		{"high2", context.Background(), 1},
+	}
+	for i, w := range waiters {
+		go func() {
+			if err := tb.WaitNPriority(w.ctx, 1, w.prio); err == nil {
+				served <- w.name
+			}
+		}()
+		waitForQueue(t, tb, i+1)
+	}
+	cancel()
+	waitForQueue(t, tb, 4)
+
+	for _, want := range []string{"high1", "high2", "low1", "low3"} {
+		clock.Advance(time.Second)
+		if got := <-served; got != want {
+			t.Fatalf("served %s, want %s", got, want)
+		}
+	}
+}
+
+func TestReconfigureFailsWaitersAboveNewCapacity(t *testing.T) {
+	tb := NewTokenBucket(1, 10, time.Hour)
+	defer tb.Stop()
+	tb.AllowN(10)
+
+	big := make(chan error, 1)
+	small := make(chan error, 1)
+	watch := make(chan error, 1)
+	go func() { big <- tb.WaitN(5) }()
+	waitForQueue(t, tb, 1)
+	go func() { small <- tb.WaitN(2) }()
+	waitForQueue(t, tb, 2)
+	go func() { watch <- tb.WaitForTokens(context.Background(), 8) }()
+	waitForWatchers(t, tb, 1)
+
+	if err := tb.Reconfigure(Config{Rate: 1, Capacity: 4, Interval: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	for name, ch := range map[string]chan error{"WaitN(5)": big, "WaitForTokens(8)": watch} {
+		select {
+		case err := <-ch:
+			if !errors.Is(err, ErrTokensExceedCapacity) {
+				t.Fatalf("%s = %v, want ErrTokensExceedCapacity", name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s still blocked after capacity dropped below it", name)
+		}
+	}
+	if got := queued(tb); got != 1 {
+		t.Fatalf("%d waiters queued, want the WaitN(2) that still fits", got)
+	}
+	tb.Refund(2)
+	if err := <-small; err != nil {
+		t.Fatalf("WaitN(2) = %v, want nil", err)
+	}
+}
+
+func TestWaitNChunkedPacesAtRefillRate(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(10, 10, time.Second, WithClock(clock))
+	defer tb.Stop()
+	tb.AllowN(10)
+
+	done := make(chan error, 1)
+	go func() { done <- tb.WaitNChunked(context.Background(), 30) }()
+	for refill := 1; refill <= 3; refill++ {
+		waitFor(t, func() bool { return queued(tb) == 1 })
+		select {
+		case err := <-done:
+			t.Fatalf("returned %v after %d refills, want 3", err, refill-1)
+		default:
+		}
+		clock.Advance(time.Second)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting after 3 refills")
+	}
+	if got := tb.TotalConsumed(); got != 40 {
+		t.Fatalf("consumed %d, want 40", got)
+	}
+}
+
+func TestWaitNChunkedRefundsChargedChunksOnCancel(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(10, 10, time.Second, WithClock(clock))
+	defer tb.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- tb.WaitNChunked(ctx, 25) }()
+	// The first chunk comes out of the full bucket; the second has to wait.
+	waitFor(t, func() bool { return queued(tb) == 1 })
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("WaitNChunked = %v, want context.Canceled", err)
+	}
+	if got := tb.AvailableTokens(); got != 10 {
+		t.Fatalf("after cancelling: %d tokens, want the first chunk's 10 back", got)
+	}
+}
+
+func TestWaitNReportsTokensCharged(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(10, 10, time.Second, WithClock(clock), WithMinCharge(4))
+	defer tb.Stop()
+	ctx := context.Background()
+
+	if charged, err := tb.waitN(ctx, 1, 0); charged != 4 || err != nil {
+		t.Fatalf("waitN(1) = %d, %v; want the minimum charge of 4", charged, err)
+	}
+	tb.Suspend()
+	if charged, err := tb.waitN(ctx, 5, 0); charged != 0 || err != nil {
+		t.Fatalf("waitN(5) while suspended = %d, %v; want nothing charged", charged, err)
+	}
+	tb.Resume()
+
+	// Served from the queue.
+	tb.AllowN(tb.AvailableTokens())
+	result := make(chan int64, 1)
+	go func() {
+		charged, _ := tb.waitN(ctx, 2, 0)
+		result <- charged
+	}()
+	waitFor(t, func() bool { return queued(tb) == 1 })
+	clock.Advance(time.Second)
+	if charged := <-result; charged != 4 {
+		t.Fatalf("queued waitN(2) charged %d, want 4", charged)
+	}
+}
+
+func TestWaitNChunkedRejectsZeroCapacity(t *testing.T) {
+	tb := NewTokenBucket(1, 0, time.Second)
+	defer tb.Stop()
+
+	done := make(chan error, 1)
+	go func() { done <- tb.WaitNChunked(context.Background(), 5) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrTokensExceedCapacity) {
+			t.Fatalf("WaitNChunked = %v, want ErrTokensExceedCapacity", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitNChunked spun on a bucket with no capacity")
+	}
+}
+
+func TestWaitNCancelledAsTokensArriveConservesTokens(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucket(1, 1, time.Second, WithClock(clock), withoutRefillLog())
+	defer tb.Stop()
+	tb.Allow()
+
+	var served, cancelled int
+	for i := 0; i < 300; i++ {
+		before := tb.TotalConsumed()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- tb.WaitNContext(ctx, 1) }()
+		waitFor(t, func() bool { return queued(tb) == 1 })
+
+		// The refill and the cancellation race for the waiter.
+		start, advanced := make(chan struct{}), make(chan struct{})
+		go func() {
+			<-start
+			clock.Advance(time.Second)
+			close(advanced)
+		}()
+		go func() {
+			<-start
+			cancel()
+		}()
+		close(start)
+		err := <-done
+
+		took := tb.TotalConsumed() - before
+		switch {
+		case err == nil && took == 1:
+			served++
+		case errors.Is(err, context.Canceled) && took == 0:
+			cancelled++
+		default:
+			t.Fatalf("iteration %d: WaitNContext returned %v having taken %d tokens", i, err, took)
+		}
+		// Empty the bucket for the next round, whoever got the token.
+		<-advanced
+		tb.Allow()
+		if got := tb.AvailableTokens(); got != 0 {
+			t.Fatalf("iteration %d: %d tokens left over, want the refilled one accounted for", i, got)
+		}
+		cancel()
+	}
+	t.Logf("served %d, cancelled %d", served, cancelled)
+}
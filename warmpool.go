@@ -0,0 +1,84 @@
+package main
+
+import (
+	"time"
+)
+
+// parkedInterval stands in for a pooled bucket's interval until it is bound
+// to a real config; its ticker is stopped throughout.
+const parkedInterval = time.Hour
+
+// WithWarmPool keeps size buckets built ahead of time, so a new key's first
+// GetOrCreate binds one from the pool instead of constructing a bucket
+// while the request waits. A background goroutine tops the pool back up as
+// buckets are taken. Pooled buckets are parked: they neither refill nor
+// accrue until they are bound to a key, which starts them full, as a fresh
+// bucket would. When the pool runs dry, buckets are built on the spot.
+func WithWarmPool(size int) ManagerOption {
+	return func(m *LimiterManager) {
+		m.warmPool = size
+	}
+}
+
+// fillPool keeps the pool full until the manager is stopped, then stops
+// whatever is left in it.
+func (m *LimiterManager) fillPool() {
+	for {
+		tb := newParkedBucket(m.clock)
+		select {
+		case m.pool <- tb:
+
+		case <-m.stop:
+			tb.Stop()
+			for {
+				select {
+				case tb := <-m.pool:
+					tb.Stop()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// takeWarm binds a pooled bucket to cfg, or returns nil if the pool is
+// empty or off.
+func (m *LimiterManager) takeWarm(cfg Config) *TokenBucket {
+	select {
+	case tb := <-m.pool:
+		tb.bind(cfg)
+		return tb
+	default:
+		return nil
+	}
+}
+
+// newParkedBucket builds a bucket with nothing to give and its refill loop
+// already running, but with its ticker stopped.
+func newParkedBucket(clock Clock) *TokenBucket {
+	tb := &TokenBucket{clock: clock, interval: parkedInterval, stop: make(chan struct{})}
+	tb.id = nextBucketID.Add(1)
+	tb.ticker = newTicker(clock, parkedInterval, tb.tick)
+	tb.ticker.Stop()
+	go tb.refill(nil)
+	return tb
+}
+
+// bind gives a parked bucket cfg and starts it, leaving it as
+// newTokenBucketFromConfig would have built it.
+func (tb *TokenBucket) bind(cfg Config) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.rate = cfg.Rate
+	tb.capacity = cfg.Capacity
+	tb.interval = cfg.Interval
+	tb.disabled = cfg.Disabled
+	tb.tokens = cfg.Capacity
+	tb.lastRefill = tb.clock.Now()
+	tb.createdAt = tb.lastRefill
+	tb.lowWater = tb.tokens
+	tb.ticker.Reset(cfg.Interval)
+	tb.publishLocked()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmPoolBindsPrebuiltBuckets(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	m := NewLimiterManager(func(string) Config { return Config{Rate: 2, Capacity: 5, Interval: time.Second} },
+		WithManagerClock(clock), WithWarmPool(3))
+	defer m.StopAll()
+	waitFor(t, func() bool { return len(m.pool) == 3 })
+
+	// Parked buckets don't refill while they wait.
+	clock.Advance(time.Hour)
+
+	built := nextBucketID.Load()
+	for _, key := range []string{"a", "b", "c"} {
+		tb := m.GetOrCreate(key)
+		if tb.id > built {
+			t.Fatalf("%s: bucket %d built on demand, want one from the pool", key, tb.id)
+		}
+		if got := tb.Config(); got != (Config{Rate: 2, Capacity: 5, Interval: time.Second}) {
+			t.Fatalf("%s: bound with %+v", key, got)
+		}
+		if got := tb.AvailableTokens(); got != 5 {
+			t.Fatalf("%s: %d tokens, want a full 5", key, got)
+		}
+	}
+
+	// A bound bucket refills like any other.
+	tb := m.GetOrCreate("a")
+	tb.AllowN(5)
+	clock.Advance(time.Second)
+	if got := tb.AvailableTokens(); got != 2 {
+		t.Fatalf("a refill after binding: %d tokens, want 2", got)
+	}
+
+	// The pool is topped back up in the background.
+	waitFor(t, func() bool { return len(m.pool) == 3 })
+}